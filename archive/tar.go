@@ -0,0 +1,171 @@
+package archive
+
+import (
+	"archive/tar"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 把目录打包为未压缩的tar流，不包含目录本身
+// overlay的whiteout和opaque属性转换为.wh.开头的文件，不记录atime和ctime，同样的内容得到同样的tar
+func Tar(dir string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, dir))
+	}()
+	return pr
+}
+
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	// 按inode记录已经写过的文件，后面的硬链接写成链接
+	links := make(map[uint64]string)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil || name == "." {
+			return err
+		}
+		return addEntry(tw, path, name, fi, links)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func addEntry(tw *tar.Writer, path, name string, fi os.FileInfo, links map[uint64]string) error {
+	stat, _ := fi.Sys().(*syscall.Stat_t)
+	if IsWhiteout(fi) {
+		return tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     filepath.Join(filepath.Dir(name), WhiteoutPrefix+fi.Name()),
+			Mode:     int64(fi.Mode().Perm()),
+			Uid:      int(stat.Uid),
+			Gid:      int(stat.Gid),
+			ModTime:  fi.ModTime().Truncate(time.Second),
+			Format:   tar.FormatPAX,
+		})
+	}
+	// tar不能保存socket
+	if fi.Mode()&os.ModeSocket != 0 {
+		return nil
+	}
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Format = tar.FormatPAX
+	hdr.ModTime = hdr.ModTime.Truncate(time.Second)
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	// 只保存数字的uid和gid，用户名在容器中没有意义
+	hdr.Uname, hdr.Gname = "", ""
+	if stat != nil {
+		hdr.Uid, hdr.Gid = int(stat.Uid), int(stat.Gid)
+		if fi.Mode().IsRegular() && stat.Nlink > 1 {
+			if first, ok := links[stat.Ino]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+			} else {
+				links[stat.Ino] = name
+			}
+		}
+	}
+	xattrs, err := listXattrs(path)
+	if err != nil {
+		return err
+	}
+	opaque := false
+	for key, value := range xattrs {
+		if key == overlayOpaqueXattr {
+			opaque = fi.IsDir() && value == "y"
+			continue
+		}
+		// overlay内部使用的属性不打包
+		if strings.HasPrefix(key, overlayXattrPrefix) {
+			continue
+		}
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string)
+		}
+		hdr.PAXRecords[xattrPAXPrefix+key] = value
+	}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeReg && hdr.Size > 0 {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if opaque {
+		return tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     filepath.Join(name, WhiteoutOpaqueDir),
+			Uid:      hdr.Uid,
+			Gid:      hdr.Gid,
+			ModTime:  hdr.ModTime,
+			Format:   tar.FormatPAX,
+		})
+	}
+	return nil
+}
+
+// 读取文件自身的扩展属性，不跟随符号链接
+func listXattrs(path string) (map[string]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err == unix.ENOTSUP || size == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(path, buf); err != nil {
+		return nil, err
+	}
+	xattrs := make(map[string]string)
+	for _, key := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if key == "" {
+			continue
+		}
+		size, err := unix.Lgetxattr(path, key, nil)
+		if err == unix.ENODATA {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size)
+		if size, err = unix.Lgetxattr(path, key, value); err != nil {
+			return nil, err
+		}
+		xattrs[key] = string(value[:size])
+	}
+	return xattrs, nil
+}
@@ -0,0 +1,255 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const xattrPAXPrefix = "SCHILY.xattr."
+
+// 根据开头的魔数识别gzip压缩，其他的按未压缩的tar处理
+func DecompressStream(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return ioutil.NopCloser(br), nil
+}
+
+// 把未压缩的tar流解压到dest，.wh.开头的文件转换为overlay的whiteout
+// 保留文件的属主、权限、扩展属性和修改时间，不允许写到dest之外
+func Untar(r io.Reader, dest string) error {
+	dest = filepath.Clean(dest)
+	tr := tar.NewReader(r)
+	// 创建子目录会修改目录的时间，目录的时间最后设置
+	var dirs []*tar.Header
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// git archive写入的pax全局头不是文件，和docker一样忽略
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader, tar.TypeXHeader, tar.TypeGNULongName, tar.TypeGNULongLink:
+			continue
+		}
+		name, err := cleanName(hdr.Name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			if hdr.Typeflag == tar.TypeDir {
+				hdr.Name = "."
+				if err = setAttrs(dest, hdr); err != nil {
+					return err
+				}
+				dirs = append(dirs, hdr)
+			}
+			continue
+		}
+		if err = mkdirParents(dest, filepath.Dir(name)); err != nil {
+			return err
+		}
+		base := filepath.Base(name)
+		if strings.HasPrefix(base, WhiteoutPrefix) {
+			if err = createWhiteout(dest, name, hdr); err != nil {
+				return err
+			}
+			continue
+		}
+		path := filepath.Join(dest, name)
+		if err = createEntry(dest, path, hdr, tr); err != nil {
+			return fmt.Errorf("extract %s: %v", hdr.Name, err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			hdr.Name = name
+			dirs = append(dirs, hdr)
+		case tar.TypeLink:
+			// 硬链接和原文件是同一个inode，不再修改属性
+		default:
+			if err = setTimes(path, hdr); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := setTimes(filepath.Join(dest, dirs[i].Name), dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 去掉开头的/，不允许..跳到上级目录
+func cleanName(name string) (string, error) {
+	cleaned := filepath.Clean("." + string(filepath.Separator) + name)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("invalid path %q: outside of the target directory", name)
+	}
+	return cleaned, nil
+}
+
+// 创建缺少的上级目录，上级目录中有符号链接时可能指向dest之外，直接报错
+func mkdirParents(dest, dir string) error {
+	if dir == "." {
+		return nil
+	}
+	path := dest
+	for _, part := range strings.Split(dir, string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+		fi, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			if err = os.Mkdir(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("invalid path %s: parent directory is a symbolic link", strings.TrimPrefix(path, dest))
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("invalid path %s: parent is not a directory", strings.TrimPrefix(path, dest))
+		}
+	}
+	return nil
+}
+
+// .wh..wh..opq给上级目录加上opaque属性，.wh.xxx创建xxx的whiteout
+func createWhiteout(dest, name string, hdr *tar.Header) error {
+	dir, base := filepath.Dir(name), filepath.Base(name)
+	switch {
+	case base == WhiteoutOpaqueDir:
+		if err := unix.Lsetxattr(filepath.Join(dest, dir), overlayOpaqueXattr, []byte("y"), 0); err != nil {
+			return fmt.Errorf("set opaque on %s: %v", dir, err)
+		}
+	case strings.HasPrefix(base, WhiteoutMetaPrefix):
+		// aufs的内部文件，忽略
+	default:
+		// .wh.和.wh..会删除所在目录或者上级目录
+		target := strings.TrimPrefix(base, WhiteoutPrefix)
+		if target == "" || target == "." || target == ".." {
+			return fmt.Errorf("invalid whiteout %q", name)
+		}
+		path := filepath.Join(dest, dir, target)
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		if err := unix.Mknod(path, unix.S_IFCHR, 0); err != nil {
+			return fmt.Errorf("create whiteout %s: %v", name, err)
+		}
+		if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createEntry(dest, path string, hdr *tar.Header, r io.Reader) error {
+	// 已经存在的文件被覆盖，目录只修改属性
+	if fi, err := os.Lstat(path); err == nil {
+		if !fi.IsDir() || hdr.Typeflag != tar.TypeDir {
+			if err = os.RemoveAll(path); err != nil {
+				return err
+			}
+		}
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(path, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg:
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		// 符号链接的目标在容器中解析，不检查
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return err
+		}
+		return os.Lchown(path, hdr.Uid, hdr.Gid)
+	case tar.TypeLink:
+		linkname, err := cleanName(hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err = mkdirParents(dest, filepath.Dir(linkname)); err != nil {
+			return err
+		}
+		return os.Link(filepath.Join(dest, linkname), path)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		mode := uint32(unix.S_IFIFO)
+		if hdr.Typeflag == tar.TypeChar {
+			mode = unix.S_IFCHR
+		} else if hdr.Typeflag == tar.TypeBlock {
+			mode = unix.S_IFBLK
+		}
+		dev := unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))
+		if err := unix.Mknod(path, mode|uint32(hdr.Mode&07777), int(dev)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported tar entry type %q", hdr.Typeflag)
+	}
+	return setAttrs(path, hdr)
+}
+
+// 先修改属主再修改权限，chown会清除setuid位
+func setAttrs(path string, hdr *tar.Header) error {
+	if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, hdr.FileInfo().Mode()); err != nil {
+		return err
+	}
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, xattrPAXPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, xattrPAXPrefix)
+		if err := unix.Lsetxattr(path, name, []byte(value), 0); err != nil && err != unix.ENOTSUP {
+			return fmt.Errorf("set xattr %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func setTimes(path string, hdr *tar.Header) error {
+	atime := hdr.AccessTime
+	if atime.IsZero() {
+		atime = hdr.ModTime
+	}
+	ts := []unix.Timespec{timespec(atime), timespec(hdr.ModTime)}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, ts, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+func timespec(t time.Time) unix.Timespec {
+	if t.IsZero() {
+		return unix.NsecToTimespec(0)
+	}
+	return unix.NsecToTimespec(t.UnixNano())
+}
@@ -0,0 +1,245 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type entry struct {
+	name     string
+	typeflag byte
+	linkname string
+	body     string
+}
+
+func makeTar(t *testing.T, entries []entry) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     0644,
+			Size:     int64(len(e.body)),
+		}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// 解压到root/dest，root中的outside用来检查是否写到了dest之外
+func tempDest(t *testing.T) (root, dest string) {
+	root, err := ioutil.TempDir("", "untar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest = filepath.Join(root, "dest")
+	if err = os.Mkdir(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(root, "outside"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return root, dest
+}
+
+func requireRoot(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating whiteouts and changing owners requires root")
+	}
+}
+
+func TestUntarRejectsEscapes(t *testing.T) {
+	requireRoot(t)
+	tests := []struct {
+		name    string
+		entries []entry
+		wantErr string
+	}{
+		{
+			name:    "dot dot path",
+			entries: []entry{{name: "../outside", typeflag: tar.TypeReg, body: "x"}},
+			wantErr: "outside of the target directory",
+		},
+		{
+			name:    "dot dot in the middle",
+			entries: []entry{{name: "a/../../outside", typeflag: tar.TypeReg, body: "x"}},
+			wantErr: "outside of the target directory",
+		},
+		{
+			name: "symlinked parent",
+			entries: []entry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "link/outside", typeflag: tar.TypeReg, body: "x"},
+			},
+			wantErr: "parent directory is a symbolic link",
+		},
+		{
+			name:    "hardlink outside",
+			entries: []entry{{name: "hard", typeflag: tar.TypeLink, linkname: "../outside"}},
+			wantErr: "outside of the target directory",
+		},
+		{
+			name:    "empty whiteout",
+			entries: []entry{{name: ".wh.", typeflag: tar.TypeReg}},
+			wantErr: "invalid whiteout",
+		},
+		{
+			name: "empty whiteout in a directory",
+			entries: []entry{
+				{name: "dir/", typeflag: tar.TypeDir},
+				{name: "dir/.wh.", typeflag: tar.TypeReg},
+			},
+			wantErr: "invalid whiteout",
+		},
+		{
+			name:    "whiteout of the parent",
+			entries: []entry{{name: ".wh..", typeflag: tar.TypeReg}},
+			wantErr: "invalid whiteout",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, dest := tempDest(t)
+			defer os.RemoveAll(root)
+			err := Untar(makeTar(t, tt.entries), dest)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Untar() error = %v, want %q", err, tt.wantErr)
+			}
+			b, err := ioutil.ReadFile(filepath.Join(root, "outside"))
+			if err != nil || string(b) != "secret" {
+				t.Errorf("file outside of dest changed: %q, %v", b, err)
+			}
+			if _, err = os.Stat(dest); err != nil {
+				t.Errorf("dest removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestUntarWhiteout(t *testing.T) {
+	requireRoot(t)
+	root, dest := tempDest(t)
+	defer os.RemoveAll(root)
+	if err := os.MkdirAll(filepath.Join(dest, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dest, "etc", "group"), []byte("root"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := Untar(makeTar(t, []entry{
+		{name: "etc/.wh.group", typeflag: tar.TypeReg},
+		{name: "opq/", typeflag: tar.TypeDir},
+		{name: "opq/.wh..wh..opq", typeflag: tar.TypeReg},
+		{name: ".wh..wh.plnk", typeflag: tar.TypeDir},
+	}), dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(filepath.Join(dest, "etc", "group"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsWhiteout(fi) {
+		t.Errorf("etc/group mode = %v, want a whiteout", fi.Mode())
+	}
+	if _, err = os.Lstat(filepath.Join(dest, "opq", WhiteoutOpaqueDir)); !os.IsNotExist(err) {
+		t.Errorf("opq/%s extracted as a file: %v", WhiteoutOpaqueDir, err)
+	}
+	if !IsOpaque(filepath.Join(dest, "opq")) {
+		t.Skip("filesystem does not support trusted xattrs")
+	}
+	if _, err = os.Lstat(filepath.Join(dest, ".wh..wh.plnk")); !os.IsNotExist(err) {
+		t.Errorf("aufs meta file extracted: %v", err)
+	}
+}
+
+// 解压后的whiteout和opaque再打包时转换回.wh.开头的文件
+func TestTarWhiteoutRoundTrip(t *testing.T) {
+	requireRoot(t)
+	root, dest := tempDest(t)
+	defer os.RemoveAll(root)
+	err := Untar(makeTar(t, []entry{
+		{name: "etc/", typeflag: tar.TypeDir},
+		{name: "etc/.wh.group", typeflag: tar.TypeReg},
+		{name: "opq/", typeflag: tar.TypeDir},
+		{name: "opq/.wh..wh..opq", typeflag: tar.TypeReg},
+		{name: "opq/file", typeflag: tar.TypeReg, body: "data"},
+	}), dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsOpaque(filepath.Join(dest, "opq")) {
+		t.Skip("filesystem does not support trusted xattrs")
+	}
+	rc := Tar(dest)
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	want := []string{"etc/", "etc/.wh.group", "opq/", "opq/.wh..wh..opq", "opq/file"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("Tar() entries = %v, want %v", names, want)
+	}
+}
+
+// git archive在开头写入pax全局头，记录提交的ID
+func TestUntarSkipsGlobalHeader(t *testing.T) {
+	requireRoot(t)
+	root, dest := tempDest(t)
+	defer os.RemoveAll(root)
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	hdrs := []*tar.Header{
+		{Name: "pax_global_header", Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "0123abcd"}},
+		{Name: "src/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "src/main.go", Typeflag: tar.TypeReg, Mode: 0644, Size: 4},
+	}
+	for _, hdr := range hdrs {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tw.Write([]byte("main")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Untar(buf, dest); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dest, "src", "main.go")); err != nil || string(b) != "main" {
+		t.Errorf("src/main.go = %q, %v", b, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "pax_global_header")); !os.IsNotExist(err) {
+		t.Errorf("pax global header extracted: %v", err)
+	}
+}
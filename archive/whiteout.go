@@ -0,0 +1,35 @@
+package archive
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+)
+
+const (
+	// 层中的.wh.xxx表示删除了下层的xxx
+	WhiteoutPrefix = ".wh."
+	// 层中的.wh..wh..opq表示所在目录不透明，下层的目录内容不可见
+	WhiteoutOpaqueDir = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+	// .wh..wh.开头的是aufs内部使用的文件
+	WhiteoutMetaPrefix = WhiteoutPrefix + WhiteoutPrefix
+
+	overlayOpaqueXattr = "trusted.overlay.opaque"
+	overlayXattrPrefix = "trusted.overlay."
+)
+
+// overlay的whiteout是设备号为0的字符设备
+func IsWhiteout(fi os.FileInfo) bool {
+	if fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+// 目录是否设置了overlay的opaque属性
+func IsOpaque(path string) bool {
+	value := make([]byte, 1)
+	n, err := unix.Lgetxattr(path, overlayOpaqueXattr, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
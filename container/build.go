@@ -0,0 +1,354 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"mydocker/image"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// build的参数
+type BuildOptions struct {
+	ContextDir string
+	// Dockerfile的路径，为空时使用上下文目录下的Dockerfile
+	Dockerfile string
+	Tags       []string
+	BuildArgs  map[string]string
+	NoCache    bool
+	// 在前台运行RUN的临时容器，直到容器退出
+	Run func(info *ContainerInfo) error
+}
+
+type builder struct {
+	opts       BuildOptions
+	contextDir string
+	// 当前这一步的镜像和它的配置
+	image  *image.LocalImage
+	config *image.Config
+	// FROM之前声明的ARG只在FROM中可见
+	globalArgs map[string]string
+	args       map[string]string
+	argNames   []string
+	usedArgs   map[string]bool
+	stageName  string
+	stages     map[string]image.Digest
+	// 当前阶段中是否已经有CMD指令
+	cmdSet bool
+}
+
+// 按Dockerfile逐条执行指令，每一步生成一个镜像，返回最后的镜像ID
+func Build(opts BuildOptions) (image.Digest, error) {
+	contextDir, err := filepath.Abs(opts.ContextDir)
+	if err == nil {
+		contextDir, err = filepath.EvalSymlinks(contextDir)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to prepare context: %v", err)
+	}
+	dockerfile := opts.Dockerfile
+	if dockerfile == "" {
+		dockerfile = filepath.Join(contextDir, "Dockerfile")
+	}
+	f, err := os.Open(dockerfile)
+	if err != nil {
+		return "", fmt.Errorf("unable to prepare context: %v", err)
+	}
+	instructions, err := image.ParseDockerfile(f)
+	f.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s: %v", dockerfile, err)
+	}
+	b := &builder{
+		opts:       opts,
+		contextDir: contextDir,
+		globalArgs: make(map[string]string),
+		usedArgs:   make(map[string]bool),
+		stages:     make(map[string]image.Digest),
+	}
+	for i, instruction := range instructions {
+		fmt.Printf("Step %d/%d : %s\n", i+1, len(instructions), instruction)
+		if err = b.dispatch(instruction); err != nil {
+			return "", err
+		}
+		if b.image != nil {
+			fmt.Printf(" ---> %s\n", b.image.Id.ShortID())
+		}
+	}
+	if b.image == nil {
+		return "", errors.New("no image was generated, is your Dockerfile empty?")
+	}
+	var unused []string
+	for name := range opts.BuildArgs {
+		if !b.usedArgs[name] {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		fmt.Printf("[Warning] One or more build-args %v were not consumed\n", unused)
+	}
+	id := b.image.Id
+	fmt.Printf("Successfully built %s\n", id.ShortID())
+	for _, tag := range opts.Tags {
+		if err = imageStore.Tag(tag, id); err != nil {
+			return "", err
+		}
+		fmt.Printf("Successfully tagged %s\n", image.NormalizeReference(tag))
+	}
+	return id, nil
+}
+
+func (b *builder) dispatch(instruction image.Instruction) error {
+	var err error
+	switch {
+	case instruction.Name == "ARG":
+		err = b.declareArgs(instruction.Args)
+	case instruction.Name == "FROM":
+		err = b.from(instruction.Args)
+	case b.image == nil:
+		err = fmt.Errorf("%s before FROM", instruction.Name)
+	case instruction.Name == "RUN":
+		// 命令的错误原样返回
+		return b.run(instruction.Args)
+	case instruction.Name == "COPY" || instruction.Name == "ADD":
+		err = b.copy(instruction)
+	default:
+		err = b.change(instruction)
+	}
+	if err != nil {
+		return fmt.Errorf("line %d: %v", instruction.Line, err)
+	}
+	return nil
+}
+
+// 只修改镜像配置的指令，生成的镜像没有新的层
+func (b *builder) change(instruction image.Instruction) error {
+	args := instruction.Args
+	// 命令在容器中由shell展开变量
+	if instruction.Name != "CMD" && instruction.Name != "ENTRYPOINT" {
+		var err error
+		if args, err = image.Expand(args, b.lookup); err != nil {
+			return err
+		}
+	}
+	change := instruction.Name + " " + args
+	config := b.config.Copy()
+	if err := image.ApplyChange(config, change); err != nil {
+		return err
+	}
+	// 和docker一致，ENTRYPOINT会清除从基础镜像继承的CMD
+	switch instruction.Name {
+	case "CMD":
+		b.cmdSet = true
+	case "ENTRYPOINT":
+		if !b.cmdSet {
+			config.Cmd = nil
+		}
+	}
+	if config.StopSignal != "" {
+		if _, err := ParseSignal(config.StopSignal); err != nil {
+			return err
+		}
+	}
+	return b.commit(change, "", func() (image.Digest, error) {
+		return imageStore.CreateChildImage(b.image, config, nil, "", image.History{CreatedBy: "/bin/sh -c #(nop) " + change})
+	})
+}
+
+// ARG name[=default]，--build-arg指定的值优先
+func (b *builder) declareArgs(args string) error {
+	lookup := b.lookup
+	if b.image == nil {
+		lookup = b.globalLookup
+	}
+	expanded, err := image.Expand(args, lookup)
+	if err != nil {
+		return err
+	}
+	words, err := image.SplitWords(expanded)
+	if err != nil {
+		return err
+	}
+	for _, word := range words {
+		kv := strings.SplitN(word, "=", 2)
+		name := kv[0]
+		if name == "" {
+			return fmt.Errorf("ARG requires a name, got %q", word)
+		}
+		value, ok := b.opts.BuildArgs[name]
+		if ok {
+			b.usedArgs[name] = true
+		} else if len(kv) == 2 {
+			value, ok = kv[1], true
+		} else if b.image != nil {
+			// 在FROM之后重新声明的全局ARG使用全局的值
+			value, ok = b.globalArgs[name]
+		}
+		if b.image == nil {
+			if ok {
+				b.globalArgs[name] = value
+			}
+			continue
+		}
+		if !b.declared(name) {
+			b.argNames = append(b.argNames, name)
+		}
+		if ok {
+			b.args[name] = value
+		}
+	}
+	return nil
+}
+
+func (b *builder) declared(name string) bool {
+	for _, declared := range b.argNames {
+		if declared == name {
+			return true
+		}
+	}
+	return false
+}
+
+// FROM image [AS name]，image可以是前面的阶段的名字，scratch表示空镜像
+func (b *builder) from(args string) error {
+	expanded, err := image.Expand(args, b.globalLookup)
+	if err != nil {
+		return err
+	}
+	words := strings.Fields(expanded)
+	if len(words) != 1 && (len(words) != 3 || !strings.EqualFold(words[1], "AS")) {
+		return fmt.Errorf("FROM requires either one or three arguments: FROM <image> [AS <name>]")
+	}
+	if b.image != nil && b.stageName != "" {
+		b.stages[b.stageName] = b.image.Id
+	}
+	b.stageName = ""
+	if len(words) == 3 {
+		b.stageName = strings.ToLower(words[2])
+	}
+	b.args = make(map[string]string)
+	b.argNames = nil
+	b.cmdSet = false
+	ref := words[0]
+	var id image.Digest
+	if stage, ok := b.stages[strings.ToLower(ref)]; ok {
+		id = stage
+	} else if ref == "scratch" {
+		if id, err = imageStore.CreateImage(image.Scratch(), nil); err != nil {
+			return err
+		}
+	} else {
+		// 本地没有时从仓库拉取
+		if _, err = imageStore.Lookup(ref); err != nil {
+			if err = PullImage(ref); err != nil {
+				return err
+			}
+		}
+		img, err := imageStore.Lookup(ref)
+		if err != nil {
+			return err
+		}
+		id = img.Id
+	}
+	return b.setImage(id)
+}
+
+func (b *builder) setImage(id image.Digest) error {
+	img, err := imageStore.GetImage(id)
+	if err != nil {
+		return err
+	}
+	b.image = img
+	b.config = img.Config.Config.Copy()
+	return nil
+}
+
+// 先查找缓存，没有缓存时由create生成这一步的镜像
+func (b *builder) commit(instruction string, content image.Digest, create func() (image.Digest, error)) error {
+	key := image.BuildCacheKey(b.image.Id, instruction, content)
+	if !b.opts.NoCache {
+		if id, ok := imageStore.GetBuildCache(key); ok {
+			fmt.Println(" ---> Using cache")
+			return b.setImage(id)
+		}
+	}
+	id, err := create()
+	if err != nil {
+		return err
+	}
+	if err = imageStore.SetBuildCache(key, id); err != nil {
+		return err
+	}
+	return b.setImage(id)
+}
+
+// 变量先从ENV中查找，再从ARG中查找
+func (b *builder) lookup(name string) (string, bool) {
+	if b.config != nil {
+		for _, kv := range b.config.Env {
+			if strings.HasPrefix(kv, name+"=") {
+				return kv[len(name)+1:], true
+			}
+		}
+	}
+	value, ok := b.args[name]
+	return value, ok
+}
+
+// FROM之前只能引用全局的ARG
+func (b *builder) globalLookup(name string) (string, bool) {
+	value, ok := b.globalArgs[name]
+	return value, ok
+}
+
+// ARG作为RUN的环境变量，不会保存到镜像中，和ENV同名时以ENV为准
+func (b *builder) argEnv() []string {
+	var env []string
+	for _, name := range b.argNames {
+		value, ok := b.args[name]
+		if !ok || lookupEnv(b.config.Env, name) != "" {
+			continue
+		}
+		env = append(env, name+"="+value)
+	}
+	return env
+}
+
+// 在临时容器中执行命令，把容器的修改提交为新的一层
+func (b *builder) run(args string) error {
+	argv := image.ParseCommand(args)
+	env := b.argEnv()
+	createdBy := strings.Join(argv, " ")
+	if len(env) > 0 {
+		createdBy = fmt.Sprintf("|%d %s %s", len(env), strings.Join(env, " "), createdBy)
+	}
+	return b.commit(createdBy, "", func() (image.Digest, error) {
+		// 空的Entrypoint表示不使用镜像的ENTRYPOINT
+		info := &ContainerInfo{
+			Image:  b.image.Id.String(),
+			Config: &image.Config{Entrypoint: []string{}, Cmd: argv, Env: env},
+		}
+		if err := RecordContainerInfo(info); err != nil {
+			return "", err
+		}
+		fmt.Printf(" ---> Running in %s\n", ShortID(info.Id))
+		defer func() {
+			DeleteContainerInfo(info.Id)
+			fmt.Printf("Removing intermediate container %s\n", ShortID(info.Id))
+		}()
+		if err := b.opts.Run(info); err != nil {
+			return "", err
+		}
+		if info.ExitCode != 0 {
+			return "", fmt.Errorf("the command '%s' returned a non-zero code: %d", strings.Join(argv, " "), info.ExitCode)
+		}
+		layer, diffID, err := archiveUpper(info)
+		if err != nil {
+			return "", err
+		}
+		return imageStore.CreateChildImage(b.image, b.config.Copy(), &layer, diffID, image.History{CreatedBy: createdBy})
+	})
+}
@@ -0,0 +1,389 @@
+package container
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mydocker/image"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 上下文中的一个源文件，name是匹配到的路径，path是解析了符号链接后的路径
+type contextFile struct {
+	name string
+	path string
+}
+
+// COPY和ADD把上下文中的文件打包为一层，文件的内容和属性的摘要作为缓存的key
+func (b *builder) copy(instruction image.Instruction) error {
+	var flags []string
+	chown := ""
+	for _, flag := range instruction.Flags {
+		expanded, err := image.Expand(flag, b.lookup)
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(expanded, "--chown="):
+			chown = strings.TrimPrefix(expanded, "--chown=")
+		case strings.HasPrefix(expanded, "--from="):
+			return fmt.Errorf("%s --from is not supported", instruction.Name)
+		default:
+			return fmt.Errorf("unknown flag: %s", flag)
+		}
+		flags = append(flags, expanded)
+	}
+	args, err := image.Expand(instruction.Args, b.lookup)
+	if err != nil {
+		return err
+	}
+	var words []string
+	if !strings.HasPrefix(args, "[") || json.Unmarshal([]byte(args), &words) != nil {
+		if words, err = image.SplitWords(args); err != nil {
+			return err
+		}
+	}
+	if len(words) < 2 {
+		return fmt.Errorf("%s requires at least two arguments", instruction.Name)
+	}
+	// 查找目标路径和用户需要镜像的层已经解压
+	if _, err = imageStore.PrepareLayers(b.image); err != nil {
+		return err
+	}
+	dest := words[len(words)-1]
+	destIsDir := strings.HasSuffix(dest, "/") || dest == "."
+	if !path.IsAbs(dest) {
+		dest = path.Join("/", b.config.WorkingDir, dest)
+	}
+	dest = path.Clean(dest)
+	if !destIsDir {
+		if fi := b.statImagePath(dest); fi != nil && fi.IsDir() {
+			destIsDir = true
+		}
+	}
+	var sources []contextFile
+	for _, src := range words[:len(words)-1] {
+		if instruction.Name == "ADD" && strings.Contains(src, "://") {
+			return fmt.Errorf("ADD from remote URL %s is not supported", src)
+		}
+		files, err := b.contextFiles(src)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, files...)
+	}
+	if len(sources) > 1 && !destIsDir {
+		return fmt.Errorf("when using %s with more than one source file, the destination must be a directory and end with a /", instruction.Name)
+	}
+
+	w, err := newLayerWriter()
+	if err != nil {
+		return err
+	}
+	defer w.remove()
+	if chown != "" {
+		if w.uid, w.gid, err = b.resolveChown(chown); err != nil {
+			return err
+		}
+		w.chown = true
+	}
+	for _, src := range sources {
+		fi, err := os.Stat(src.path)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			err = w.addDir(src.path, dest, b.statImagePath(dest) == nil)
+		} else {
+			extracted := false
+			// ADD会解压本地的tar包
+			if instruction.Name == "ADD" {
+				extracted, err = w.addArchive(src.path, dest)
+			}
+			if err == nil && !extracted {
+				target := dest
+				if destIsDir {
+					target = path.Join(dest, filepath.Base(src.name))
+				}
+				err = w.addFile(src.path, target, fi)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	content, err := w.close()
+	if err != nil {
+		return err
+	}
+	text := strings.Join(append(append([]string{instruction.Name}, flags...), args), " ")
+	createdBy := fmt.Sprintf("/bin/sh -c #(nop) %s %s in %s ", instruction.Name, content, dest)
+	return b.commit(text, content, func() (image.Digest, error) {
+		f, err := os.Open(w.file.Name())
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		layer, diffID, err := imageStore.PutLayer(f)
+		if err != nil {
+			return "", err
+		}
+		return imageStore.CreateChildImage(b.image, b.config.Copy(), &layer, diffID, image.History{CreatedBy: createdBy})
+	})
+}
+
+// 返回上下文中匹配src的文件，src可以有通配符，不允许引用上下文之外的文件
+func (b *builder) contextFiles(src string) ([]contextFile, error) {
+	pattern := filepath.Join(b.contextDir, filepath.Clean("/"+src))
+	matches := []string{pattern}
+	if strings.ContainsAny(src, "*?[") {
+		var err error
+		if matches, err = filepath.Glob(pattern); err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no source files were specified: %s", src)
+		}
+	}
+	var files []contextFile
+	for _, match := range matches {
+		real, err := filepath.EvalSymlinks(match)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("file not found in build context: %s", src)
+			}
+			return nil, err
+		}
+		if real != b.contextDir && !strings.HasPrefix(real, b.contextDir+"/") {
+			return nil, fmt.Errorf("forbidden path outside the build context: %s", src)
+		}
+		files = append(files, contextFile{name: match, path: real})
+	}
+	return files, nil
+}
+
+func (b *builder) statImagePath(p string) os.FileInfo {
+	if hostPath := imageStore.LookupPath(b.image, p); hostPath != "" {
+		if fi, err := os.Stat(hostPath); err == nil {
+			return fi
+		}
+	}
+	return nil
+}
+
+// --chown=user[:group]，没有指定组时gid和uid相同
+func (b *builder) resolveChown(spec string) (int, int, error) {
+	execUser, err := lookupExecUser(spec, imageStore.LookupPath(b.image, "/etc/passwd"), imageStore.LookupPath(b.image, "/etc/group"))
+	if err != nil {
+		return 0, 0, err
+	}
+	if !strings.Contains(spec, ":") {
+		return execUser.Uid, execUser.Uid, nil
+	}
+	return execUser.Uid, execUser.Gid, nil
+}
+
+// 生成层的tar包，写到临时文件中，同时计算内容的摘要，摘要不包含修改时间
+type layerWriter struct {
+	file *os.File
+	tw   *tar.Writer
+	hash hash.Hash
+	// chown为false时，上下文中的文件属于root，tar包中的文件保留原来的属主
+	chown    bool
+	uid, gid int
+}
+
+func newLayerWriter() (*layerWriter, error) {
+	f, err := ioutil.TempFile("", "mydocker-build-")
+	if err != nil {
+		return nil, err
+	}
+	return &layerWriter{file: f, tw: tar.NewWriter(f), hash: sha256.New()}, nil
+}
+
+func (w *layerWriter) remove() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+func (w *layerWriter) close() (image.Digest, error) {
+	if err := w.tw.Close(); err != nil {
+		return "", err
+	}
+	if err := w.file.Close(); err != nil {
+		return "", err
+	}
+	return image.Digest("sha256:" + hex.EncodeToString(w.hash.Sum(nil))), nil
+}
+
+// 写入一项，hdr.Name是容器中的路径，不记录atime、ctime和属主的名字
+func (w *layerWriter) write(hdr *tar.Header, r io.Reader) error {
+	name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+	if name == "" {
+		return nil
+	}
+	hdr.Name = name
+	if hdr.Typeflag == tar.TypeDir {
+		hdr.Name += "/"
+	}
+	hdr.Uname, hdr.Gname = "", ""
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	var xattrs []string
+	for key, value := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "SCHILY.xattr.") {
+			xattrs = append(xattrs, key+"="+value)
+		}
+	}
+	sort.Strings(xattrs)
+	fmt.Fprintf(w.hash, "%s\x00%c\x00%o\x00%d\x00%d\x00%s\x00%d\x00%d\x00%d\x00%s\n", hdr.Name, hdr.Typeflag,
+		hdr.Mode, hdr.Uid, hdr.Gid, hdr.Linkname, hdr.Devmajor, hdr.Devminor, hdr.Size, strings.Join(xattrs, "\x00"))
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if r != nil {
+		if _, err := io.Copy(io.MultiWriter(w.tw, w.hash), r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 写入上下文中的一个文件或者目录本身
+func (w *layerWriter) addFile(src, target string, fi os.FileInfo) error {
+	if fi.Mode()&os.ModeSocket != 0 {
+		return nil
+	}
+	link := ""
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(src); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = target
+	hdr.Uid, hdr.Gid = 0, 0
+	if w.chown {
+		hdr.Uid, hdr.Gid = w.uid, w.gid
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return w.write(hdr, nil)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return w.write(hdr, f)
+}
+
+// 复制目录中的内容，目标目录不存在时按源目录的属性创建
+func (w *layerWriter) addDir(src, dest string, createDest bool) error {
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." && !createDest {
+			return nil
+		}
+		return w.addFile(p, path.Join(dest, filepath.ToSlash(rel)), fi)
+	})
+}
+
+// 源文件是tar包(可以用gzip或bzip2压缩)时解压到目标目录，不是tar包时返回false
+func (w *layerWriter) addArchive(src, dest string) (bool, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	magic, _ := br.Peek(3)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return false, nil
+		}
+		defer gz.Close()
+		r = gz
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		r = bzip2.NewReader(br)
+	}
+	// 按ustar头中的magic判断是不是tar包
+	tarReader := bufio.NewReaderSize(r, 512)
+	header, _ := tarReader.Peek(512)
+	if len(header) < 512 || !bytes.Equal(header[257:262], []byte("ustar")) {
+		return false, nil
+	}
+	tr := tar.NewReader(tarReader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return true, fmt.Errorf("extract %s: %v", filepath.Base(src), err)
+		}
+		// 路径限制在目标目录中，不能通过..写到外面
+		entry := &tar.Header{
+			Name:       path.Join(dest, path.Clean("/"+hdr.Name)),
+			Typeflag:   hdr.Typeflag,
+			Mode:       hdr.Mode,
+			Uid:        hdr.Uid,
+			Gid:        hdr.Gid,
+			Size:       hdr.Size,
+			ModTime:    hdr.ModTime,
+			Linkname:   hdr.Linkname,
+			Devmajor:   hdr.Devmajor,
+			Devminor:   hdr.Devminor,
+			PAXRecords: make(map[string]string),
+		}
+		for key, value := range hdr.PAXRecords {
+			if strings.HasPrefix(key, "SCHILY.xattr.") {
+				entry.PAXRecords[key] = value
+			}
+		}
+		if w.chown {
+			entry.Uid, entry.Gid = w.uid, w.gid
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			err = w.write(entry, tr)
+		case tar.TypeLink:
+			entry.Linkname = path.Join(dest, path.Clean("/"+hdr.Linkname))
+			entry.Linkname = strings.TrimPrefix(entry.Linkname, "/")
+			err = w.write(entry, nil)
+		case tar.TypeDir, tar.TypeSymlink, tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			if path.Clean("/"+hdr.Name) == "/" {
+				continue
+			}
+			err = w.write(entry, nil)
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+}
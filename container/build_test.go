@@ -0,0 +1,50 @@
+package container
+
+import (
+	"io/ioutil"
+	"mydocker/image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildGlobalArgs(t *testing.T) {
+	root, err := ioutil.TempDir("", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	saved := imageStore
+	imageStore = image.New(filepath.Join(root, "image"))
+	defer func() { imageStore = saved }()
+
+	// 全局ARG引用前面的全局ARG，并在FROM和重新声明后使用
+	dockerfile := `ARG A=1
+ARG B=stage$A
+ARG C
+FROM scratch AS stage1
+FROM $B
+ARG B
+ARG C
+LABEL b=$B c=$C
+`
+	contextDir := filepath.Join(root, "context")
+	if err = os.Mkdir(contextDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte(dockerfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	id, err := Build(BuildOptions{ContextDir: contextDir, BuildArgs: map[string]string{"C": "x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := imageStore.GetImage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := img.Config.Config.Labels
+	if labels["b"] != "stage1" || labels["c"] != "x" {
+		t.Errorf("labels = %v, want b=stage1 c=x", labels)
+	}
+}
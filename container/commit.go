@@ -1,18 +1,67 @@
 package container
 
 import (
-	"os"
-	"os/exec"
+	"fmt"
+	"mydocker/archive"
+	"mydocker/image"
 )
 
-func CommitContainer(imageName string) error {
-	mntURL := "/root/test1/mnt"
-	imageTar := "/root/test1/" + imageName + ".tar"
-	cmd := exec.Command("tar", "-czf", imageTar, "-C", mntURL, ".")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return err
-	}
-	return nil
+type CommitOptions struct {
+	// Dockerfile指令，用来修改新镜像的配置
+	Changes []string
+	Author  string
+	// 记录在新镜像的历史中
+	Comment string
+}
+
+// 把容器读写层的修改保存为新的一层，在容器的镜像之上生成新镜像并打上tag
+// 新镜像的配置来自容器的配置，再按opts.Changes中的Dockerfile指令修改
+func CommitContainer(containerRef, ref string, opts CommitOptions) (image.Digest, error) {
+	info, err := LookupContainer(containerRef)
+	if err != nil {
+		return "", err
+	}
+	base, err := imageStore.GetImage(image.Digest(info.ImageId))
+	if err != nil {
+		return "", err
+	}
+	runConfig := base.Config.Config.Copy()
+	if info.Config != nil {
+		runConfig = info.Config.Copy()
+		if len(info.Labels) > 0 {
+			runConfig.Labels = info.Labels
+		}
+	}
+	for _, change := range opts.Changes {
+		if err = image.ApplyChange(runConfig, change); err != nil {
+			return "", err
+		}
+	}
+	layer, diffID, err := archiveUpper(info)
+	if err != nil {
+		return "", err
+	}
+	id, err := imageStore.CreateChildImage(base, runConfig, &layer, diffID, image.History{
+		CreatedBy: info.Command,
+		Author:    opts.Author,
+		Comment:   opts.Comment,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err = imageStore.Tag(ref, id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// 把容器的读写层打包保存为一层
+func archiveUpper(info *ContainerInfo) (image.Descriptor, image.Digest, error) {
+	r := archive.Tar(UpperDir(info.Id))
+	defer r.Close()
+	layer, diffID, err := imageStore.PutLayer(r)
+	if err != nil {
+		return image.Descriptor{}, "", fmt.Errorf("archive container %s:%v", info.Name, err)
+	}
+	return layer, diffID, nil
 }
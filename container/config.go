@@ -0,0 +1,89 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"mydocker/image"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// 没有设置PATH时使用的默认值，和docker相同
+const defaultPathEnv = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// 按docker的规则把run的参数合并到镜像的配置上，info.Config中是run的参数：
+// Entrypoint不为nil表示指定了--entrypoint，此时镜像的Cmd也不再使用；
+// 命令行中的命令覆盖Cmd；Env按变量名覆盖；容器的label覆盖镜像的label
+func mergeImageConfig(info *ContainerInfo, imageConfig *image.Config) error {
+	override := info.Config
+	if override == nil {
+		override = &image.Config{}
+	}
+	config := imageConfig.Copy()
+	if override.Entrypoint != nil {
+		config.Entrypoint = nil
+		for _, arg := range override.Entrypoint {
+			if arg != "" {
+				config.Entrypoint = append(config.Entrypoint, arg)
+			}
+		}
+		config.Cmd = nil
+	}
+	if len(override.Cmd) > 0 {
+		config.Cmd = override.Cmd
+	}
+	config.Env = image.MergeEnv(config.Env, override.Env)
+	if lookupEnv(config.Env, "PATH") == "" {
+		config.Env = append([]string{defaultPathEnv}, config.Env...)
+	}
+	if override.WorkingDir != "" {
+		config.WorkingDir = override.WorkingDir
+	}
+	if config.WorkingDir != "" && !path.IsAbs(config.WorkingDir) {
+		return fmt.Errorf("the working directory '%s' is invalid, it needs to be an absolute path", config.WorkingDir)
+	}
+	if override.User != "" {
+		config.User = override.User
+	}
+	if config.StopSignal != "" {
+		if _, err := ParseSignal(config.StopSignal); err != nil {
+			return err
+		}
+	}
+	labels := make(map[string]string)
+	for k, v := range config.Labels {
+		labels[k] = v
+	}
+	for k, v := range info.Labels {
+		labels[k] = v
+	}
+	info.Labels = labels
+	config.Labels = nil
+	info.Config = config
+	argv := info.Argv()
+	if len(argv) == 0 {
+		return errors.New("no command specified")
+	}
+	info.Command = strings.Join(argv, " ")
+	return nil
+}
+
+func lookupEnv(env []string, key string) string {
+	for _, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			return kv[len(key)+1:]
+		}
+	}
+	return ""
+}
+
+// 停止容器时发送的信号，镜像中没有指定时为SIGTERM
+func (info *ContainerInfo) stopSignal() syscall.Signal {
+	if info.Config != nil && info.Config.StopSignal != "" {
+		if signal, err := ParseSignal(info.Config.StopSignal); err == nil {
+			return signal
+		}
+	}
+	return syscall.SIGTERM
+}
@@ -1,10 +1,13 @@
 package container
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"math/rand"
+	"io/ioutil"
+	"mydocker/archive"
+	"mydocker/image"
+	"mydocker/store"
+	"mydocker/subsystems"
 	"mydocker/util"
 	"os"
 	"os/exec"
@@ -16,52 +19,122 @@ import (
 )
 
 var (
-	Running             = "running"
-	Stop                = "stop"
-	Exit                = "exit"
-	DefaultInfoLocation = "/var/run/mydocker/%s/"
-	ConfigName          = "config.json"
+	Created          = "created"
+	Running          = "running"
+	Restarting       = "restarting"
+	Paused           = "paused"
+	Stop             = "stop"
+	Exit             = "exit"
+	ContainerLogFile = "container.log"
+	// 没有指定镜像时使用的镜像
+	DefaultImage = "busybox"
 )
 
+var stateStore = store.New(store.DefaultRoot)
+
 type ContainerInfo struct {
-	Pid        string `json:"pid"`
-	Id         string `json:"id"`
-	Name       string `json:"name"`
-	Command    string `json:"command"`
-	CreateTime string `json:"createTime"`
-	Status     string `json:"status"`
+	Pid        string            `json:"pid"`
+	Id         string            `json:"id"`
+	Name       string            `json:"name"`
+	Command    string            `json:"command"`
+	CreateTime string            `json:"createTime"`
+	Created    time.Time         `json:"created"`
+	Status     string            `json:"status"`
+	ExitCode   int               `json:"exitCode"`
+	Volume     string            `json:"volume"`
+	Image      string            `json:"image"`
+	ImageId    string            `json:"imageId"`
+	Labels     map[string]string `json:"labels"`
+	CgroupPath string            `json:"cgroupPath"`
+	// 重启时复用同样的资源限制
+	ResourceConfig *subsystems.ResourceConfig `json:"resourceConfig"`
+	RestartPolicy  *RestartPolicy             `json:"restartPolicy"`
+	RestartCount   int                        `json:"restartCount"`
+	// 写入容器init进程的oom_score_adj
+	OomScoreAdj int `json:"oomScoreAdj"`
+	// 容器进程的启动时间，用于识别pid复用
+	PidStartTime uint64 `json:"pidStartTime"`
+	// 等待容器退出并负责重启的进程
+	MonitorPid       int    `json:"monitorPid"`
+	MonitorStartTime uint64 `json:"monitorStartTime"`
+	// 通过--device映射到容器内的设备
+	Devices []*Device `json:"devices"`
+	// 镜像的配置和run的参数合并后的结果
+	Config *image.Config `json:"config"`
+}
+
+const createTimeLayout = "2006-01-02 15:04:05"
+
+// 排序和since/before过滤使用完整精度的创建时间，CreateTime只用于显示
+// 以前的版本只记录了精确到秒的CreateTime
+func (info *ContainerInfo) createdAt() time.Time {
+	if !info.Created.IsZero() {
+		return info.Created
+	}
+	t, _ := time.ParseInLocation(createTimeLayout, info.CreateTime, time.Local)
+	return t
+}
+
+// 容器进程的命令，为Entrypoint加上Cmd
+func (info *ContainerInfo) Argv() []string {
+	if info.Config == nil {
+		return strings.Split(info.Command, " ")
+	}
+	return append(append([]string{}, info.Config.Entrypoint...), info.Config.Cmd...)
 }
 
-func NewContainerProcess(tty bool, volume string) (cmd *exec.Cmd, writePipe *os.File, err error) {
+func NewContainerProcess(tty bool, volume, containerID string) (cmd *exec.Cmd, writePipe *os.File, err error) {
 	readPipe, writePipe, err := util.NewPipe()
 	if err != nil {
 		return
 	}
 	// proc/self/exec 表示执行自己的init方法
-	cmd = exec.Command("/proc/self/exe", "init")
+	cmd = exec.Command("/proc/self/exe", "init", containerID)
 	// 为进程创建对应的namespace
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID |
 			syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
 	}
 	cmd.ExtraFiles = []*os.File{readPipe}
-	//rootURL := "/root/test1/"
-	//mntURL := "/root/test1/mnt/"
-	//if err = NewWorkspace(rootURL, mntURL, volume); err != nil {
-	//	return
-	//}
-	//cmd.Dir = mntURL
-	cmd.Dir = "/root/test1/busybox"
 
 	// 如果需要tty则把目前的标准输入、标准输出、标准错误赋予给新的子进程
 	if tty {
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
+	} else {
+		// 后台运行的容器把标准输出和标准错误追加到日志文件，重启后继续写同一个文件
+		var logFile *os.File
+		logFile, err = os.OpenFile(ContainerLogPath(containerID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			readPipe.Close()
+			writePipe.Close()
+			return
+		}
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
 	}
 	return
 }
 
+// 子进程启动后父进程中不再需要的文件：管道的读端和日志文件，容器每次重启都会重新打开
+func CloseProcessFiles(cmd *exec.Cmd) {
+	for _, f := range cmd.ExtraFiles {
+		f.Close()
+	}
+	if logFile, ok := cmd.Stdout.(*os.File); ok && logFile != os.Stdout {
+		logFile.Close()
+	}
+}
+
+func SetOomScoreAdj(pid, score int) error {
+	return ioutil.WriteFile(fmt.Sprintf("/proc/%d/oom_score_adj", pid), []byte(strconv.Itoa(score)), 0644)
+}
+
+func ContainerLogPath(containerID string) string {
+	return filepath.Join(stateStore.ContainerDir(containerID), ContainerLogFile)
+}
+
 func NewWorkspace(rootURL, mnt, volume string) error {
 	if err := CreateReadOnly(rootURL); err != nil {
 		return err
@@ -78,7 +151,7 @@ func NewWorkspace(rootURL, mnt, volume string) error {
 			if err := MountVolume(rootURL, mnt, volumeURLs); err != nil {
 				return err
 			}
-			logrus.Info("mount the volume:%+v", volumeURLs)
+			logrus.Infof("mount the volume:%+v", volumeURLs)
 		}
 	}
 	return nil
@@ -96,7 +169,17 @@ func CreateReadOnly(rootURL string) error {
 		if err = os.Mkdir(target, 0777); err != nil {
 			return err
 		}
-		if _, err = exec.Command("tar", "-xvf", source, "-C", target).CombinedOutput(); err != nil {
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r, err := archive.DecompressStream(f)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		if err = archive.Untar(r, target); err != nil {
 			return err
 		}
 	}
@@ -159,7 +242,7 @@ func DeleteWorkSpace(rootURL, mntURL, volume string) error {
 			if err := DeleteMountPointWithVolume(mntURL, volumeURLs); err != nil {
 				return err
 			}
-			logrus.Info("umount the volume:%+v", volumeURLs)
+			logrus.Infof("umount the volume:%+v", volumeURLs)
 		}
 	} else {
 		if err := DeleteMountPoint(mntURL); err != nil {
@@ -232,59 +315,79 @@ func volumeExtract(volume string) []string {
 	return strings.Split(volume, ":")
 }
 
-func generateContainerID(n int) string {
-	letterBytes := "1234567890"
-	rand.Seed(time.Now().UnixNano())
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
-	}
-	return string(b)
-}
-
-func RecordContainerInfo(pid int, command []string, containerName string) (string, error) {
-	id := generateContainerID(10)
-	createTime := time.Now().Format("2006-01-02 15:04:05")
-	commandStr := strings.Join(command, " ")
-	if containerName == "" {
-		containerName = id
-	}
-	containerInfo := ContainerInfo{
-		Pid:        strconv.Itoa(pid),
-		Command:    commandStr,
-		Id:         id,
-		CreateTime: createTime,
-		Name:       containerName,
-		Status:     Running,
-	}
-	b, err := json.Marshal(containerInfo)
-	if err != nil {
-		return "", err
+func RecordContainerInfo(info *ContainerInfo) (err error) {
+	if info.Id, err = generateContainerID(); err != nil {
+		return err
 	}
-	jsonStr := string(b)
-	dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
-	exist, err := pathExist(dirURL)
-	if err != nil {
-		return "", err
+	info.Created = time.Now()
+	info.CreateTime = info.Created.Format(createTimeLayout)
+	if info.Name == "" {
+		info.Name = ShortID(info.Id)
 	}
-	if !exist {
-		if err := os.MkdirAll(dirURL, 0755); err != nil {
-			return "", err
-		}
+	if info.Image == "" {
+		info.Image = DefaultImage
 	}
-	fileName := filepath.Join(dirURL, ConfigName)
-	file, err := os.Create(fileName)
-	defer file.Close()
+	// 镜像的层只解压一次，所有使用它的容器共享
+	img, err := imageStore.Lookup(info.Image)
 	if err != nil {
-		return "", err
+		return err
+	}
+	if _, err = imageStore.PrepareLayers(img); err != nil {
+		return err
+	}
+	info.ImageId = img.Id.String()
+	if err = mergeImageConfig(info, img.Config.Config); err != nil {
+		return err
+	}
+	if info.Status == "" {
+		info.Status = Created
+	}
+	if info.CgroupPath == "" {
+		info.CgroupPath = filepath.Join(subsystems.CgroupParent, info.Id)
+	}
+	if err = stateStore.CreateContainer(info.Id, info.Name, info); err != nil {
+		return err
 	}
-	if _, err := file.WriteString(jsonStr); err != nil {
-		return "", err
+	if err = createRootfs(info.Id); err != nil {
+		stateStore.DeleteContainer(info.Id, info.Name)
+		return err
 	}
-	return containerName, nil
+	return nil
+}
+
+// 在容器锁内读取最新的容器信息，交给fn修改后写回
+func UpdateContainerInfo(containerID string, fn func(info *ContainerInfo) error) (*ContainerInfo, error) {
+	var info ContainerInfo
+	if err := stateStore.UpdateContainer(containerID, &info, func() error {
+		return fn(&info)
+	}); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func GetContainerInfo(containerID string) (*ContainerInfo, error) {
+	var info ContainerInfo
+	if err := stateStore.ReadContainer(containerID, &info); err != nil {
+		return nil, err
+	}
+	if !reconcileContainerInfo(&info) {
+		return &info, nil
+	}
+	// 在锁内重新确认状态后再写回，遗留的资源由CleanupOrphans清理
+	return UpdateContainerInfo(containerID, func(latest *ContainerInfo) error {
+		reconcileContainerInfo(latest)
+		return nil
+	})
 }
 
-func DeleteContainerInfo(containerName string) error {
-	dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
-	return os.RemoveAll(dirURL)
+func DeleteContainerInfo(containerID string) error {
+	info, err := GetContainerInfo(containerID)
+	if err != nil {
+		return err
+	}
+	if err = removeRootfs(info.Id); err != nil {
+		return err
+	}
+	return stateStore.DeleteContainer(info.Id, info.Name)
 }
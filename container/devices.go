@@ -0,0 +1,115 @@
+package container
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"mydocker/subsystems"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 通过--device映射到容器内的设备
+type Device struct {
+	PathOnHost      string `json:"pathOnHost"`
+	PathInContainer string `json:"pathInContainer"`
+	// r、w、m的组合
+	Permissions string `json:"permissions"`
+	// c或者b
+	Type     string `json:"type"`
+	Major    int64  `json:"major"`
+	Minor    int64  `json:"minor"`
+	FileMode uint32 `json:"fileMode"`
+}
+
+// 每个容器都会创建的设备节点
+var defaultDevices = []*Device{
+	{PathInContainer: "/dev/null", Type: "c", Major: 1, Minor: 3, FileMode: 0666},
+	{PathInContainer: "/dev/zero", Type: "c", Major: 1, Minor: 5, FileMode: 0666},
+	{PathInContainer: "/dev/full", Type: "c", Major: 1, Minor: 7, FileMode: 0666},
+	{PathInContainer: "/dev/random", Type: "c", Major: 1, Minor: 8, FileMode: 0666},
+	{PathInContainer: "/dev/urandom", Type: "c", Major: 1, Minor: 9, FileMode: 0666},
+	{PathInContainer: "/dev/tty", Type: "c", Major: 5, Minor: 0, FileMode: 0666},
+}
+
+// 解析 /dev/host[:/dev/container][:rwm]，容器内路径默认和主机路径相同
+func ParseDevice(value string) (*Device, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return nil, fmt.Errorf("bad format of device %q, expected /dev/host[:/dev/container][:permissions]", value)
+	}
+	device := &Device{PathOnHost: parts[0], PathInContainer: parts[0], Permissions: "rwm"}
+	switch len(parts) {
+	case 2:
+		// 第二段不是绝对路径时表示权限
+		if strings.HasPrefix(parts[1], "/") {
+			device.PathInContainer = parts[1]
+		} else {
+			device.Permissions = parts[1]
+		}
+	case 3:
+		device.PathInContainer = parts[1]
+		device.Permissions = parts[2]
+	}
+	if !filepath.IsAbs(device.PathInContainer) {
+		return nil, fmt.Errorf("device path %s in container is not absolute", device.PathInContainer)
+	}
+	if err := subsystems.ValidateDevicePermissions(device.Permissions); err != nil {
+		return nil, err
+	}
+	var err error
+	device.Type, device.Major, device.Minor, device.FileMode, err = subsystems.StatDevice(device.PathOnHost)
+	if err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// 对应的devices cgroup规则
+func (d *Device) Rule() *subsystems.DeviceRule {
+	return &subsystems.DeviceRule{
+		Type:        d.Type,
+		Major:       d.Major,
+		Minor:       d.Minor,
+		Permissions: d.Permissions,
+	}
+}
+
+// 在容器的/dev下创建设备节点，需要在挂载/dev之后调用
+func createDevices(devices []*Device) error {
+	for _, device := range append(append([]*Device{}, defaultDevices...), devices...) {
+		if err := createDeviceNode(device); err != nil {
+			return fmt.Errorf("create device %s:%v", device.PathInContainer, err)
+		}
+	}
+	links := [][2]string{
+		{"/proc/self/fd", "/dev/fd"},
+		{"/proc/self/fd/0", "/dev/stdin"},
+		{"/proc/self/fd/1", "/dev/stdout"},
+		{"/proc/self/fd/2", "/dev/stderr"},
+	}
+	for _, link := range links {
+		if err := os.Symlink(link[0], link[1]); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func createDeviceNode(device *Device) error {
+	if err := os.MkdirAll(filepath.Dir(device.PathInContainer), 0755); err != nil {
+		return err
+	}
+	mode := device.FileMode & 07777
+	if device.Type == "b" {
+		mode |= unix.S_IFBLK
+	} else {
+		mode |= unix.S_IFCHR
+	}
+	dev := unix.Mkdev(uint32(device.Major), uint32(device.Minor))
+	if err := unix.Mknod(device.PathInContainer, mode, int(dev)); err != nil && !os.IsExist(err) {
+		return err
+	}
+	// mknod受umask影响，重新设置权限
+	return os.Chmod(device.PathInContainer, os.FileMode(device.FileMode&0777))
+}
@@ -0,0 +1,44 @@
+package container
+
+import (
+	"testing"
+)
+
+func TestParseDevice(t *testing.T) {
+	tests := []struct {
+		value       string
+		wantPath    string
+		wantPerms   string
+		wantErr     bool
+		wantDevType string
+	}{
+		{"/dev/null", "/dev/null", "rwm", false, "c"},
+		{"/dev/null:/dev/mynull", "/dev/mynull", "rwm", false, "c"},
+		{"/dev/null:r", "/dev/null", "r", false, "c"},
+		{"/dev/null:/dev/mynull:rw", "/dev/mynull", "rw", false, "c"},
+		{"", "", "", true, ""},
+		{"/dev/null:relative", "", "", true, ""},
+		{"/dev/null:/dev/a:rx", "", "", true, ""},
+		{"/dev/null:/a:/b:rw", "", "", true, ""},
+		{"/no/such/device", "", "", true, ""},
+	}
+	for _, tt := range tests {
+		device, err := ParseDevice(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseDevice(%q) succeeded, want error", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDevice(%q) error = %v", tt.value, err)
+			continue
+		}
+		if device.PathInContainer != tt.wantPath || device.Permissions != tt.wantPerms || device.Type != tt.wantDevType {
+			t.Errorf("ParseDevice(%q) = %+v", tt.value, device)
+		}
+		if device.Major != 1 || device.Minor != 3 {
+			t.Errorf("ParseDevice(%q) device number = %d:%d, want 1:3", tt.value, device.Major, device.Minor)
+		}
+	}
+}
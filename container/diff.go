@@ -0,0 +1,130 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mydocker/archive"
+	"mydocker/image"
+	"mydocker/util"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// 和docker的接口一致，json中是数字
+type ChangeKind int
+
+const (
+	ChangeModify ChangeKind = iota
+	ChangeAdd
+	ChangeDelete
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdd:
+		return "A"
+	case ChangeDelete:
+		return "D"
+	}
+	return "C"
+}
+
+type Change struct {
+	Path string
+	Kind ChangeKind
+}
+
+// 遍历容器的读写层，和镜像比较得到新增、修改和删除的文件，按路径排序
+func GetContainerChanges(containerRef string) ([]Change, error) {
+	info, err := LookupContainer(containerRef)
+	if err != nil {
+		return nil, err
+	}
+	img, err := imageStore.GetImage(image.Digest(info.ImageId))
+	if err != nil {
+		return nil, err
+	}
+	upper := UpperDir(info.Id)
+	if _, err = os.Stat(upper); err != nil {
+		return nil, fmt.Errorf("container %s has no write layer: %v", info.Name, err)
+	}
+	return upperChanges(img, upper)
+}
+
+// whiteout表示删除，镜像中没有的文件是新增，其余的是修改
+func upperChanges(img *image.LocalImage, upper string) ([]Change, error) {
+	var changes []Change
+	err := filepath.Walk(upper, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(upper, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := "/" + filepath.ToSlash(rel)
+		switch {
+		case archive.IsWhiteout(fi):
+			changes = append(changes, Change{Path: name, Kind: ChangeDelete})
+		case imageStore.LookupPath(img, name) == "":
+			changes = append(changes, Change{Path: name, Kind: ChangeAdd})
+		default:
+			changes = append(changes, Change{Path: name, Kind: ChangeModify})
+			// 不透明的目录中不在读写层的文件都已经被删除
+			if fi.IsDir() && archive.IsOpaque(p) {
+				changes = append(changes, opaqueDeleted(img, name, p)...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// 镜像中dir目录下存在，但是读写层的upperDir中没有的文件
+func opaqueDeleted(img *image.LocalImage, dir, upperDir string) []Change {
+	seen := make(map[string]bool)
+	var changes []Change
+	for _, diffID := range img.Config.RootFS.DiffIDs {
+		fis, err := ioutil.ReadDir(filepath.Join(imageStore.LayerDir(diffID), dir))
+		if err != nil {
+			continue
+		}
+		for _, fi := range fis {
+			name := path.Join(dir, fi.Name())
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if _, err = os.Lstat(filepath.Join(upperDir, fi.Name())); err == nil {
+				continue
+			}
+			if imageStore.LookupPath(img, name) != "" {
+				changes = append(changes, Change{Path: name, Kind: ChangeDelete})
+			}
+		}
+	}
+	return changes
+}
+
+// 默认每行输出一个修改，例如 A /tmp/new，format为json时每行输出一个json
+func ShowContainerChanges(containerRef, format string) error {
+	changes, err := GetContainerChanges(containerRef)
+	if err != nil {
+		return err
+	}
+	if done, err := util.FormatList(os.Stdout, changes, format, false, nil); done {
+		return err
+	}
+	for _, change := range changes {
+		fmt.Printf("%s %s\n", change.Kind, change.Path)
+	}
+	return nil
+}
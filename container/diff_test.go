@@ -0,0 +1,81 @@
+package container
+
+import (
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"mydocker/image"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 按描述创建文件：dir/为目录，+dir/为不透明的目录，-name为whiteout，其余为普通文件
+func createFiles(t *testing.T, dir string, files []string) {
+	for _, f := range files {
+		path := filepath.Join(dir, strings.TrimLeft(f, "+-"))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		switch {
+		case strings.HasPrefix(f, "-"):
+			err = unix.Mknod(path, unix.S_IFCHR, 0)
+		case strings.HasSuffix(f, "/"):
+			if err = os.MkdirAll(path, 0755); err == nil && strings.HasPrefix(f, "+") {
+				err = unix.Lsetxattr(path, "trusted.overlay.opaque", []byte("y"), 0)
+			}
+		default:
+			err = ioutil.WriteFile(path, []byte(f), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUpperChanges(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating whiteouts requires root")
+	}
+	root, err := ioutil.TempDir("", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	saved := imageStore
+	imageStore = image.New(filepath.Join(root, "image"))
+	defer func() { imageStore = saved }()
+	// 第二层删除了/a
+	img := &image.LocalImage{Config: image.Scratch()}
+	for i, files := range [][]string{
+		{"etc/passwd", "etc/group", "a/b", "d/x", "d/y"},
+		{"-a"},
+	} {
+		diffID := image.FromBytes([]byte(strconv.Itoa(i)))
+		createFiles(t, imageStore.LayerDir(diffID), files)
+		img.Config.RootFS.DiffIDs = append(img.Config.RootFS.DiffIDs, diffID)
+	}
+	upper := filepath.Join(root, "upper")
+	createFiles(t, upper, []string{"etc/passwd", "-etc/group", "new", "a/b", "+d/", "d/x"})
+	changes, err := upperChanges(img, upper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{"/a", ChangeAdd},
+		{"/a/b", ChangeAdd},
+		{"/d", ChangeModify},
+		{"/d/x", ChangeModify},
+		{"/d/y", ChangeDelete},
+		{"/etc", ChangeModify},
+		{"/etc/group", ChangeDelete},
+		{"/etc/passwd", ChangeModify},
+		{"/new", ChangeAdd},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("upperChanges() = %v, want %v", changes, want)
+	}
+}
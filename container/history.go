@@ -0,0 +1,140 @@
+package container
+
+import (
+	"fmt"
+	"mydocker/image"
+	"mydocker/util"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const truncCreatedByLen = 45
+
+type HistoryOptions struct {
+	// 以可读的格式输出大小和时间
+	Human   bool
+	Quiet   bool
+	NoTrunc bool
+	// go模板，或者json表示每行输出一个json
+	Format string
+}
+
+// 镜像历史中的一项，字段名即为--format模板中使用的名字
+type ImageHistory struct {
+	// 这一步生成的本地镜像，不在本地时为<missing>
+	Id        string
+	Created   time.Time
+	CreatedBy string
+	Size      int64
+	Comment   string
+	Tags      []string
+}
+
+const missingImageId = "<missing>"
+
+// 返回镜像的历史，最新的一步在最前面
+func GetImageHistory(ref string) ([]ImageHistory, error) {
+	img, err := imageStore.Lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+	history := img.Config.History
+	// 没有历史的镜像按层列出
+	if len(history) == 0 {
+		for range img.Manifest.Layers {
+			history = append(history, image.History{Created: img.Config.Created})
+		}
+	}
+	ids, err := historyImageIds(img)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ImageHistory, len(history))
+	layer := 0
+	for i, h := range history {
+		item := ImageHistory{
+			Id:        missingImageId,
+			Created:   h.Created,
+			CreatedBy: h.CreatedBy,
+			Comment:   h.Comment,
+		}
+		if id, ok := ids[i]; ok {
+			item.Id = id.String()
+			if item.Tags, err = imageStore.ReferencesOf(id); err != nil {
+				return nil, err
+			}
+		}
+		if !h.EmptyLayer && layer < len(img.Manifest.Layers) {
+			if item.Size, err = imageStore.LayerSize(img.Manifest.Layers[layer], img.Config.RootFS.DiffIDs[layer]); err != nil {
+				return nil, err
+			}
+			layer++
+		}
+		result[len(history)-1-i] = item
+	}
+	return result, nil
+}
+
+// 沿着parent找到历史中每一步对应的本地镜像，镜像对应它的最后一步
+func historyImageIds(img *image.LocalImage) (map[int]image.Digest, error) {
+	ids := make(map[int]image.Digest)
+	seen := make(map[image.Digest]bool)
+	for id := img.Id; id != "" && !seen[id]; {
+		seen[id] = true
+		current, err := imageStore.GetImage(id)
+		if err != nil {
+			// parent已经不在本地
+			break
+		}
+		if n := len(current.Config.History); n > 0 {
+			if _, ok := ids[n-1]; !ok {
+				ids[n-1] = id
+			}
+		}
+		metadata, err := imageStore.GetMetadata(id)
+		if err != nil {
+			return nil, err
+		}
+		id = metadata.Parent
+	}
+	return ids, nil
+}
+
+func ShowHistory(ref string, opts HistoryOptions) error {
+	history, err := GetImageHistory(ref)
+	if err != nil {
+		return err
+	}
+	if !opts.NoTrunc {
+		for i := range history {
+			if history[i].Id != missingImageId {
+				history[i].Id = image.Digest(history[i].Id).ShortID()
+			}
+			// 多行的命令合并为一行
+			createdBy := strings.Join(strings.Fields(history[i].CreatedBy), " ")
+			if len(createdBy) > truncCreatedByLen {
+				createdBy = truncate(createdBy, truncCreatedByLen-3) + "..."
+			}
+			history[i].CreatedBy = createdBy
+		}
+	}
+	if done, err := util.FormatList(os.Stdout, history, opts.Format, opts.Quiet, func(i int) string {
+		return history[i].Id
+	}); done {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "IMAGE\tCREATED\tCREATED BY\tSIZE\tCOMMENT\n")
+	for _, item := range history {
+		created := item.Created.Format(time.RFC3339)
+		size := fmt.Sprint(item.Size)
+		if opts.Human {
+			created = util.HumanDuration(time.Since(item.Created)) + " ago"
+			size = util.HumanSize(float64(item.Size))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", item.Id, created, item.CreatedBy, size, item.Comment)
+	}
+	return w.Flush()
+}
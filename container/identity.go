@@ -0,0 +1,69 @@
+package container
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	containerIDLen = 64
+	shortIDLen     = 12
+)
+
+// 生成64位十六进制的随机容器ID
+func generateContainerID() (string, error) {
+	b := make([]byte, containerIDLen/2)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 用于展示的12位短ID
+func ShortID(id string) string {
+	if len(id) > shortIDLen {
+		return id[:shortIDLen]
+	}
+	return id
+}
+
+// 按照完整ID、名字、唯一的ID前缀的顺序查找容器ID
+func ResolveContainerID(ref string) (string, error) {
+	if ref == "" {
+		return "", fmt.Errorf("empty container reference")
+	}
+	if len(ref) == containerIDLen && stateStore.ContainerExists(ref) {
+		return ref, nil
+	}
+	if id, err := stateStore.LookupName(ref); err == nil {
+		return id, nil
+	}
+	ids, err := stateStore.ListContainers()
+	if err != nil {
+		return "", err
+	}
+	var matched []string
+	for _, id := range ids {
+		if strings.HasPrefix(id, ref) {
+			matched = append(matched, id)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return "", fmt.Errorf("no such container: %s", ref)
+	case 1:
+		return matched[0], nil
+	}
+	return "", fmt.Errorf("multiple containers found with prefix %s", ref)
+}
+
+// 通过ID、ID前缀或者名字获取容器信息
+func LookupContainer(ref string) (*ContainerInfo, error) {
+	id, err := ResolveContainerID(ref)
+	if err != nil {
+		return nil, err
+	}
+	return GetContainerInfo(id)
+}
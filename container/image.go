@@ -0,0 +1,106 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mydocker/image"
+	"mydocker/registry"
+	"os"
+)
+
+// 把镜像保存为OCI image layout格式的tar归档，output为空时写到标准输出
+func SaveImages(refs []string, output string) (err error) {
+	var w io.Writer = os.Stdout
+	if output == "" {
+		if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			return errors.New("refusing to write archive to a terminal, use -o or redirect the output")
+		}
+	} else {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			// 不保留写了一半的文件
+			if err != nil {
+				os.Remove(output)
+			}
+		}()
+		w = f
+	}
+	return imageStore.Save(w, refs)
+}
+
+// 从tar归档导入镜像，input为空时从标准输入读取
+func LoadImages(input string) error {
+	var r io.Reader = os.Stdin
+	if input != "" {
+		f, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	loaded, err := imageStore.Load(r)
+	if err != nil {
+		return err
+	}
+	for _, img := range loaded {
+		if img.Ref != "" {
+			fmt.Printf("Loaded image: %s\n", img.Ref)
+		} else {
+			fmt.Printf("Loaded image ID: %s\n", img.Id)
+		}
+	}
+	return nil
+}
+
+// 给镜像加上新的名字，target没有tag时为latest
+func TagImage(source, target string) error {
+	img, err := imageStore.Lookup(source)
+	if err != nil {
+		return err
+	}
+	ref, err := image.ParseReference(target)
+	if err != nil {
+		return err
+	}
+	if ref.Digest != "" {
+		return fmt.Errorf("refusing to create a tag with a digest reference: %s", target)
+	}
+	return imageStore.Tag(ref.Name()+":"+ref.Tag, img.Id)
+}
+
+// 从仓库拉取镜像
+func PullImage(name string) error {
+	ref, err := image.ParseReference(name)
+	if err != nil {
+		return err
+	}
+	_, err = registry.Pull(imageStore, ref, registryCredentials, os.Stdout)
+	return err
+}
+
+// 把本地镜像推送到镜像名中的仓库
+func PushImage(name string) error {
+	ref, err := image.ParseReference(name)
+	if err != nil {
+		return err
+	}
+	_, err = registry.Push(imageStore, ref, registryCredentials, os.Stdout)
+	return err
+}
+
+// 按仓库地址从配置文件或者credential helper读取login保存的凭据
+func registryCredentials(domain string) (*registry.Credentials, error) {
+	config, err := registry.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.GetCredentials(domain)
+}
@@ -1,48 +1,99 @@
 package container
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
+	"mydocker/image"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"syscall"
 )
 
-func InitContainerProcess() (err error) {
+func InitContainerProcess(containerID string) (err error) {
+	// 在pivot_root之前读取容器信息，状态目录在容器内不可见
+	var info ContainerInfo
+	if err = stateStore.ReadContainer(containerID, &info); err != nil {
+		return fmt.Errorf("read container %s:%v", containerID, err)
+	}
 	// 读取fd为3，也就是附加的read管道
 	readPipe := os.NewFile(uintptr(3), "pipe")
-	// 读取管道的数据
+	// 读取管道的数据，是json编码的命令和参数
 	b, err := ioutil.ReadAll(readPipe)
 	if err != nil {
 		return
 	}
-	msgStr := string(b)
-	commandArr := strings.Split(msgStr, " ")
+	var commandArr []string
+	if err = json.Unmarshal(b, &commandArr); err != nil {
+		return fmt.Errorf("invalid command %q:%v", string(b), err)
+	}
 	if len(commandArr) == 0 || commandArr[0] == "" {
-		err = fmt.Errorf("command %s is not found", commandArr[0])
-		return
+		return fmt.Errorf("no command specified")
 	}
 
-	// 获取实际路径
+	if err = setUpMount(&info); err != nil {
+		return err
+	}
+	env := os.Environ()
+	if info.Config != nil {
+		if env, err = setUpProcess(info.Config); err != nil {
+			return err
+		}
+	}
+	// 按容器内的PATH获取实际路径
 	command, err := exec.LookPath(commandArr[0])
 	if err != nil {
 		return err
 	}
 	logrus.Infof("command %s", command)
-
-	if err = setUpMount(); err != nil {
-		return err
-	}
 	// 使用系统调用execve来替换当前的init程序为传入的command
-	if err := syscall.Exec(command, commandArr[0:], os.Environ()); err != nil {
+	if err := syscall.Exec(command, commandArr[0:], env); err != nil {
 		return err
 	}
 	return nil
 }
 
+// 在容器的根目录下切换工作目录和用户，返回容器进程的环境变量
+func setUpProcess(config *image.Config) ([]string, error) {
+	env := append([]string{}, config.Env...)
+	if err := os.Setenv("PATH", lookupEnv(env, "PATH")); err != nil {
+		return nil, err
+	}
+	if config.WorkingDir != "" {
+		// 工作目录不存在时创建，和docker一致
+		if err := os.MkdirAll(config.WorkingDir, 0755); err != nil {
+			return nil, err
+		}
+		if err := syscall.Chdir(config.WorkingDir); err != nil {
+			return nil, err
+		}
+	}
+	user := config.User
+	if user == "" {
+		user = "0"
+	}
+	// 已经pivot_root，读取的是容器内的文件
+	execUser, err := lookupExecUser(user, "/etc/passwd", "/etc/group")
+	if err != nil {
+		return nil, err
+	}
+	if lookupEnv(env, "HOME") == "" {
+		env = append(env, "HOME="+execUser.Home)
+	}
+	if err = syscall.Setgroups(execUser.Groups); err != nil {
+		return nil, err
+	}
+	if err = syscall.Setgid(execUser.Gid); err != nil {
+		return nil, err
+	}
+	if err = syscall.Setuid(execUser.Uid); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
 func pivotRoot(root string) error {
 	if err := syscall.Mount(root, root, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
 		return err
@@ -64,12 +115,16 @@ func pivotRoot(root string) error {
 	return os.Remove(pivotDir)
 }
 
-func setUpMount() error {
-	pwd, err := os.Getwd()
+func setUpMount(info *ContainerInfo) error {
+	// 挂载事件不传播到主机的mount namespace
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	root, err := mountRootfs(info)
 	if err != nil {
 		return err
 	}
-	if err := pivotRoot(pwd); err != nil {
+	if err := pivotRoot(root); err != nil {
 		return err
 	}
 
@@ -85,6 +140,5 @@ func setUpMount() error {
 	if err := syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755"); err != nil {
 		return err
 	}
-
-	return nil
+	return createDevices(info.Devices)
 }
@@ -0,0 +1,200 @@
+package container
+
+import (
+	"fmt"
+	"mydocker/image"
+	"mydocker/subsystems"
+	"strconv"
+	"time"
+)
+
+// inspect输出的容器详情，字段名即为--format模板中使用的名字
+type ContainerInspect struct {
+	Id              string
+	Name            string
+	Created         string
+	Path            string
+	Args            []string
+	Image           string
+	State           ContainerState
+	Config          ContainerConfig
+	HostConfig      HostConfig
+	Mounts          []MountPoint
+	NetworkSettings NetworkSettings
+	CgroupPath      string
+	LogPath         string
+	RestartCount    int
+}
+
+type ContainerState struct {
+	Status     string
+	Running    bool
+	Paused     bool
+	Restarting bool
+	Pid        int
+	ExitCode   int
+	// cgroup中当前的进程数
+	Pids uint64
+}
+
+type ContainerConfig struct {
+	Cmd          []string
+	Image        string
+	Entrypoint   []string
+	Env          []string
+	WorkingDir   string
+	User         string
+	Labels       map[string]string
+	ExposedPorts map[string]struct{}
+	Volumes      map[string]struct{}
+	StopSignal   string
+}
+
+type HostConfig struct {
+	RestartPolicy *RestartPolicy
+	Resources     *subsystems.ResourceConfig
+	Devices       []*Device
+}
+
+type MountPoint struct {
+	Source      string
+	Destination string
+	RW          bool
+}
+
+type NetworkSettings struct {
+	// 容器network namespace的路径
+	SandboxKey string
+}
+
+func InspectContainer(containerRef string) (*ContainerInspect, error) {
+	info, err := LookupContainer(containerRef)
+	if err != nil {
+		return nil, err
+	}
+	commandArr := info.Argv()
+	pid, _ := strconv.Atoi(info.Pid)
+	inspect := &ContainerInspect{
+		Id:      info.Id,
+		Name:    info.Name,
+		Created: info.CreateTime,
+		Path:    commandArr[0],
+		Args:    commandArr[1:],
+		Image:   info.ImageId,
+		State: ContainerState{
+			Status:     info.Status,
+			Running:    info.Status == Running || info.Status == Paused,
+			Paused:     info.Status == Paused,
+			Restarting: info.Status == Restarting,
+			ExitCode:   info.ExitCode,
+		},
+		Config: ContainerConfig{
+			Cmd:    commandArr,
+			Image:  info.Image,
+			Labels: info.Labels,
+		},
+		HostConfig: HostConfig{
+			RestartPolicy: info.RestartPolicy,
+			Resources:     info.ResourceConfig,
+			Devices:       info.Devices,
+		},
+		Mounts:       []MountPoint{},
+		CgroupPath:   info.CgroupPath,
+		RestartCount: info.RestartCount,
+	}
+	if config := info.Config; config != nil {
+		inspect.Config.Cmd = config.Cmd
+		inspect.Config.Entrypoint = config.Entrypoint
+		inspect.Config.Env = config.Env
+		inspect.Config.WorkingDir = config.WorkingDir
+		inspect.Config.User = config.User
+		inspect.Config.ExposedPorts = config.ExposedPorts
+		inspect.Config.Volumes = config.Volumes
+		inspect.Config.StopSignal = config.StopSignal
+	}
+	if inspect.State.Running {
+		inspect.State.Pid = pid
+		inspect.State.Pids, _ = subsystems.GetPidsCurrent(info.CgroupPath)
+		inspect.NetworkSettings.SandboxKey = fmt.Sprintf("/proc/%d/ns/net", pid)
+	}
+	if info.Volume != "" {
+		volumeURLs := volumeExtract(info.Volume)
+		if len(volumeURLs) == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
+			inspect.Mounts = append(inspect.Mounts, MountPoint{
+				Source:      volumeURLs[0],
+				Destination: volumeURLs[1],
+				RW:          true,
+			})
+		}
+	}
+	logPath := ContainerLogPath(info.Id)
+	if exist, _ := pathExist(logPath); exist {
+		inspect.LogPath = logPath
+	}
+	return inspect, nil
+}
+
+// inspect输出的镜像详情
+type ImageInspect struct {
+	Id       string
+	RepoTags []string
+	// commit或者build时所基于的镜像
+	Parent       string
+	Comment      string
+	Created      string
+	Author       string
+	Size         int64
+	Architecture string
+	Os           string
+	Config       *image.Config
+	RootFS       image.RootFS
+	// 配置和每一层压缩后的摘要
+	Manifest *image.Manifest
+	Metadata ImageMetadata
+}
+
+type ImageMetadata struct {
+	LastTagTime string
+}
+
+func InspectImage(ref string) (*ImageInspect, error) {
+	img, err := imageStore.Lookup(ref)
+	if err != nil {
+		return nil, err
+	}
+	repoTags, err := imageStore.ReferencesOf(img.Id)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := imageStore.GetMetadata(img.Id)
+	if err != nil {
+		return nil, err
+	}
+	inspect := &ImageInspect{
+		Id:           img.Id.String(),
+		RepoTags:     repoTags,
+		Parent:       metadata.Parent.String(),
+		Created:      img.Config.Created.Format(time.RFC3339Nano),
+		Author:       img.Config.Author,
+		Architecture: img.Config.Architecture,
+		Os:           img.Config.OS,
+		Config:       img.Config.Config,
+		RootFS:       img.Config.RootFS,
+		Manifest:     img.Manifest,
+	}
+	if history := img.Config.History; len(history) > 0 {
+		inspect.Comment = history[len(history)-1].Comment
+	}
+	if !metadata.LastTagTime.IsZero() {
+		inspect.Metadata.LastTagTime = metadata.LastTagTime.Format(time.RFC3339Nano)
+	}
+	// 镜像大小为各层未压缩时的大小之和
+	for i, layer := range img.Manifest.Layers {
+		size, err := imageStore.LayerSize(layer, img.Config.RootFS.DiffIDs[i])
+		if err != nil {
+			return nil, err
+		}
+		inspect.Size += size
+	}
+	return inspect, nil
+}
@@ -1,31 +1,173 @@
 package container
 
 import (
-	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"io/ioutil"
+	"mydocker/util"
 	"os"
-	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"text/tabwriter"
 )
 
-func ListContainers() error {
-	dirURL := fmt.Sprintf(DefaultInfoLocation, "")
-	dirURL = dirURL[:len(dirURL)-1]
-	fileList, err := ioutil.ReadDir(dirURL)
+const truncCommandLen = 20
+
+type ListOptions struct {
+	// 是否包含已经退出的容器
+	All bool
+	// 只输出容器ID
+	Quiet   bool
+	NoTrunc bool
+	// key=value形式的过滤条件
+	Filters []string
+	// go模板，或者json表示每行输出一个json
+	Format string
+}
+
+// 同一个key的多个条件是或的关系，不同key之间是与的关系
+type containerFilter map[string][]string
+
+var validFilterKeys = map[string]bool{
+	"id":       true,
+	"name":     true,
+	"status":   true,
+	"label":    true,
+	"ancestor": true,
+	"exited":   true,
+	"since":    true,
+	"before":   true,
+}
+
+func parseFilters(filters []string) (containerFilter, error) {
+	f := containerFilter{}
+	for _, item := range filters {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("bad format of filter %q, expected key=value", item)
+		}
+		if !validFilterKeys[kv[0]] {
+			return nil, fmt.Errorf("invalid filter key %q", kv[0])
+		}
+		if kv[0] == "exited" {
+			if _, err := strconv.Atoi(kv[1]); err != nil {
+				return nil, fmt.Errorf("invalid exited filter %q", kv[1])
+			}
+		}
+		f[kv[0]] = append(f[kv[0]], kv[1])
+	}
+	return f, nil
+}
+
+func (f containerFilter) match(key string, fn func(value string) bool) bool {
+	values, ok := f[key]
+	if !ok {
+		return true
+	}
+	for _, value := range values {
+		if fn(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f containerFilter) apply(infos []*ContainerInfo, all bool) ([]*ContainerInfo, error) {
+	// since/before按照参照容器的创建时间过滤
+	var since, before *ContainerInfo
+	for _, key := range []string{"since", "before"} {
+		for _, ref := range f[key] {
+			info, err := LookupContainer(ref)
+			if err != nil {
+				return nil, err
+			}
+			if key == "since" {
+				since = info
+			} else {
+				before = info
+			}
+		}
+	}
+	var result []*ContainerInfo
+	for _, info := range infos {
+		// 没有-a时只显示未退出的容器，除非显式按状态过滤
+		if !all && f["status"] == nil && (info.Status == Exit || info.Status == Stop) {
+			continue
+		}
+		matched := f.match("id", func(v string) bool { return strings.HasPrefix(info.Id, v) }) &&
+			f.match("name", func(v string) bool { return strings.Contains(info.Name, v) }) &&
+			f.match("status", func(v string) bool { return info.Status == v }) &&
+			f.match("ancestor", func(v string) bool { return info.Image == v }) &&
+			f.match("exited", func(v string) bool {
+				code, _ := strconv.Atoi(v)
+				return (info.Status == Exit || info.Status == Stop) && info.ExitCode == code
+			}) &&
+			f.match("label", func(v string) bool {
+				kv := strings.SplitN(v, "=", 2)
+				labelValue, ok := info.Labels[kv[0]]
+				return ok && (len(kv) == 1 || labelValue == kv[1])
+			})
+		if !matched {
+			continue
+		}
+		if since != nil && !info.createdAt().After(since.createdAt()) {
+			continue
+		}
+		if before != nil && !info.createdAt().Before(before.createdAt()) {
+			continue
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
+
+func ListContainers(opts ListOptions) error {
+	filter, err := parseFilters(opts.Filters)
+	if err != nil {
+		return err
+	}
+	ids, err := stateStore.ListContainers()
 	if err != nil {
 		return err
 	}
 	var containInfos []*ContainerInfo
-	for _, fileInfo := range fileList {
-		info, err := getContainerInfoByFile(fileInfo)
+	for _, id := range ids {
+		info, err := GetContainerInfo(id)
 		if err != nil {
-			logrus.Errorf("get container info failed:%s", err.Error())
+			logrus.Warnf("get container info failed:%s", err.Error())
 			continue
 		}
 		containInfos = append(containInfos, info)
 	}
+	if containInfos, err = filter.apply(containInfos, opts.All); err != nil {
+		return err
+	}
+	// 按创建时间倒序，最新创建的在最前面
+	sort.SliceStable(containInfos, func(i, j int) bool {
+		return containInfos[i].createdAt().After(containInfos[j].createdAt())
+	})
+	if !opts.NoTrunc {
+		for i, item := range containInfos {
+			row := *item
+			row.Id = ShortID(row.Id)
+			if len(row.Command) > truncCommandLen {
+				row.Command = truncate(row.Command, truncCommandLen-3) + "..."
+			}
+			containInfos[i] = &row
+		}
+	}
+	if done, err := util.FormatList(os.Stdout, containInfos, opts.Format, opts.Quiet, func(i int) string {
+		return containInfos[i].Id
+	}); done {
+		return err
+	}
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
 	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
 	for _, item := range containInfos {
@@ -37,18 +179,3 @@ func ListContainers() error {
 	}
 	return nil
 }
-
-func getContainerInfoByFile(file os.FileInfo) (*ContainerInfo, error) {
-	containerName := file.Name()
-	dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
-	configURL := filepath.Join(dirURL, ConfigName)
-	config, err := ioutil.ReadFile(configURL)
-	if err != nil {
-		return nil, err
-	}
-	var info ContainerInfo
-	if err = json.Unmarshal(config, &info); err != nil {
-		return nil, err
-	}
-	return &info, nil
-}
@@ -0,0 +1,96 @@
+package container
+
+import (
+	"io/ioutil"
+	"mydocker/store"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFilters(t *testing.T) {
+	tests := []struct {
+		filters []string
+		want    containerFilter
+		wantErr string
+	}{
+		{nil, containerFilter{}, ""},
+		{
+			[]string{"name=web", "name=db", "status=running"},
+			containerFilter{"name": {"web", "db"}, "status": {"running"}},
+			"",
+		},
+		{[]string{"label=a=b"}, containerFilter{"label": {"a=b"}}, ""},
+		{[]string{"exited=137"}, containerFilter{"exited": {"137"}}, ""},
+		{[]string{"name"}, nil, "bad format"},
+		{[]string{"name="}, nil, "bad format"},
+		{[]string{"color=red"}, nil, "invalid filter key"},
+		{[]string{"exited=abc"}, nil, "invalid exited filter"},
+	}
+	for _, tt := range tests {
+		got, err := parseFilters(tt.filters)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseFilters(%q) error = %v, want %q", tt.filters, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFilters(%q) = %v, %v, want %v", tt.filters, got, err, tt.want)
+		}
+	}
+}
+
+// 同一秒内创建的容器也要按创建的先后过滤
+func TestFilterSinceBefore(t *testing.T) {
+	root, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	saved := stateStore
+	stateStore = store.New(root)
+	defer func() { stateStore = saved }()
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	var infos []*ContainerInfo
+	for i, name := range []string{"first", "second", "third"} {
+		info := &ContainerInfo{
+			Id:         strings.Repeat(string(rune('a'+i)), 64),
+			Name:       name,
+			Status:     Created,
+			Created:    created.Add(time.Duration(i) * time.Millisecond),
+			CreateTime: created.Format(createTimeLayout),
+		}
+		if err = stateStore.CreateContainer(info.Id, info.Name, info); err != nil {
+			t.Fatal(err)
+		}
+		infos = append(infos, info)
+	}
+	tests := []struct {
+		filters []string
+		want    []string
+	}{
+		{[]string{"since=first"}, []string{"second", "third"}},
+		{[]string{"before=third"}, []string{"first", "second"}},
+		{[]string{"since=first", "before=third"}, []string{"second"}},
+	}
+	for _, tt := range tests {
+		f, err := parseFilters(tt.filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := f.apply(infos, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, info := range result {
+			names = append(names, info.Name)
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("filters %q = %v, want %v", tt.filters, names, tt.want)
+		}
+	}
+}
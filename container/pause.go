@@ -0,0 +1,46 @@
+package container
+
+import (
+	"fmt"
+	"mydocker/subsystems"
+)
+
+// 通过freezer cgroup冻结容器内的所有进程
+func PauseContainer(containerRef string) error {
+	info, err := LookupContainer(containerRef)
+	if err != nil {
+		return err
+	}
+	_, err = UpdateContainerInfo(info.Id, func(info *ContainerInfo) error {
+		if info.Status == Paused {
+			return fmt.Errorf("container %s is already paused", containerRef)
+		}
+		if info.Status != Running {
+			return fmt.Errorf("container %s is not running", containerRef)
+		}
+		if err := subsystems.Freeze(info.CgroupPath); err != nil {
+			return fmt.Errorf("pause container %s:%v", containerRef, err)
+		}
+		info.Status = Paused
+		return nil
+	})
+	return err
+}
+
+func UnpauseContainer(containerRef string) error {
+	info, err := LookupContainer(containerRef)
+	if err != nil {
+		return err
+	}
+	_, err = UpdateContainerInfo(info.Id, func(info *ContainerInfo) error {
+		if info.Status != Paused {
+			return fmt.Errorf("container %s is not paused", containerRef)
+		}
+		if err := subsystems.Thaw(info.CgroupPath); err != nil {
+			return fmt.Errorf("unpause container %s:%v", containerRef, err)
+		}
+		info.Status = Running
+		return nil
+	})
+	return err
+}
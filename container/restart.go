@@ -0,0 +1,97 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RestartNo            = "no"
+	RestartOnFailure     = "on-failure"
+	RestartAlways        = "always"
+	RestartUnlessStopped = "unless-stopped"
+)
+
+const (
+	// 重启的退避时间从100ms开始翻倍，最长1分钟
+	restartBackoffInitial = 100 * time.Millisecond
+	restartBackoffMax     = time.Minute
+	// 容器运行超过10s再退出时重置退避时间
+	restartBackoffReset = 10 * time.Second
+)
+
+// 容器的重启策略
+type RestartPolicy struct {
+	Name string `json:"name"`
+	// 仅对on-failure有效，0表示不限制重启次数
+	MaximumRetryCount int `json:"maximumRetryCount"`
+}
+
+// 解析 no|on-failure[:N]|always|unless-stopped
+func ParseRestartPolicy(policy string) (*RestartPolicy, error) {
+	if policy == "" {
+		return &RestartPolicy{Name: RestartNo}, nil
+	}
+	parts := strings.SplitN(policy, ":", 2)
+	p := &RestartPolicy{Name: parts[0]}
+	switch p.Name {
+	case RestartNo, RestartAlways, RestartUnlessStopped:
+		if len(parts) == 2 {
+			return nil, fmt.Errorf("maximum retry count can not be used with restart policy %s", p.Name)
+		}
+	case RestartOnFailure:
+		if len(parts) == 2 {
+			count, err := strconv.Atoi(parts[1])
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("invalid maximum retry count %q", parts[1])
+			}
+			p.MaximumRetryCount = count
+		}
+	default:
+		return nil, fmt.Errorf("invalid restart policy %q", policy)
+	}
+	return p, nil
+}
+
+func (p *RestartPolicy) String() string {
+	if p.Name == RestartOnFailure && p.MaximumRetryCount > 0 {
+		return fmt.Sprintf("%s:%d", p.Name, p.MaximumRetryCount)
+	}
+	return p.Name
+}
+
+// 根据退出码和已重启次数判断是否需要重启，被stop的容器不会再重启
+func (p *RestartPolicy) ShouldRestart(exitCode, restartCount int, stopped bool) bool {
+	if p == nil || stopped {
+		return false
+	}
+	switch p.Name {
+	case RestartAlways, RestartUnlessStopped:
+		return true
+	case RestartOnFailure:
+		if exitCode == 0 {
+			return false
+		}
+		return p.MaximumRetryCount == 0 || restartCount < p.MaximumRetryCount
+	}
+	return false
+}
+
+// 两次重启之间的等待时间，零值可以直接使用
+type RestartBackoff struct {
+	delay time.Duration
+}
+
+// 根据容器这次运行的时间返回重启前需要等待的时间
+func (b *RestartBackoff) Next(uptime time.Duration) time.Duration {
+	if b.delay == 0 || uptime > restartBackoffReset {
+		b.delay = restartBackoffInitial
+	}
+	delay := b.delay
+	if b.delay *= 2; b.delay > restartBackoffMax {
+		b.delay = restartBackoffMax
+	}
+	return delay
+}
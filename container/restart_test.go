@@ -0,0 +1,97 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		want    *RestartPolicy
+		wantErr bool
+	}{
+		{"", &RestartPolicy{Name: RestartNo}, false},
+		{"no", &RestartPolicy{Name: RestartNo}, false},
+		{"always", &RestartPolicy{Name: RestartAlways}, false},
+		{"unless-stopped", &RestartPolicy{Name: RestartUnlessStopped}, false},
+		{"on-failure", &RestartPolicy{Name: RestartOnFailure}, false},
+		{"on-failure:3", &RestartPolicy{Name: RestartOnFailure, MaximumRetryCount: 3}, false},
+		{"on-failure:0", &RestartPolicy{Name: RestartOnFailure}, false},
+		{"on-failure:-1", nil, true},
+		{"on-failure:x", nil, true},
+		{"always:3", nil, true},
+		{"no:1", nil, true},
+		{"sometimes", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRestartPolicy(tt.policy)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseRestartPolicy(%q) = %+v, %v, want %+v", tt.policy, got, err, tt.want)
+		}
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	tests := []struct {
+		policy       string
+		exitCode     int
+		restartCount int
+		stopped      bool
+		want         bool
+	}{
+		{"no", 1, 0, false, false},
+		{"always", 0, 10, false, true},
+		{"always", 1, 0, true, false},
+		{"unless-stopped", 0, 0, false, true},
+		{"unless-stopped", 0, 0, true, false},
+		{"on-failure", 0, 0, false, false},
+		{"on-failure", 1, 100, false, true},
+		{"on-failure:2", 1, 1, false, true},
+		{"on-failure:2", 1, 2, false, false},
+	}
+	for _, tt := range tests {
+		p, err := ParseRestartPolicy(tt.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.ShouldRestart(tt.exitCode, tt.restartCount, tt.stopped); got != tt.want {
+			t.Errorf("%s.ShouldRestart(%d, %d, %v) = %v, want %v",
+				tt.policy, tt.exitCode, tt.restartCount, tt.stopped, got, tt.want)
+		}
+	}
+	var p *RestartPolicy
+	if p.ShouldRestart(1, 0, false) {
+		t.Error("nil policy should not restart")
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	const ms = time.Millisecond
+	var b RestartBackoff
+	steps := []struct {
+		uptime time.Duration
+		want   time.Duration
+	}{
+		{0, 100 * ms},
+		{ms, 200 * ms},
+		{time.Second, 400 * ms},
+		{10 * time.Second, 800 * ms},
+		// 运行超过10s后重新从100ms开始
+		{11 * time.Second, 100 * ms},
+		{0, 200 * ms},
+	}
+	for i, step := range steps {
+		if got := b.Next(step.uptime); got != step.want {
+			t.Errorf("step %d: Next(%s) = %s, want %s", i, step.uptime, got, step.want)
+		}
+	}
+	// 一直快速退出时最多等待1分钟
+	for i := 0; i < 20; i++ {
+		b.Next(0)
+	}
+	if got := b.Next(0); got != time.Minute {
+		t.Errorf("Next() after many restarts = %s, want %s", got, time.Minute)
+	}
+}
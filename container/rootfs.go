@@ -0,0 +1,79 @@
+package container
+
+import (
+	"fmt"
+	"mydocker/image"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// 容器的读写层，容器删除前一直保留
+const containerRootfsRoot = "/var/lib/mydocker/containers"
+
+var imageStore = image.New(image.DefaultRoot)
+
+// 容器rootfs的目录:
+//
+//	<root>/<id>/upper   overlay的upperdir，容器的修改都写在这里
+//	<root>/<id>/work    overlay的workdir
+//	<root>/<id>/merged  挂载点
+//	<root>/<id>/init    最上面的lowerdir，包含容器内的挂载点，不会被提交到镜像中
+func ContainerRootDir(containerID string) string {
+	return filepath.Join(containerRootfsRoot, containerID)
+}
+
+func UpperDir(containerID string) string {
+	return filepath.Join(ContainerRootDir(containerID), "upper")
+}
+
+// 创建容器的读写层
+func createRootfs(containerID string) error {
+	for _, dir := range []string{"upper", "work", "merged"} {
+		if err := os.MkdirAll(filepath.Join(ContainerRootDir(containerID), dir), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeRootfs(containerID string) error {
+	return os.RemoveAll(ContainerRootDir(containerID))
+}
+
+// 在容器的mount namespace中把镜像的层和读写层挂载为overlay，返回挂载点
+func mountRootfs(info *ContainerInfo) (string, error) {
+	img, err := imageStore.GetImage(image.Digest(info.ImageId))
+	if err != nil {
+		return "", err
+	}
+	// 层已经在创建容器时解压，这里只计算路径，最上层在前
+	// overlay不允许同一个目录出现两次，相同的层只保留最上面的一个，例如多个空层
+	var lowers []string
+	seen := make(map[image.Digest]bool)
+	for i := len(img.Config.RootFS.DiffIDs) - 1; i >= 0; i-- {
+		diffID := img.Config.RootFS.DiffIDs[i]
+		if seen[diffID] {
+			continue
+		}
+		seen[diffID] = true
+		lowers = append(lowers, imageStore.LayerDir(diffID))
+	}
+	rootDir := ContainerRootDir(info.Id)
+	// 镜像中可能没有挂载点，例如FROM scratch构建的镜像
+	initDir := filepath.Join(rootDir, "init")
+	for _, dir := range []string{"proc", "dev"} {
+		if err = os.MkdirAll(filepath.Join(initDir, dir), 0755); err != nil {
+			return "", err
+		}
+	}
+	lowers = append([]string{initDir}, lowers...)
+	merged := filepath.Join(rootDir, "merged")
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		strings.Join(lowers, ":"), UpperDir(info.Id), filepath.Join(rootDir, "work"))
+	if err = syscall.Mount("overlay", merged, "overlay", 0, options); err != nil {
+		return "", fmt.Errorf("mount rootfs of container %s:%v", info.Name, err)
+	}
+	return merged, nil
+}
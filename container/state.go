@@ -0,0 +1,174 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"mydocker/subsystems"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 进程异常消失、无法得知真实退出码时记录的退出码
+const unknownExitCode = 255
+
+// 读取/proc/<pid>/stat，返回进程名之后的字段，fields[0]是第3个字段state
+func readProcStat(pid int) ([]string, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// 进程名中可能有空格，从最后一个右括号之后开始解析
+	stat := string(b)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("invalid stat of pid %d", pid)
+	}
+	return fields, nil
+}
+
+// 读取/proc/<pid>/stat中进程的启动时间(第22个字段)，用来区分pid是否被复用
+func ProcessStartTime(pid int) (uint64, error) {
+	fields, err := readProcStat(pid)
+	if err != nil {
+		return 0, err
+	}
+	if fields[0] == "Z" || fields[0] == "X" {
+		return 0, fmt.Errorf("process %d is dead", pid)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// pid存在且启动时间和记录的一致才认为是同一个进程
+func processAlive(pid int, startTime uint64) bool {
+	if pid <= 0 {
+		return false
+	}
+	actual, err := ProcessStartTime(pid)
+	if err != nil {
+		return false
+	}
+	return startTime == 0 || actual == startTime
+}
+
+// 根据实际的进程状态修正记录的状态，返回状态是否被修改
+// 等待容器的进程还活着时由它负责更新状态，否则容器进程不在了就认为容器已经退出
+func reconcileContainerInfo(info *ContainerInfo) bool {
+	if info.Status != Running && info.Status != Paused && info.Status != Restarting {
+		return false
+	}
+	if processAlive(info.MonitorPid, info.MonitorStartTime) {
+		return false
+	}
+	pid, _ := strconv.Atoi(info.Pid)
+	if info.Status != Restarting && processAlive(pid, info.PidStartTime) {
+		return false
+	}
+	logrus.Debugf("container %s is not running anymore, mark it as %s", info.Name, Exit)
+	info.Status = Exit
+	info.ExitCode = unknownExitCode
+	return true
+}
+
+// 容器进程可能还在使用cgroup和rootfs的状态
+func isActive(info *ContainerInfo) bool {
+	return info.Status == Running || info.Status == Paused || info.Status == Restarting
+}
+
+// 清理已经退出的容器遗留的挂载点和cgroup
+func cleanupContainerResources(info *ContainerInfo, mounts []string) {
+	for _, mnt := range mounts {
+		if err := syscall.Unmount(mnt, syscall.MNT_DETACH); err != nil {
+			logrus.Debugf("unmount %s of container %s:%v", mnt, info.Name, err)
+		}
+	}
+	if info.CgroupPath == "" {
+		return
+	}
+	cgroupManager := subsystems.NewCgroupManager(info.CgroupPath)
+	if err := cgroupManager.Destroy(); err != nil {
+		logrus.Debugf("destroy cgroup %s of container %s:%v", info.CgroupPath, info.Name, err)
+	}
+}
+
+// 当前mount namespace中容器目录下的挂载点，例如没有随容器退出卸载的rootfs，深的在前
+func containerMounts(containerID string) ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	prefix := ContainerRootDir(containerID) + "/"
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 4 && strings.HasPrefix(fields[4], prefix) {
+			mounts = append(mounts, fields[4])
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(mounts, func(i, j int) bool {
+		return len(mounts[i]) > len(mounts[j])
+	})
+	return mounts, nil
+}
+
+// 迁移状态目录到当前版本
+func MigrateState() error {
+	return stateStore.Migrate()
+}
+
+// 扫描所有容器，把已经不存在的容器标记为退出并清理已经退出的容器遗留的资源
+// 只在会修改容器状态的命令中调用，查询命令只在读取时修正状态
+func CleanupOrphans() error {
+	ids, err := stateStore.ListContainers()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		// 读取时会修正状态
+		info, err := GetContainerInfo(id)
+		if err != nil {
+			logrus.Warnf("get container info failed:%s", err.Error())
+			continue
+		}
+		if isActive(info) {
+			continue
+		}
+		mounts, err := containerMounts(id)
+		if err != nil {
+			return err
+		}
+		if len(mounts) == 0 && !subsystems.NewCgroupManager(info.CgroupPath).Exists() {
+			continue
+		}
+		if err = cleanupInactive(id, mounts); err != nil {
+			logrus.Warnf("cleanup container %s failed:%s", info.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// 在容器锁内确认容器没有被重新启动后再清理，启动容器时在锁内创建cgroup
+func cleanupInactive(containerID string, mounts []string) error {
+	lock, err := stateStore.LockContainer(containerID)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	var info ContainerInfo
+	if err = stateStore.ReadContainer(containerID, &info); err != nil {
+		return err
+	}
+	if !isActive(&info) {
+		cleanupContainerResources(&info, mounts)
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"mydocker/subsystems"
+	"mydocker/util"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	statsInterval = time.Second
+	// /proc/stat中的时间单位是USER_HZ，Linux上固定为100
+	clockTicksPerSecond = 100
+)
+
+type StatsOptions struct {
+	// 为空表示所有运行中的容器
+	Containers []string
+	// 只输出一次
+	NoStream bool
+	// go模板，或者json表示每行输出一个json
+	Format string
+}
+
+// 一个容器的资源使用情况
+type ContainerStats struct {
+	Id            string  `json:"id"`
+	Name          string  `json:"name"`
+	CpuPercent    float64 `json:"cpuPercent"`
+	MemoryUsage   uint64  `json:"memoryUsage"`
+	MemoryLimit   uint64  `json:"memoryLimit"`
+	MemoryCache   uint64  `json:"memoryCache"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	NetRx         uint64  `json:"netRx"`
+	NetTx         uint64  `json:"netTx"`
+	BlockRead     uint64  `json:"blockRead"`
+	BlockWrite    uint64  `json:"blockWrite"`
+	Pids          uint64  `json:"pids"`
+}
+
+// 某一时刻的采样，CPU使用率由两次采样的差值计算
+type statsSample struct {
+	info      *ContainerInfo
+	stats     *subsystems.Stats
+	systemCpu uint64
+	netRx     uint64
+	netTx     uint64
+}
+
+func ShowStats(opts StatsOptions) error {
+	if opts.Format != "" && opts.Format != "json" {
+		if _, err := util.ParseTemplate(opts.Format); err != nil {
+			return err
+		}
+	}
+	// 先采样一次，第一次输出时就能算出CPU使用率
+	prev, err := sampleContainers(opts.Containers)
+	if err != nil {
+		return err
+	}
+	for {
+		time.Sleep(statsInterval)
+		current, err := sampleContainers(opts.Containers)
+		if err != nil {
+			return err
+		}
+		var result []*ContainerStats
+		for _, sample := range current {
+			result = append(result, calculateStats(sample, prev[sample.info.Id]))
+		}
+		if err = printStats(result, opts); err != nil {
+			return err
+		}
+		if opts.NoStream {
+			return nil
+		}
+		prev = make(map[string]*statsSample)
+		for _, sample := range current {
+			prev[sample.info.Id] = sample
+		}
+	}
+}
+
+// 对指定的或者所有运行中的容器采样，采样期间退出的容器会被跳过
+func sampleContainers(refs []string) (map[string]*statsSample, error) {
+	var infos []*ContainerInfo
+	if len(refs) == 0 {
+		ids, err := stateStore.ListContainers()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			info, err := GetContainerInfo(id)
+			if err != nil {
+				logrus.Warnf("get container info failed:%s", err.Error())
+				continue
+			}
+			if info.Status == Running || info.Status == Paused {
+				infos = append(infos, info)
+			}
+		}
+	} else {
+		for _, ref := range refs {
+			info, err := LookupContainer(ref)
+			if err != nil {
+				return nil, err
+			}
+			if info.Status != Running && info.Status != Paused {
+				return nil, fmt.Errorf("container %s is not running", ref)
+			}
+			infos = append(infos, info)
+		}
+	}
+	systemCpu, err := systemCpuUsage()
+	if err != nil {
+		return nil, err
+	}
+	samples := make(map[string]*statsSample)
+	for _, info := range infos {
+		stats, err := subsystems.GetStats(info.CgroupPath)
+		if err != nil {
+			logrus.Warnf("get stats of container %s:%v", info.Name, err)
+			continue
+		}
+		sample := &statsSample{info: info, stats: stats, systemCpu: systemCpu}
+		sample.netRx, sample.netTx, _ = networkUsage(info.Pid)
+		samples[info.Id] = sample
+	}
+	return samples, nil
+}
+
+// 计算方式和docker相同：容器CPU时间的增量除以系统CPU时间的增量再乘以CPU个数
+func calculateStats(sample, prev *statsSample) *ContainerStats {
+	stats := sample.stats
+	result := &ContainerStats{
+		Id:          sample.info.Id,
+		Name:        sample.info.Name,
+		MemoryUsage: stats.MemoryUsage,
+		MemoryLimit: stats.MemoryLimit,
+		MemoryCache: stats.MemoryCache,
+		NetRx:       sample.netRx,
+		NetTx:       sample.netTx,
+		BlockRead:   stats.BlkioRead,
+		BlockWrite:  stats.BlkioWrite,
+		Pids:        stats.Pids,
+	}
+	// 没有限制时显示主机的内存总量
+	if total, err := hostMemoryTotal(); err == nil && (result.MemoryLimit == 0 || result.MemoryLimit > total) {
+		result.MemoryLimit = total
+	}
+	if result.MemoryLimit > 0 {
+		result.MemoryPercent = float64(result.MemoryUsage) / float64(result.MemoryLimit) * 100
+	}
+	if prev != nil && sample.systemCpu > prev.systemCpu && stats.CpuUsage >= prev.stats.CpuUsage {
+		cpus, err := subsystems.OnlineCpuCount()
+		if err != nil {
+			cpus = 1
+		}
+		cpuDelta := float64(stats.CpuUsage - prev.stats.CpuUsage)
+		systemDelta := float64(sample.systemCpu - prev.systemCpu)
+		result.CpuPercent = cpuDelta / systemDelta * float64(cpus) * 100
+	}
+	return result
+}
+
+func printStats(result []*ContainerStats, opts StatsOptions) error {
+	if done, err := util.FormatList(os.Stdout, result, opts.Format, false, nil); done {
+		return err
+	}
+	if !opts.NoStream {
+		// 清屏后把光标移到左上角，刷新整个表格
+		fmt.Print("\033[2J\033[H")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "CONTAINER ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tNET I/O\tBLOCK I/O\tPIDS\n")
+	for _, item := range result {
+		fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%s / %s\t%.2f%%\t%s / %s\t%s / %s\t%d\n",
+			ShortID(item.Id), item.Name, item.CpuPercent,
+			util.BytesSize(float64(item.MemoryUsage)), util.BytesSize(float64(item.MemoryLimit)), item.MemoryPercent,
+			util.HumanSize(float64(item.NetRx)), util.HumanSize(float64(item.NetTx)),
+			util.HumanSize(float64(item.BlockRead)), util.HumanSize(float64(item.BlockWrite)),
+			item.Pids)
+	}
+	return w.Flush()
+}
+
+// 读取/proc/stat中所有CPU的累计时间，单位纳秒
+func systemCpuUsage() (uint64, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "cpu" {
+			continue
+		}
+		var ticks uint64
+		for _, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid /proc/stat line %q", scanner.Text())
+			}
+			ticks += value
+		}
+		return ticks * uint64(time.Second) / clockTicksPerSecond, nil
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no cpu line in /proc/stat")
+}
+
+// 从容器进程看到的/proc/<pid>/net/dev读取容器network namespace的流量，不包含lo
+func networkUsage(pid string) (rx, tx uint64, err error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%s/net/dev", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 格式为 "  eth0: rx_bytes rx_packets ... tx_bytes ..."，前两行是表头
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "lo" {
+			continue
+		}
+		fields := strings.Fields(kv[1])
+		if len(fields) < 9 {
+			continue
+		}
+		rxBytes, _ := strconv.ParseUint(fields[0], 10, 64)
+		txBytes, _ := strconv.ParseUint(fields[8], 10, 64)
+		rx += rxBytes
+		tx += txBytes
+	}
+	return rx, tx, scanner.Err()
+}
+
+func hostMemoryTotal() (uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// MemTotal:       16305800 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "MemTotal:" {
+			total, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return total * 1024, nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+}
@@ -0,0 +1,63 @@
+package container
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"mydocker/subsystems"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// 停止容器，先发送镜像中指定的停止信号，默认为SIGTERM，超时后发送SIGKILL
+// 状态会先被置为stop，这样等待容器的进程就不会再按重启策略拉起容器
+func StopContainer(containerRef string, timeout time.Duration) error {
+	// 查找时会修正已经退出的容器的状态
+	info, err := LookupContainer(containerRef)
+	if err != nil {
+		return err
+	}
+	// 在容器锁内修改状态，等待容器的进程据此不再启动或重启容器
+	var status string
+	info, err = UpdateContainerInfo(info.Id, func(info *ContainerInfo) error {
+		status = info.Status
+		if status == Created || status == Running || status == Paused || status == Restarting {
+			info.Status = Stop
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if status != Running && status != Paused {
+		return nil
+	}
+	pid, err := strconv.Atoi(info.Pid)
+	if err != nil {
+		return fmt.Errorf("invalid pid %s of container %s", info.Pid, info.Name)
+	}
+	if err = syscall.Kill(pid, info.stopSignal()); err != nil {
+		if err == syscall.ESRCH {
+			return nil
+		}
+		return err
+	}
+	// 冻结的进程收不到信号，发送信号后解冻容器
+	if status == Paused {
+		if err = subsystems.Thaw(info.CgroupPath); err != nil {
+			return err
+		}
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if syscall.Kill(pid, 0) == syscall.ESRCH {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	logrus.Infof("container %s did not exit in %s, kill it", info.Name, timeout)
+	if err = syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"mydocker/subsystems"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// 容器内的一个进程
+type ProcessInfo struct {
+	User string
+	Pid  int
+	Ppid int
+	// 容器pid namespace中看到的pid
+	NsPid   int
+	Cpu     float64
+	Rss     uint64
+	CpuTime uint64
+	Cmd     string
+}
+
+// 列出容器内的进程，指定了ps参数时调用ps并只保留容器内的进程
+func Top(containerRef string, psArgs []string) error {
+	info, err := LookupContainer(containerRef)
+	if err != nil {
+		return err
+	}
+	if info.Status != Running && info.Status != Paused {
+		return fmt.Errorf("container %s is not running", containerRef)
+	}
+	pids, err := subsystems.GetPids(info.CgroupPath)
+	if err != nil {
+		return err
+	}
+	if len(psArgs) > 0 {
+		return psTop(pids, psArgs)
+	}
+	var processes []*ProcessInfo
+	for _, pid := range pids {
+		process, err := readProcessInfo(pid)
+		if err != nil {
+			// 读取期间退出的进程
+			continue
+		}
+		processes = append(processes, process)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 8, 1, 3, ' ', 0)
+	fmt.Fprint(w, "USER\tPID\tPPID\tCONTAINER PID\t%CPU\tRSS\tTIME\tCMD\n")
+	for _, p := range processes {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.1f\t%d\t%s\t%s\n",
+			p.User, p.Pid, p.Ppid, p.NsPid, p.Cpu, p.Rss, formatCpuTime(p.CpuTime), p.Cmd)
+	}
+	return w.Flush()
+}
+
+func readProcessInfo(pid int) (*ProcessInfo, error) {
+	fields, err := readProcStat(pid)
+	if err != nil {
+		return nil, err
+	}
+	process := &ProcessInfo{Pid: pid}
+	process.Ppid, _ = strconv.Atoi(fields[1])
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	startTime, _ := strconv.ParseUint(fields[19], 10, 64)
+	rssPages, _ := strconv.ParseUint(fields[21], 10, 64)
+	// 单位是秒
+	process.CpuTime = (utime + stime) / clockTicksPerSecond
+	// RSS单位是KB
+	process.Rss = rssPages * uint64(os.Getpagesize()) / 1024
+	// 和ps一样，用CPU时间除以进程启动以来经过的时间
+	if uptime, err := systemUptime(); err == nil {
+		elapsed := uptime - float64(startTime)/clockTicksPerSecond
+		if elapsed > 0 {
+			process.Cpu = float64(utime+stime) / clockTicksPerSecond / elapsed * 100
+		}
+	}
+
+	status, err := readProcStatus(pid)
+	if err != nil {
+		return nil, err
+	}
+	process.User = status["Uid"]
+	if uids := strings.Fields(status["Uid"]); len(uids) > 0 {
+		process.User = uids[0]
+		if u, err := user.LookupId(uids[0]); err == nil {
+			process.User = u.Username
+		}
+	}
+	// NSpid的最后一个值是最内层pid namespace中的pid
+	if nspids := strings.Fields(status["NSpid"]); len(nspids) > 0 {
+		process.NsPid, _ = strconv.Atoi(nspids[len(nspids)-1])
+	}
+
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil, err
+	}
+	process.Cmd = strings.TrimSpace(strings.Replace(string(cmdline), "\x00", " ", -1))
+	if process.Cmd == "" {
+		// 内核线程或者僵尸进程没有cmdline
+		process.Cmd = "[" + status["Name"] + "]"
+	}
+	return process, nil
+}
+
+// 读取/proc/<pid>/status，每行是"Key:\tvalue"
+func readProcStatus(pid int) (map[string]string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	status := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) == 2 {
+			status[kv[0]] = strings.TrimSpace(kv[1])
+		}
+	}
+	return status, scanner.Err()
+}
+
+func systemUptime() (float64, error) {
+	b, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid /proc/uptime")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// 按照ps的格式输出CPU时间 [DD-]HH:MM:SS
+func formatCpuTime(seconds uint64) string {
+	days := seconds / 86400
+	s := fmt.Sprintf("%02d:%02d:%02d", seconds%86400/3600, seconds%3600/60, seconds%60)
+	if days > 0 {
+		return fmt.Sprintf("%d-%s", days, s)
+	}
+	return s
+}
+
+// 执行ps，按表头中PID列的位置过滤出容器内的进程
+func psTop(pids []int, psArgs []string) error {
+	output, err := exec.Command("ps", psArgs...).Output()
+	if err != nil {
+		return fmt.Errorf("run ps %s:%v", strings.Join(psArgs, " "), err)
+	}
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	pidIndex := -1
+	for i, name := range strings.Fields(lines[0]) {
+		if name == "PID" {
+			pidIndex = i
+			break
+		}
+	}
+	if pidIndex == -1 {
+		return fmt.Errorf("couldn't find PID field in ps output")
+	}
+	inContainer := make(map[int]bool)
+	for _, pid := range pids {
+		inContainer[pid] = true
+	}
+	fmt.Println(lines[0])
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) <= pidIndex {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[pidIndex])
+		if err != nil {
+			return fmt.Errorf("unexpected pid %q in ps output", fields[pidIndex])
+		}
+		if inContainer[pid] {
+			fmt.Println(line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package container
+
+import (
+	"fmt"
+	"mydocker/subsystems"
+)
+
+// 修改容器的资源限制，fn在容器锁内修改配置，只能修改fn中设置的项
+// 运行中的容器立即写入cgroup，其它状态的容器在下次启动时生效
+func UpdateContainerResources(containerRef string, fn func(config *subsystems.ResourceConfig) error) (*ContainerInfo, error) {
+	info, err := LookupContainer(containerRef)
+	if err != nil {
+		return nil, err
+	}
+	return UpdateContainerInfo(info.Id, func(info *ContainerInfo) error {
+		config := subsystems.ResourceConfig{}
+		if info.ResourceConfig != nil {
+			config = *info.ResourceConfig
+		}
+		if err := fn(&config); err != nil {
+			return err
+		}
+		if config.MemorySwap > 0 && config.Memory > config.MemorySwap {
+			return fmt.Errorf("memory limit should be smaller than already set memory swap limit %d", config.MemorySwap)
+		}
+		if err := subsystems.ValidateMemoryReservation(config.Memory, config.MemoryReservation); err != nil {
+			return err
+		}
+		if info.Status == Running || info.Status == Paused {
+			// 只写入这次修改的项，其它限制保持不变
+			changed := subsystems.ResourceConfig{}
+			if err := fn(&changed); err != nil {
+				return err
+			}
+			if err := subsystems.CheckAvailable(&changed); err != nil {
+				return err
+			}
+			if err := validateUsage(info.CgroupPath, &changed); err != nil {
+				return err
+			}
+			if err := subsystems.NewCgroupManager(info.CgroupPath).Update(&changed); err != nil {
+				return fmt.Errorf("update cgroup of container %s:%v", info.Name, err)
+			}
+		}
+		info.ResourceConfig = &config
+		return nil
+	})
+}
+
+// 新的限制不能低于容器当前的使用量
+func validateUsage(cgroupPath string, config *subsystems.ResourceConfig) error {
+	if config.Memory > 0 {
+		usage, err := subsystems.GetMemoryUsage(cgroupPath)
+		if err != nil {
+			return err
+		}
+		if config.Memory < usage {
+			return fmt.Errorf("memory limit %d is below current memory usage %d", config.Memory, usage)
+		}
+	}
+	if config.PidsLimit > 0 {
+		current, err := subsystems.GetPidsCurrent(cgroupPath)
+		if err != nil {
+			return err
+		}
+		if uint64(config.PidsLimit) < current {
+			return fmt.Errorf("pids limit %d is below current number of processes %d", config.PidsLimit, current)
+		}
+	}
+	return nil
+}
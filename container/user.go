@@ -0,0 +1,106 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 容器进程的用户，从容器内的/etc/passwd和/etc/group解析
+type ExecUser struct {
+	Uid    int
+	Gid    int
+	Groups []int
+	Home   string
+}
+
+// 解析 user[:group]，user和group可以是名字或者数字，名字从给定的passwd和group文件中查找
+func lookupExecUser(spec, passwdPath, groupPath string) (*ExecUser, error) {
+	userSpec, groupSpec := spec, ""
+	if i := strings.IndexByte(spec, ':'); i != -1 {
+		userSpec, groupSpec = spec[:i], spec[i+1:]
+	}
+	execUser := &ExecUser{Home: "/"}
+	passwd, _ := readColonFile(passwdPath)
+	uid, err := strconv.Atoi(userSpec)
+	found := false
+	for _, fields := range passwd {
+		if len(fields) < 6 {
+			continue
+		}
+		entryUid, _ := strconv.Atoi(fields[2])
+		if fields[0] == userSpec || (err == nil && entryUid == uid) {
+			execUser.Uid = entryUid
+			execUser.Gid, _ = strconv.Atoi(fields[3])
+			execUser.Home = fields[5]
+			userSpec = fields[0]
+			found = true
+			break
+		}
+	}
+	if !found {
+		// 数字的uid可以不在passwd中
+		if err != nil {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userSpec)
+		}
+		execUser.Uid = uid
+	}
+
+	groups, _ := readColonFile(groupPath)
+	if groupSpec != "" {
+		gid, err := strconv.Atoi(groupSpec)
+		found = false
+		for _, fields := range groups {
+			if len(fields) < 3 {
+				continue
+			}
+			entryGid, _ := strconv.Atoi(fields[2])
+			if fields[0] == groupSpec || (err == nil && entryGid == gid) {
+				execUser.Gid = entryGid
+				found = true
+				break
+			}
+		}
+		if !found {
+			if err != nil {
+				return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupSpec)
+			}
+			execUser.Gid = gid
+		}
+		return execUser, nil
+	}
+	// 没有指定组时加入用户所在的附加组
+	for _, fields := range groups {
+		if len(fields) < 4 {
+			continue
+		}
+		for _, member := range strings.Split(fields[3], ",") {
+			if found && member == userSpec {
+				gid, _ := strconv.Atoi(fields[2])
+				execUser.Groups = append(execUser.Groups, gid)
+			}
+		}
+	}
+	return execUser, nil
+}
+
+// 读取以冒号分隔的文件，忽略空行和注释
+func readColonFile(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries [][]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, strings.Split(line, ":"))
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,71 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLookupExecUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwd := filepath.Join(dir, "passwd")
+	group := filepath.Join(dir, "group")
+	if err = ioutil.WriteFile(passwd, []byte(`# comment
+root:x:0:0:root:/root:/bin/sh
+bob:x:1000:1000::/home/bob:/bin/sh
+
+broken:x
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(group, []byte(`root:x:0:
+bob:x:1000:
+staff:x:50:bob,alice
+wheel:x:10:alice
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		spec    string
+		want    ExecUser
+		wantErr bool
+	}{
+		{"root", ExecUser{Uid: 0, Gid: 0, Home: "/root"}, false},
+		{"bob", ExecUser{Uid: 1000, Gid: 1000, Groups: []int{50}, Home: "/home/bob"}, false},
+		{"1000", ExecUser{Uid: 1000, Gid: 1000, Groups: []int{50}, Home: "/home/bob"}, false},
+		{"bob:wheel", ExecUser{Uid: 1000, Gid: 10, Home: "/home/bob"}, false},
+		{"bob:50", ExecUser{Uid: 1000, Gid: 50, Home: "/home/bob"}, false},
+		{"bob:4000", ExecUser{Uid: 1000, Gid: 4000, Home: "/home/bob"}, false},
+		{"4000", ExecUser{Uid: 4000, Gid: 0, Home: "/"}, false},
+		{"4000:4000", ExecUser{Uid: 4000, Gid: 4000, Home: "/"}, false},
+		{"nobody", ExecUser{}, true},
+		{"bob:nogroup", ExecUser{}, true},
+	}
+	for _, tt := range tests {
+		got, err := lookupExecUser(tt.spec, passwd, group)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("lookupExecUser(%q) = %+v, want error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("lookupExecUser(%q) error = %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("lookupExecUser(%q) = %+v, want %+v", tt.spec, *got, tt.want)
+		}
+	}
+	// 镜像中没有passwd时只能使用数字
+	if got, err := lookupExecUser("123:45", filepath.Join(dir, "missing"), filepath.Join(dir, "missing")); err != nil ||
+		got.Uid != 123 || got.Gid != 45 {
+		t.Errorf("lookupExecUser without passwd = %+v, %v", got, err)
+	}
+}
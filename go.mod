@@ -3,4 +3,5 @@ module mydocker
 require (
 	github.com/sirupsen/logrus v1.2.0
 	github.com/urfave/cli v1.20.0
+	golang.org/x/sys v0.0.0-20180905080454-ebe1bf3edb33
 )
@@ -0,0 +1,380 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ociLayoutFile      = "oci-layout"
+	ociIndexFile       = "index.json"
+	ociLayoutVersion   = "1.0.0"
+	dockerManifestFile = "manifest.json"
+)
+
+type ociLayout struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+// docker save生成的manifest.json中的一项
+type dockerArchiveEntry struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// load导入的一个镜像，没有名字时Ref为空
+type LoadedImage struct {
+	Ref string
+	Id  Digest
+}
+
+// 把镜像按OCI image layout写成tar归档
+func (s *Store) Save(w io.Writer, refs []string) error {
+	index := &Index{SchemaVersion: 2, MediaType: MediaTypeIndex, Manifests: []Descriptor{}}
+	var blobs []Digest
+	seen := make(map[Digest]bool)
+	addBlob := func(d Digest) {
+		if !seen[d] {
+			seen[d] = true
+			blobs = append(blobs, d)
+		}
+	}
+	for _, ref := range refs {
+		img, err := s.Lookup(ref)
+		if err != nil {
+			return err
+		}
+		fi, err := os.Stat(s.BlobPath(img.Id))
+		if err != nil {
+			return err
+		}
+		desc := Descriptor{MediaType: img.Manifest.MediaType, Digest: img.Id, Size: fi.Size()}
+		if desc.MediaType == "" {
+			desc.MediaType = MediaTypeManifest
+		}
+		// 按镜像名保存时记录名字，按ID保存时load后没有名字
+		names, err := s.ReferencesOf(img.Id)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if name == NormalizeReference(ref) {
+				desc.Annotations = map[string]string{
+					AnnotationImageName: name,
+					AnnotationRefName:   name[strings.LastIndex(name, ":")+1:],
+				}
+			}
+		}
+		index.Manifests = append(index.Manifests, desc)
+		addBlob(img.Id)
+		addBlob(img.Manifest.Config.Digest)
+		for _, layer := range img.Manifest.Layers {
+			addBlob(layer.Digest)
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	layout, err := json.Marshal(&ociLayout{ImageLayoutVersion: ociLayoutVersion})
+	if err != nil {
+		return err
+	}
+	if err = writeTarFile(tw, ociLayoutFile, layout); err != nil {
+		return err
+	}
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if err = writeTarFile(tw, ociIndexFile, indexBytes); err != nil {
+		return err
+	}
+	for _, dir := range []string{"blobs/", "blobs/sha256/"} {
+		if err = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir, Mode: 0755}); err != nil {
+			return err
+		}
+	}
+	for _, d := range blobs {
+		if err = s.writeTarBlob(tw, d); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, content []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+		return err
+	}
+	_, err := tw.Write(content)
+	return err
+}
+
+func (s *Store) writeTarBlob(tw *tar.Writer, d Digest) error {
+	fi, err := os.Stat(s.BlobPath(d))
+	if err != nil {
+		return err
+	}
+	r, err := s.OpenBlob(d)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: "blobs/sha256/" + d.Hex(), Mode: 0644, Size: fi.Size()}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+// 导入OCI image layout或者docker save格式的tar归档，按归档中的名字打tag
+func (s *Store) Load(r io.Reader) ([]LoadedImage, error) {
+	if err := os.MkdirAll(s.Root, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(s.Root, ".tmp-load-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err = extractArchive(r, dir); err != nil {
+		return nil, fmt.Errorf("read archive:%v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, ociLayoutFile)); err == nil {
+		return s.loadOCILayout(dir)
+	}
+	if _, err = os.Stat(filepath.Join(dir, dockerManifestFile)); err == nil {
+		return s.loadDockerArchive(dir)
+	}
+	return nil, fmt.Errorf("archive is neither an OCI image layout nor a docker save archive")
+}
+
+// 把tar归档解到目录中，只保留普通文件、目录和链接，路径都限制在目录内
+func extractArchive(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	links := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean("/" + hdr.Name)
+		target := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			// 旧版本的docker save用符号链接复用相同的层
+			links[name] = filepath.Clean(filepath.Join("/", filepath.Dir(name), hdr.Linkname))
+		case tar.TypeLink:
+			links[name] = filepath.Clean("/" + hdr.Linkname)
+		}
+	}
+	// 链接指向的文件可能在链接之后才出现，最后统一创建为硬链接
+	for name, source := range links {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			return err
+		}
+		if err := os.Link(filepath.Join(dir, source), filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从目录中读取blob，路径由摘要决定
+func layoutBlobSource(dir string) BlobSource {
+	return func(desc Descriptor) (io.ReadCloser, error) {
+		if err := desc.Digest.Validate(); err != nil {
+			return nil, err
+		}
+		f, err := os.Open(filepath.Join(dir, "blobs", "sha256", desc.Digest.Hex()))
+		if err != nil {
+			return nil, fmt.Errorf("blob %s is missing in the archive", desc.Digest)
+		}
+		return f, nil
+	}
+}
+
+func readJSONFile(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s:%v", filepath.Base(path), err)
+	}
+	return nil
+}
+
+func (s *Store) loadOCILayout(dir string) ([]LoadedImage, error) {
+	layout := &ociLayout{}
+	if err := readJSONFile(filepath.Join(dir, ociLayoutFile), layout); err != nil {
+		return nil, err
+	}
+	if layout.ImageLayoutVersion != ociLayoutVersion {
+		return nil, fmt.Errorf("unsupported image layout version %q", layout.ImageLayoutVersion)
+	}
+	index := &Index{}
+	if err := readJSONFile(filepath.Join(dir, ociIndexFile), index); err != nil {
+		return nil, err
+	}
+	fetch := layoutBlobSource(dir)
+	var loaded []LoadedImage
+	for _, desc := range index.Manifests {
+		manifestDesc := desc
+		manifestBytes, err := readVerifiedBlob(fetch, manifestDesc)
+		if err != nil {
+			return nil, err
+		}
+		// 多平台的镜像只导入当前平台的manifest
+		if isManifestList(desc.MediaType) {
+			list := &Index{}
+			if err = json.Unmarshal(manifestBytes, list); err != nil {
+				return nil, fmt.Errorf("invalid index %s:%v", desc.Digest, err)
+			}
+			if manifestDesc, err = MatchPlatform(list); err != nil {
+				return nil, err
+			}
+			if manifestBytes, err = readVerifiedBlob(fetch, manifestDesc); err != nil {
+				return nil, err
+			}
+		}
+		id, err := s.ImportImage(manifestBytes, fetch)
+		if err != nil {
+			return nil, err
+		}
+		ref := desc.Annotations[AnnotationImageName]
+		if ref == "" {
+			// ref.name只有tag时无法得到镜像名
+			if name := desc.Annotations[AnnotationRefName]; strings.ContainsAny(name, ":/") {
+				ref = name
+			}
+		}
+		if ref != "" {
+			if err = s.Tag(ref, id); err != nil {
+				return nil, err
+			}
+			ref = NormalizeReference(ref)
+		}
+		loaded = append(loaded, LoadedImage{Ref: ref, Id: id})
+	}
+	return loaded, nil
+}
+
+// 读取整个blob并校验摘要
+func readVerifiedBlob(fetch BlobSource, desc Descriptor) ([]byte, error) {
+	r, err := fetch(desc)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if actual := FromBytes(b); actual != desc.Digest {
+		return nil, fmt.Errorf("blob %s has digest %s", desc.Digest, actual)
+	}
+	return b, nil
+}
+
+// docker save的归档中配置和层按路径引用，层通常是未压缩的tar
+func (s *Store) loadDockerArchive(dir string) ([]LoadedImage, error) {
+	var entries []dockerArchiveEntry
+	if err := readJSONFile(filepath.Join(dir, dockerManifestFile), &entries); err != nil {
+		return nil, err
+	}
+	var loaded []LoadedImage
+	for _, entry := range entries {
+		paths := make(map[Digest]string)
+		configPath := filepath.Join(dir, filepath.Clean("/"+entry.Config))
+		configDesc, err := describeFile(configPath, MediaTypeConfig)
+		if err != nil {
+			return nil, err
+		}
+		// 配置的文件名就是它的摘要
+		if name := strings.TrimSuffix(filepath.Base(entry.Config), ".json"); Digest(sha256Prefix+name).Validate() == nil &&
+			sha256Prefix+name != configDesc.Digest.String() {
+			return nil, fmt.Errorf("config %s has digest %s", entry.Config, configDesc.Digest)
+		}
+		paths[configDesc.Digest] = configPath
+		manifest := &Manifest{SchemaVersion: 2, MediaType: MediaTypeManifest, Config: configDesc, Layers: []Descriptor{}}
+		for _, layer := range entry.Layers {
+			layerPath := filepath.Join(dir, filepath.Clean("/"+layer))
+			layerDesc, err := describeFile(layerPath, MediaTypeLayerUncompressed)
+			if err != nil {
+				return nil, err
+			}
+			paths[layerDesc.Digest] = layerPath
+			manifest.Layers = append(manifest.Layers, layerDesc)
+		}
+		manifestBytes, err := json.Marshal(manifest)
+		if err != nil {
+			return nil, err
+		}
+		id, err := s.ImportImage(manifestBytes, func(desc Descriptor) (io.ReadCloser, error) {
+			return os.Open(paths[desc.Digest])
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(entry.RepoTags) == 0 {
+			loaded = append(loaded, LoadedImage{Id: id})
+		}
+		for _, ref := range entry.RepoTags {
+			if err = s.Tag(ref, id); err != nil {
+				return nil, err
+			}
+			loaded = append(loaded, LoadedImage{Ref: NormalizeReference(ref), Id: id})
+		}
+	}
+	return loaded, nil
+}
+
+// 计算文件的摘要，gzip压缩的层使用压缩层的类型
+func describeFile(path, mediaType string) (Descriptor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	if magic, _ := br.Peek(2); mediaType == MediaTypeLayerUncompressed && isGzip(magic) {
+		mediaType = MediaTypeLayer
+	}
+	digester := newDigester()
+	size, err := io.Copy(digester, br)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	return Descriptor{MediaType: mediaType, Digest: digester.Digest(), Size: size}, nil
+}
@@ -0,0 +1,64 @@
+package image
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mydocker/store"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// build的缓存，记录每一步的key到生成的镜像ID
+const buildCacheFile = "buildcache.json"
+
+// 缓存的key由parent镜像、展开变量后的指令和复制的文件内容的摘要决定
+func BuildCacheKey(parent Digest, instruction string, content Digest) string {
+	return FromBytes([]byte(strings.Join([]string{parent.String(), instruction, content.String()}, "\n"))).String()
+}
+
+func (s *Store) readBuildCache() (map[string]Digest, error) {
+	cache := make(map[string]Digest)
+	b, err := ioutil.ReadFile(filepath.Join(s.Root, buildCacheFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// 查找缓存的镜像，镜像已经不存在时当作没有缓存
+func (s *Store) GetBuildCache(key string) (Digest, bool) {
+	cache, err := s.readBuildCache()
+	if err != nil {
+		return "", false
+	}
+	id, ok := cache[key]
+	if !ok || !s.HasBlob(id) {
+		return "", false
+	}
+	return id, true
+}
+
+func (s *Store) SetBuildCache(key string, id Digest) error {
+	lock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	cache, err := s.readBuildCache()
+	if err != nil {
+		return err
+	}
+	cache[key] = id
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(filepath.Join(s.Root, buildCacheFile), b, 0644)
+}
@@ -0,0 +1,211 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// commit --change可以修改的指令，写法和Dockerfile相同
+var changeInstructions = []string{"CMD", "ENTRYPOINT", "ENV", "EXPOSE", "LABEL", "STOPSIGNAL", "USER", "VOLUME", "WORKDIR"}
+
+// 按Dockerfile指令修改镜像配置，例如 CMD ["/bin/sh"] 或者 ENV A=1 B=2
+func ApplyChange(config *Config, change string) error {
+	instruction, args := splitInstruction(change)
+	switch strings.ToUpper(instruction) {
+	case "CMD":
+		config.Cmd = ParseCommand(args)
+	case "ENTRYPOINT":
+		config.Entrypoint = ParseCommand(args)
+	case "ENV":
+		pairs, err := ParseKeyValues(args, true)
+		if err != nil {
+			return fmt.Errorf("ENV %s", err.Error())
+		}
+		var env []string
+		for _, kv := range pairs {
+			env = append(env, kv[0]+"="+kv[1])
+		}
+		config.Env = MergeEnv(config.Env, env)
+	case "LABEL":
+		pairs, err := ParseKeyValues(args, false)
+		if err != nil {
+			return fmt.Errorf("LABEL %s", err.Error())
+		}
+		if config.Labels == nil {
+			config.Labels = make(map[string]string)
+		}
+		for _, kv := range pairs {
+			config.Labels[kv[0]] = kv[1]
+		}
+	case "EXPOSE":
+		for _, port := range strings.Fields(args) {
+			normalized, err := NormalizePort(port)
+			if err != nil {
+				return err
+			}
+			if config.ExposedPorts == nil {
+				config.ExposedPorts = make(map[string]struct{})
+			}
+			config.ExposedPorts[normalized] = struct{}{}
+		}
+	case "VOLUME":
+		volumes := parseList(args)
+		if len(volumes) == 0 {
+			return fmt.Errorf("VOLUME requires at least one argument")
+		}
+		if config.Volumes == nil {
+			config.Volumes = make(map[string]struct{})
+		}
+		for _, v := range volumes {
+			config.Volumes[v] = struct{}{}
+		}
+	case "USER":
+		if args == "" {
+			return fmt.Errorf("USER requires exactly one argument")
+		}
+		config.User = args
+	case "WORKDIR":
+		if args == "" {
+			return fmt.Errorf("WORKDIR requires exactly one argument")
+		}
+		// 相对路径基于当前的工作目录
+		if !path.IsAbs(args) {
+			args = path.Join("/", config.WorkingDir, args)
+		}
+		config.WorkingDir = path.Clean(args)
+	case "STOPSIGNAL":
+		if args == "" {
+			return fmt.Errorf("STOPSIGNAL requires exactly one argument")
+		}
+		config.StopSignal = args
+	default:
+		return fmt.Errorf("%s is not a valid change command, supported: %s", instruction, strings.Join(changeInstructions, ", "))
+	}
+	return nil
+}
+
+// 拆出指令名和参数
+func splitInstruction(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	i := strings.IndexAny(line, " \t")
+	if i == -1 {
+		return line, ""
+	}
+	return line[:i], strings.TrimSpace(line[i+1:])
+}
+
+// json数组形式原样使用，否则作为shell命令用/bin/sh -c执行
+func ParseCommand(args string) []string {
+	var command []string
+	if strings.HasPrefix(args, "[") && json.Unmarshal([]byte(args), &command) == nil {
+		return command
+	}
+	if args == "" {
+		return nil
+	}
+	return []string{"/bin/sh", "-c", args}
+}
+
+// json数组或者空白分隔的列表
+func parseList(args string) []string {
+	var list []string
+	if strings.HasPrefix(args, "[") && json.Unmarshal([]byte(args), &list) == nil {
+		return list
+	}
+	return strings.Fields(args)
+}
+
+// 端口没有协议时默认为tcp，例如 80 -> 80/tcp
+func NormalizePort(port string) (string, error) {
+	proto := "tcp"
+	if i := strings.IndexByte(port, '/'); i != -1 {
+		port, proto = port[:i], strings.ToLower(port[i+1:])
+	}
+	if proto != "tcp" && proto != "udp" && proto != "sctp" {
+		return "", fmt.Errorf("invalid proto %q in port %s", proto, port)
+	}
+	// 支持端口范围，例如 8000-8010
+	for _, p := range strings.SplitN(port, "-", 2) {
+		if n, err := strconv.ParseUint(p, 10, 16); err != nil || n == 0 {
+			return "", fmt.Errorf("invalid port %q", port)
+		}
+	}
+	return port + "/" + proto, nil
+}
+
+// 解析 KEY=VALUE KEY2="VALUE 2" 形式的参数，legacy为true时也支持ENV的旧写法 KEY VALUE
+func ParseKeyValues(args string, legacy bool) ([][2]string, error) {
+	words, err := SplitWords(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("requires at least one argument")
+	}
+	if !strings.Contains(words[0], "=") {
+		if !legacy {
+			return nil, fmt.Errorf("requires KEY=VALUE, got %q", words[0])
+		}
+		key, value := splitInstruction(args)
+		if value == "" {
+			return nil, fmt.Errorf("requires KEY VALUE or KEY=VALUE")
+		}
+		return [][2]string{{key, value}}, nil
+	}
+	var pairs [][2]string
+	for _, word := range words {
+		kv := strings.SplitN(word, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("requires KEY=VALUE, got %q", word)
+		}
+		pairs = append(pairs, [2]string{kv[0], kv[1]})
+	}
+	return pairs, nil
+}
+
+// 按空白拆分参数，支持单引号、双引号和反斜杠转义
+func SplitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range s {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unmatched quote in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
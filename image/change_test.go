@@ -0,0 +1,81 @@
+package image
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyChange(t *testing.T) {
+	tests := []struct {
+		change  string
+		base    Config
+		want    Config
+		wantErr bool
+	}{
+		{change: `CMD ["/bin/sh", "-c", "ls"]`, want: Config{Cmd: []string{"/bin/sh", "-c", "ls"}}},
+		{change: `CMD ls -l`, want: Config{Cmd: []string{"/bin/sh", "-c", "ls -l"}}},
+		{change: `cmd [broken`, want: Config{Cmd: []string{"/bin/sh", "-c", "[broken"}}},
+		{change: `ENTRYPOINT ["/app"]`, want: Config{Entrypoint: []string{"/app"}}},
+		{
+			change: `ENV A=1 B="two words"`,
+			base:   Config{Env: []string{"A=0", "PATH=/bin"}},
+			want:   Config{Env: []string{"A=1", "PATH=/bin", "B=two words"}},
+		},
+		{change: `ENV KEY some value`, want: Config{Env: []string{"KEY=some value"}}},
+		{change: `ENV`, wantErr: true},
+		{change: `LABEL version=1.0 "maintainer"="a b"`, want: Config{Labels: map[string]string{"version": "1.0", "maintainer": "a b"}}},
+		{change: `LABEL version 1.0`, wantErr: true},
+		{change: `EXPOSE 80 53/udp 8000-8010`, want: Config{ExposedPorts: map[string]struct{}{"80/tcp": {}, "53/udp": {}, "8000-8010/tcp": {}}}},
+		{change: `EXPOSE 0`, wantErr: true},
+		{change: `EXPOSE 80/icmp`, wantErr: true},
+		{change: `VOLUME ["/data", "/logs"]`, want: Config{Volumes: map[string]struct{}{"/data": {}, "/logs": {}}}},
+		{change: `VOLUME /data /logs`, want: Config{Volumes: map[string]struct{}{"/data": {}, "/logs": {}}}},
+		{change: `VOLUME`, wantErr: true},
+		{change: `USER bob:staff`, want: Config{User: "bob:staff"}},
+		{change: `USER`, wantErr: true},
+		{change: `WORKDIR /opt/app/`, want: Config{WorkingDir: "/opt/app"}},
+		{change: `WORKDIR sub`, base: Config{WorkingDir: "/opt"}, want: Config{WorkingDir: "/opt/sub"}},
+		{change: `WORKDIR sub`, want: Config{WorkingDir: "/sub"}},
+		{change: `STOPSIGNAL SIGINT`, want: Config{StopSignal: "SIGINT"}},
+		{change: `RUN ls`, wantErr: true},
+	}
+	for _, tt := range tests {
+		config := tt.base
+		err := ApplyChange(&config, tt.change)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ApplyChange(%q) succeeded, want error", tt.change)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ApplyChange(%q) error = %v", tt.change, err)
+			continue
+		}
+		if !reflect.DeepEqual(config, tt.want) {
+			t.Errorf("ApplyChange(%q) = %+v, want %+v", tt.change, config, tt.want)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"a b\tc", []string{"a", "b", "c"}, false},
+		{`a="b c" d`, []string{"a=b c", "d"}, false},
+		{`'a \b' "c \"d\""`, []string{`a \b`, `c "d"`}, false},
+		{`a\ b`, []string{"a b"}, false},
+		{`""`, []string{""}, false},
+		{`"open`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := SplitWords(tt.s)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitWords(%q) = %q, %v, want %q", tt.s, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// 内容的sha256摘要，格式为 sha256:<64位十六进制>
+type Digest string
+
+const sha256Prefix = "sha256:"
+
+func FromBytes(b []byte) Digest {
+	sum := sha256.Sum256(b)
+	return Digest(sha256Prefix + hex.EncodeToString(sum[:]))
+}
+
+func ParseDigest(s string) (Digest, error) {
+	d := Digest(s)
+	if err := d.Validate(); err != nil {
+		return "", err
+	}
+	return d, nil
+}
+
+func (d Digest) Validate() error {
+	s := string(d)
+	if !strings.HasPrefix(s, sha256Prefix) {
+		return fmt.Errorf("unsupported digest %q, only sha256 is supported", s)
+	}
+	hexPart := s[len(sha256Prefix):]
+	if len(hexPart) != sha256.Size*2 {
+		return fmt.Errorf("invalid digest %q", s)
+	}
+	if _, err := hex.DecodeString(hexPart); err != nil || strings.ToLower(hexPart) != hexPart {
+		return fmt.Errorf("invalid digest %q", s)
+	}
+	return nil
+}
+
+func (d Digest) Hex() string {
+	return strings.TrimPrefix(string(d), sha256Prefix)
+}
+
+func (d Digest) String() string {
+	return string(d)
+}
+
+// 截取前12位作为短ID
+func (d Digest) ShortID() string {
+	h := d.Hex()
+	if len(h) > 12 {
+		return h[:12]
+	}
+	return h
+}
+
+// 边写边计算摘要和大小
+type digester struct {
+	hash hash.Hash
+	size int64
+}
+
+func newDigester() *digester {
+	return &digester{hash: sha256.New()}
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	return d.hash.Write(p)
+}
+
+func (d *digester) Size() int64 {
+	return d.size
+}
+
+func (d *digester) Digest() Digest {
+	return Digest(sha256Prefix + hex.EncodeToString(d.hash.Sum(nil)))
+}
@@ -0,0 +1,40 @@
+package image
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDigestValidate(t *testing.T) {
+	valid := "sha256:" + strings.Repeat("a1", 32)
+	tests := []struct {
+		digest  string
+		wantErr bool
+	}{
+		{valid, false},
+		{FromBytes(nil).String(), false},
+		{"", true},
+		{strings.Repeat("a1", 32), true},
+		{"sha512:" + strings.Repeat("a1", 32), true},
+		{"sha256:" + strings.Repeat("a1", 31), true},
+		{"sha256:" + strings.Repeat("a1", 33), true},
+		{"sha256:" + strings.Repeat("A1", 32), true},
+		{"sha256:" + strings.Repeat("g1", 32), true},
+		{"sha256:../" + strings.Repeat("a", 61), true},
+	}
+	for _, tt := range tests {
+		if err := Digest(tt.digest).Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Digest(%q).Validate() = %v, want error %v", tt.digest, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFromBytes(t *testing.T) {
+	want := Digest("sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	if d := FromBytes(nil); d != want {
+		t.Errorf("FromBytes(nil) = %s, want %s", d, want)
+	}
+	if id := want.ShortID(); id != "e3b0c44298fc" {
+		t.Errorf("ShortID() = %s", id)
+	}
+}
@@ -0,0 +1,176 @@
+package image
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Dockerfile中的一条指令，Flags是指令名之后以--开头的参数，例如COPY --chown=1000
+type Instruction struct {
+	Line     int
+	Name     string
+	Flags    []string
+	Args     string
+	Original string
+}
+
+func (i Instruction) String() string {
+	return i.Original
+}
+
+// 支持的Dockerfile指令
+var buildInstructions = map[string]bool{
+	"FROM": true, "RUN": true, "COPY": true, "ADD": true, "ENV": true, "WORKDIR": true,
+	"USER": true, "ENTRYPOINT": true, "CMD": true, "EXPOSE": true, "LABEL": true,
+	"ARG": true, "STOPSIGNAL": true, "VOLUME": true,
+}
+
+// 解析Dockerfile，忽略空行和注释，行尾的反斜杠表示指令在下一行继续
+func ParseDockerfile(r io.Reader) ([]Instruction, error) {
+	var instructions []Instruction
+	var current []string
+	start := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		// 续行中间的注释和空行也忽略
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if len(current) == 0 {
+			start = lineNo
+		}
+		if strings.HasSuffix(line, "\\") {
+			current = append(current, strings.TrimSpace(strings.TrimSuffix(line, "\\")))
+			continue
+		}
+		current = append(current, line)
+		instruction, err := parseInstruction(strings.Join(current, " "), start)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+		current = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(current) > 0 {
+		instruction, err := parseInstruction(strings.Join(current, " "), start)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+	}
+	if len(instructions) == 0 {
+		return nil, fmt.Errorf("the Dockerfile cannot be empty")
+	}
+	if instructions[0].Name != "FROM" && instructions[0].Name != "ARG" {
+		return nil, fmt.Errorf("line %d: the first instruction must be FROM", instructions[0].Line)
+	}
+	return instructions, nil
+}
+
+func parseInstruction(line string, lineNo int) (Instruction, error) {
+	name, args := splitInstruction(line)
+	instruction := Instruction{Line: lineNo, Name: strings.ToUpper(name), Original: line}
+	if !buildInstructions[instruction.Name] {
+		return instruction, fmt.Errorf("line %d: unknown instruction: %s", lineNo, name)
+	}
+	// 只有COPY和ADD支持--开头的参数
+	if instruction.Name == "COPY" || instruction.Name == "ADD" {
+		for strings.HasPrefix(args, "--") {
+			var flag string
+			flag, args = splitInstruction(args)
+			instruction.Flags = append(instruction.Flags, flag)
+		}
+	}
+	instruction.Args = args
+	if args == "" {
+		return instruction, fmt.Errorf("line %d: %s requires at least one argument", lineNo, instruction.Name)
+	}
+	return instruction, nil
+}
+
+// 替换 $VAR、${VAR}、${VAR:-default} 和 ${VAR:+value}，单引号中的内容和 \$ 不替换
+func Expand(s string, lookup func(name string) (string, bool)) (string, error) {
+	var result strings.Builder
+	inSingleQuote, inDoubleQuote := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'' && !inDoubleQuote:
+			inSingleQuote = !inSingleQuote
+			result.WriteByte(c)
+		case inSingleQuote:
+			result.WriteByte(c)
+		case c == '"':
+			inDoubleQuote = !inDoubleQuote
+			result.WriteByte(c)
+		case c == '\\' && i+1 < len(s) && s[i+1] == '$':
+			result.WriteByte('$')
+			i++
+		case c == '\\' && i+1 < len(s):
+			// 其他转义原样保留，由后面拆分参数时处理
+			result.WriteByte(c)
+			result.WriteByte(s[i+1])
+			i++
+		case c == '$' && i+1 < len(s) && s[i+1] == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end == -1 {
+				return "", fmt.Errorf("missing '}' in %q", s)
+			}
+			value, err := expandBraces(s[i+2:i+end], lookup)
+			if err != nil {
+				return "", err
+			}
+			result.WriteString(value)
+			i += end
+		case c == '$' && i+1 < len(s) && isVariableChar(s[i+1]):
+			j := i + 1
+			for j < len(s) && isVariableChar(s[j]) {
+				j++
+			}
+			value, _ := lookup(s[i+1 : j])
+			result.WriteString(value)
+			i = j - 1
+		default:
+			result.WriteByte(c)
+		}
+	}
+	return result.String(), nil
+}
+
+func expandBraces(expr string, lookup func(name string) (string, bool)) (string, error) {
+	name, modifier, word := expr, "", ""
+	if i := strings.Index(expr, ":"); i != -1 {
+		name, modifier = expr[:i], expr[i:]
+		if len(modifier) < 2 || (modifier[1] != '-' && modifier[1] != '+') {
+			return "", fmt.Errorf("unsupported modifier in ${%s}", expr)
+		}
+		modifier, word = modifier[:2], modifier[2:]
+	}
+	if name == "" || strings.IndexFunc(name, func(r rune) bool { return r > 127 || !isVariableChar(byte(r)) }) != -1 {
+		return "", fmt.Errorf("invalid variable name in ${%s}", expr)
+	}
+	value, ok := lookup(name)
+	switch modifier {
+	case ":-":
+		if !ok || value == "" {
+			return word, nil
+		}
+	case ":+":
+		if ok && value != "" {
+			return word, nil
+		}
+		return "", nil
+	}
+	return value, nil
+}
+
+func isVariableChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,99 @@
+package image
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDockerfile(t *testing.T) {
+	dockerfile := `# syntax comment
+ARG VER=1.0
+FROM busybox:$VER
+
+RUN echo a \
+    # comment inside a continuation
+    && echo b
+copy --chown=1000:1000 --from=x src /dst
+CMD ["/bin/sh"]
+`
+	instructions, err := ParseDockerfile(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Instruction{
+		{Line: 2, Name: "ARG", Args: "VER=1.0", Original: "ARG VER=1.0"},
+		{Line: 3, Name: "FROM", Args: "busybox:$VER", Original: "FROM busybox:$VER"},
+		{Line: 5, Name: "RUN", Args: "echo a && echo b", Original: "RUN echo a && echo b"},
+		{
+			Line:     8,
+			Name:     "COPY",
+			Flags:    []string{"--chown=1000:1000", "--from=x"},
+			Args:     "src /dst",
+			Original: "copy --chown=1000:1000 --from=x src /dst",
+		},
+		{Line: 9, Name: "CMD", Args: `["/bin/sh"]`, Original: `CMD ["/bin/sh"]`},
+	}
+	if !reflect.DeepEqual(instructions, want) {
+		t.Errorf("ParseDockerfile() =\n%+v\nwant\n%+v", instructions, want)
+	}
+
+	for _, bad := range []string{
+		"",
+		"# only a comment\n",
+		"RUN ls\n",
+		"FROM busybox\nFOO bar\n",
+		"FROM busybox\nRUN\n",
+		"FROM busybox\nCOPY --chown=1\n",
+	} {
+		if _, err := ParseDockerfile(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseDockerfile(%q) succeeded, want error", bad)
+		}
+	}
+	// 文件结尾的续行
+	instructions, err = ParseDockerfile(strings.NewReader("FROM busybox\nRUN a \\"))
+	if err != nil || len(instructions) != 2 || instructions[1].Args != "a" {
+		t.Errorf("trailing continuation: %+v, %v", instructions, err)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{"A": "1", "EMPTY": "", "NAME_2": "x"}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+	tests := []struct {
+		s       string
+		want    string
+		wantErr bool
+	}{
+		{"plain", "plain", false},
+		{"$A", "1", false},
+		{"${A}b", "1b", false},
+		{"$A$NAME_2", "1x", false},
+		{"$MISSING-", "-", false},
+		{"${MISSING:-def}", "def", false},
+		{"${EMPTY:-def}", "def", false},
+		{"${A:-def}", "1", false},
+		{"${A:+set}", "set", false},
+		{"${EMPTY:+set}", "", false},
+		{"${MISSING:+set}", "", false},
+		{`\$A`, "$A", false},
+		{`'$A'`, `'$A'`, false},
+		{`"$A"`, `"1"`, false},
+		{`"it's $A"`, `"it's 1"`, false},
+		{`a\ b`, `a\ b`, false},
+		{"cost $", "cost $", false},
+		{"${A", "", true},
+		{"${}", "", true},
+		{"${A:?err}", "", true},
+		{"${A-B}", "", true},
+	}
+	for _, tt := range tests {
+		got, err := Expand(tt.s, lookup)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Expand(%q) = %q, %v, want %q", tt.s, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// 按描述获取blob的内容，导入镜像时从归档文件或者仓库读取
+type BlobSource func(desc Descriptor) (io.ReadCloser, error)
+
+func isManifestList(mediaType string) bool {
+	return mediaType == MediaTypeIndex || mediaType == MediaTypeDockerManifestList
+}
+
+func isGzip(magic []byte) bool {
+	return len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b
+}
+
+// 从多平台的镜像中选出当前平台的manifest
+func MatchPlatform(index *Index) (Descriptor, error) {
+	for _, desc := range index.Manifests {
+		if desc.Platform == nil {
+			continue
+		}
+		if desc.Platform.OS == runtime.GOOS && desc.Platform.Architecture == runtime.GOARCH {
+			return desc, nil
+		}
+	}
+	return Descriptor{}, fmt.Errorf("no matching manifest for %s/%s in the manifest list entries", runtime.GOOS, runtime.GOARCH)
+}
+
+// 导入manifest和它引用的配置、层，所有内容都按摘要校验，返回镜像ID
+// 未压缩的层会被压缩后保存，此时manifest随之改变，镜像ID也和原来不同
+func (s *Store) ImportImage(manifestBytes []byte, fetch BlobSource) (Digest, error) {
+	manifest := &Manifest{}
+	if err := json.Unmarshal(manifestBytes, manifest); err != nil {
+		return "", fmt.Errorf("invalid manifest:%v", err)
+	}
+	if manifest.SchemaVersion != 2 {
+		return "", fmt.Errorf("unsupported manifest schema version %d", manifest.SchemaVersion)
+	}
+	if isManifestList(manifest.MediaType) {
+		return "", fmt.Errorf("expected an image manifest, got %s", manifest.MediaType)
+	}
+	config := &Image{}
+	if err := s.importBlob(manifest.Config, fetch); err != nil {
+		return "", err
+	}
+	if err := s.ReadJSON(manifest.Config.Digest, config); err != nil {
+		return "", fmt.Errorf("read config %s:%v", manifest.Config.Digest, err)
+	}
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return "", fmt.Errorf("image has %d layers but %d diff ids", len(manifest.Layers), len(config.RootFS.DiffIDs))
+	}
+	layers := make([]Descriptor, len(manifest.Layers))
+	rewritten := false
+	for i, layer := range manifest.Layers {
+		desc, err := s.importLayer(layer, config.RootFS.DiffIDs[i], fetch)
+		if err != nil {
+			return "", err
+		}
+		layers[i] = desc
+		rewritten = rewritten || desc.Digest != layer.Digest
+	}
+	var id Digest
+	if rewritten {
+		configDesc := Descriptor{MediaType: MediaTypeConfig, Digest: manifest.Config.Digest, Size: manifest.Config.Size}
+		desc, err := s.PutJSON(&Manifest{
+			SchemaVersion: 2,
+			MediaType:     MediaTypeManifest,
+			Config:        configDesc,
+			Layers:        layers,
+		}, MediaTypeManifest)
+		if err != nil {
+			return "", err
+		}
+		id = desc.Digest
+	} else {
+		d, _, err := s.PutBlob(bytes.NewReader(manifestBytes), "")
+		if err != nil {
+			return "", err
+		}
+		id = d
+	}
+	// 解压所有层，同时校验diff_id
+	img, err := s.GetImage(id)
+	if err != nil {
+		return "", err
+	}
+	if _, err = s.PrepareLayers(img); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// 本地没有时获取blob，校验摘要和大小
+func (s *Store) importBlob(desc Descriptor, fetch BlobSource) error {
+	if err := desc.Digest.Validate(); err != nil {
+		return err
+	}
+	if s.HasBlob(desc.Digest) {
+		return nil
+	}
+	r, err := fetch(desc)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, size, err := s.PutBlob(r, desc.Digest)
+	if err != nil {
+		return fmt.Errorf("blob %s:%v", desc.Digest, err)
+	}
+	if desc.Size > 0 && size != desc.Size {
+		return fmt.Errorf("blob %s has size %d, expected %d", desc.Digest, size, desc.Size)
+	}
+	return nil
+}
+
+// 压缩的层原样保存，未压缩的层压缩后保存，返回保存后的层描述
+func (s *Store) importLayer(layer Descriptor, diffID Digest, fetch BlobSource) (Descriptor, error) {
+	switch layer.MediaType {
+	case MediaTypeLayer, MediaTypeDockerLayer:
+		if err := s.importBlob(layer, fetch); err != nil {
+			return Descriptor{}, err
+		}
+		return layer, nil
+	case MediaTypeLayerUncompressed:
+		// 未压缩的层的摘要就是diff_id
+		if layer.Digest != diffID {
+			return Descriptor{}, fmt.Errorf("layer %s does not match diff id %s", layer.Digest, diffID)
+		}
+		if err := layer.Digest.Validate(); err != nil {
+			return Descriptor{}, err
+		}
+		r, err := fetch(layer)
+		if err != nil {
+			return Descriptor{}, err
+		}
+		defer r.Close()
+		desc, actual, err := s.PutLayer(r)
+		if err != nil {
+			return Descriptor{}, fmt.Errorf("layer %s:%v", layer.Digest, err)
+		}
+		if actual != diffID {
+			return Descriptor{}, fmt.Errorf("layer %s has diff id %s, expected %s", layer.Digest, actual, diffID)
+		}
+		return desc, nil
+	}
+	return Descriptor{}, fmt.Errorf("unsupported layer media type %s", layer.MediaType)
+}
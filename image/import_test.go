@@ -0,0 +1,179 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 一个只有一层的镜像，blobs按摘要保存manifest引用的内容
+type testImage struct {
+	manifest Manifest
+	blobs    map[Digest][]byte
+	fetched  []Digest
+}
+
+func newTestImage(t *testing.T) *testImage {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "hello", Typeflag: tar.TypeReg, Mode: 0644, Size: 5}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write([]byte("hello"))
+	tw.Close()
+	diffID := FromBytes(buf.Bytes())
+	gzipped := new(bytes.Buffer)
+	gw := gzip.NewWriter(gzipped)
+	gw.Write(buf.Bytes())
+	gw.Close()
+	config, err := json.Marshal(&Image{
+		Architecture: "amd64",
+		OS:           "linux",
+		RootFS:       RootFS{Type: "layers", DiffIDs: []Digest{diffID}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := &testImage{blobs: make(map[Digest][]byte)}
+	img.manifest = Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeManifest,
+		Config:        img.add(MediaTypeConfig, config),
+		Layers:        []Descriptor{img.add(MediaTypeLayer, gzipped.Bytes())},
+	}
+	return img
+}
+
+func (img *testImage) add(mediaType string, b []byte) Descriptor {
+	d := FromBytes(b)
+	img.blobs[d] = b
+	return Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(b))}
+}
+
+func (img *testImage) fetch(desc Descriptor) (io.ReadCloser, error) {
+	img.fetched = append(img.fetched, desc.Digest)
+	b, ok := img.blobs[desc.Digest]
+	if !ok {
+		return nil, fmt.Errorf("blob %s not found", desc.Digest)
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (img *testImage) manifestBytes(t *testing.T) []byte {
+	b, err := json.Marshal(&img.manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestImportImageRejectsBadDigests(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("extracting layers requires root")
+	}
+	tests := []struct {
+		name    string
+		modify  func(img *testImage)
+		wantErr string
+	}{
+		{
+			name: "path in config digest",
+			modify: func(img *testImage) {
+				img.manifest.Config.Digest = Digest("sha256:../../../etc/" + strings.Repeat("a", 52))
+			},
+			wantErr: "invalid digest",
+		},
+		{
+			name: "unsupported algorithm",
+			modify: func(img *testImage) {
+				img.manifest.Layers[0].Digest = Digest("md5:" + strings.Repeat("a", 32))
+			},
+			wantErr: "only sha256 is supported",
+		},
+		{
+			name: "layer content does not match digest",
+			modify: func(img *testImage) {
+				d := img.manifest.Layers[0].Digest
+				img.blobs[d] = append([]byte(nil), img.blobs[d]...)
+				img.blobs[d][len(img.blobs[d])-1] ^= 0xff
+			},
+			wantErr: "digest mismatch",
+		},
+		{
+			name: "config content does not match digest",
+			modify: func(img *testImage) {
+				img.blobs[img.manifest.Config.Digest] = []byte(`{"rootfs":{"type":"layers","diff_ids":[]}}`)
+			},
+			wantErr: "digest mismatch",
+		},
+		{
+			name: "size mismatch",
+			modify: func(img *testImage) {
+				img.manifest.Layers[0].Size++
+			},
+			wantErr: "size",
+		},
+		{
+			name: "diff id mismatch",
+			modify: func(img *testImage) {
+				config := &Image{RootFS: RootFS{Type: "layers", DiffIDs: []Digest{FromBytes([]byte("other"))}}}
+				b, _ := json.Marshal(config)
+				img.manifest.Config = img.add(MediaTypeConfig, b)
+			},
+			wantErr: "diff id",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "import")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			s := New(root)
+			img := newTestImage(t)
+			tt.modify(img)
+			id, err := s.ImportImage(img.manifestBytes(t), img.fetch)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ImportImage() = %s, %v, want error containing %q", id, err, tt.wantErr)
+			}
+			// 不合法的摘要不会被用来获取blob，保存的blob都和文件名中的摘要一致
+			for _, d := range img.fetched {
+				if d.Validate() != nil {
+					t.Errorf("fetched blob with invalid digest %s", d)
+				}
+			}
+			fileList, _ := ioutil.ReadDir(filepath.Join(root, blobsDir, "sha256"))
+			for _, fileInfo := range fileList {
+				b, err := ioutil.ReadFile(filepath.Join(root, blobsDir, "sha256", fileInfo.Name()))
+				if err != nil || FromBytes(b).Hex() != fileInfo.Name() {
+					t.Errorf("blob %s does not match its digest", fileInfo.Name())
+				}
+			}
+		})
+	}
+
+	// 没有修改时可以正常导入
+	root, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s := New(root)
+	img := newTestImage(t)
+	id, err := s.ImportImage(img.manifestBytes(t), img.fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != FromBytes(img.manifestBytes(t)) {
+		t.Errorf("ImportImage() = %s, want the manifest digest", id)
+	}
+}
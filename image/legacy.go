@@ -0,0 +1,88 @@
+package image
+
+import (
+	"fmt"
+	"io"
+	"mydocker/archive"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// 以前的镜像是/root/test1下的tar包或者解压好的目录
+const legacyImageRoot = "/root/test1"
+
+// 按镜像名查找镜像，本地没有时尝试导入旧格式的同名镜像
+func (s *Store) Lookup(ref string) (*LocalImage, error) {
+	id, err := s.Resolve(ref)
+	if err != nil {
+		if id, err = s.importLegacy(ref); err != nil {
+			return nil, err
+		}
+	}
+	return s.GetImage(id)
+}
+
+// 把旧格式的镜像导入为只有一层的镜像
+func (s *Store) importLegacy(name string) (Digest, error) {
+	if filepath.Base(name) != name {
+		return "", fmt.Errorf("no such image: %s", name)
+	}
+	r, err := openLegacyImage(name)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	layer, diffID, err := s.PutLayer(r)
+	if err != nil {
+		return "", fmt.Errorf("import image %s:%v", name, err)
+	}
+	now := time.Now().UTC()
+	config := &Image{
+		Created:      now,
+		Architecture: runtime.GOARCH,
+		OS:           runtime.GOOS,
+		RootFS:       RootFS{Type: "layers", DiffIDs: []Digest{diffID}},
+		History: []History{{
+			Created:   now,
+			CreatedBy: "import " + name,
+		}},
+	}
+	id, err := s.CreateImage(config, []Descriptor{layer})
+	if err != nil {
+		return "", err
+	}
+	if err = s.Tag(name, id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// 返回旧格式镜像的未压缩tar流
+func openLegacyImage(name string) (io.ReadCloser, error) {
+	tarPath := filepath.Join(legacyImageRoot, name+".tar")
+	if f, err := os.Open(tarPath); err == nil {
+		// 旧的commit生成的是gzip压缩的tar包
+		rc, err := archive.DecompressStream(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &readCloser{Reader: rc, close: f.Close}, nil
+	}
+	dir := filepath.Join(legacyImageRoot, name)
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("no such image: %s", name)
+	}
+	return archive.Tar(dir), nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error {
+	return r.close()
+}
@@ -0,0 +1,56 @@
+package image
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mydocker/store"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const metadataDir = "metadata"
+
+// 只保存在本地的镜像信息，不属于镜像的内容，save和push时不会带上
+type Metadata struct {
+	// commit或者build时所基于的镜像
+	Parent      Digest    `json:"parent,omitempty"`
+	LastTagTime time.Time `json:"lastTagTime,omitempty"`
+}
+
+func (s *Store) metadataPath(id Digest) string {
+	return filepath.Join(s.Root, metadataDir, id.Hex()+".json")
+}
+
+// 读取镜像的本地信息，没有记录时返回空的信息
+func (s *Store) GetMetadata(id Digest) (*Metadata, error) {
+	m := &Metadata{}
+	b, err := ioutil.ReadFile(s.metadataPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// 修改镜像的本地信息，调用者需要持有存储的锁
+func (s *Store) updateMetadata(id Digest, fn func(m *Metadata)) error {
+	m, err := s.GetMetadata(id)
+	if err != nil {
+		return err
+	}
+	fn(m)
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Join(s.Root, metadataDir), 0755); err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(s.metadataPath(id), b, 0644)
+}
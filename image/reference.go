@@ -0,0 +1,95 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	DefaultDomain = "docker.io"
+	DefaultTag    = "latest"
+	// docker hub上的官方镜像在library下
+	officialRepoPrefix = "library/"
+)
+
+var (
+	pathComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*$`)
+	tagRegexp           = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+)
+
+// 镜像名，格式为 [domain/]path[:tag][@digest]
+type Reference struct {
+	// 仓库的地址，可以带端口
+	Domain string
+	Path   string
+	Tag    string
+	Digest Digest
+}
+
+// 解析镜像名，没有domain时为docker hub，没有tag和digest时tag为latest
+func ParseReference(s string) (*Reference, error) {
+	ref := &Reference{}
+	name := s
+	if i := strings.Index(name, "@"); i != -1 {
+		d, err := ParseDigest(name[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid reference format %q:%v", s, err)
+		}
+		ref.Digest = d
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+		if !tagRegexp.MatchString(ref.Tag) {
+			return nil, fmt.Errorf("invalid reference format %q: invalid tag", s)
+		}
+	}
+	// 第一段包含.或者:，或者是localhost时是仓库地址
+	ref.Domain, ref.Path = DefaultDomain, name
+	if i := strings.Index(name, "/"); i != -1 {
+		if first := name[:i]; strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.Domain, ref.Path = first, name[i+1:]
+		}
+	}
+	if ref.Domain == DefaultDomain && !strings.Contains(ref.Path, "/") {
+		ref.Path = officialRepoPrefix + ref.Path
+	}
+	for _, component := range strings.Split(ref.Path, "/") {
+		if !pathComponentRegexp.MatchString(component) {
+			return nil, fmt.Errorf("invalid reference format %q: repository name must be lowercase", s)
+		}
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = DefaultTag
+	}
+	return ref, nil
+}
+
+// 本地使用的仓库名，docker hub的镜像省略domain和library
+func (r *Reference) Name() string {
+	if r.Domain != DefaultDomain {
+		return r.Domain + "/" + r.Path
+	}
+	return strings.TrimPrefix(r.Path, officialRepoPrefix)
+}
+
+// 仓库中的引用，有digest时优先使用digest
+func (r *Reference) Reference() string {
+	if r.Digest != "" {
+		return r.Digest.String()
+	}
+	return r.Tag
+}
+
+func (r *Reference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest.String()
+	}
+	return s
+}
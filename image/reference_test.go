@@ -0,0 +1,52 @@
+package image
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	tests := []struct {
+		ref     string
+		want    Reference
+		name    string
+		wantErr bool
+	}{
+		{"busybox", Reference{Domain: DefaultDomain, Path: "library/busybox", Tag: "latest"}, "busybox", false},
+		{"busybox:1.36", Reference{Domain: DefaultDomain, Path: "library/busybox", Tag: "1.36"}, "busybox", false},
+		{"user/app", Reference{Domain: DefaultDomain, Path: "user/app", Tag: "latest"}, "user/app", false},
+		{"docker.io/library/busybox", Reference{Domain: DefaultDomain, Path: "library/busybox", Tag: "latest"}, "busybox", false},
+		{"localhost/app", Reference{Domain: "localhost", Path: "app", Tag: "latest"}, "localhost/app", false},
+		{"localhost:5000/a/b:v1", Reference{Domain: "localhost:5000", Path: "a/b", Tag: "v1"}, "localhost:5000/a/b", false},
+		{"quay.io/org/app", Reference{Domain: "quay.io", Path: "org/app", Tag: "latest"}, "quay.io/org/app", false},
+		{"busybox@" + digest, Reference{Domain: DefaultDomain, Path: "library/busybox", Digest: Digest(digest)}, "busybox", false},
+		{"busybox:1@" + digest, Reference{Domain: DefaultDomain, Path: "library/busybox", Tag: "1", Digest: Digest(digest)}, "busybox", false},
+		{"my-app_1.x", Reference{Domain: DefaultDomain, Path: "library/my-app_1.x", Tag: "latest"}, "my-app_1.x", false},
+		{"BusyBox", Reference{}, "", true},
+		{"busybox:", Reference{}, "", true},
+		{"busybox:-bad", Reference{}, "", true},
+		{"busybox@sha256:short", Reference{}, "", true},
+		{"a//b", Reference{}, "", true},
+		{"-app", Reference{}, "", true},
+	}
+	for _, tt := range tests {
+		ref, err := ParseReference(tt.ref)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseReference(%q) = %+v, want error", tt.ref, ref)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseReference(%q) error = %v", tt.ref, err)
+			continue
+		}
+		if *ref != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, want %+v", tt.ref, *ref, tt.want)
+		}
+		if ref.Name() != tt.name {
+			t.Errorf("ParseReference(%q).Name() = %q, want %q", tt.ref, ref.Name(), tt.name)
+		}
+	}
+}
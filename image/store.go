@@ -0,0 +1,521 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mydocker/archive"
+	"mydocker/store"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultRoot      = "/var/lib/mydocker/image"
+	blobsDir         = "blobs"
+	layersDir        = "layers"
+	layerSizesDir    = "layersizes"
+	repositoriesFile = "repositories.json"
+	lockFileName     = "lock"
+)
+
+// 本地镜像的存储，目录结构如下:
+//
+//	<root>/blobs/sha256/<hex>        按摘要保存的manifest、配置和压缩后的层
+//	<root>/layers/<diff_id hex>      解压后的层，所有容器共享，只读地作为overlay的lowerdir
+//	<root>/layersizes/<diff_id hex>  层未压缩时的大小
+//	<root>/repositories.json         镜像名到manifest摘要的映射
+//	<root>/buildcache.json           build每一步的缓存
+//	<root>/metadata/<hex>.json       只保存在本地的镜像信息，例如parent
+//	<root>/lock                      全局锁
+type Store struct {
+	Root string
+}
+
+func New(root string) *Store {
+	return &Store{Root: root}
+}
+
+// 本地的一个镜像，ID是manifest的摘要
+type LocalImage struct {
+	Id       Digest
+	Manifest *Manifest
+	Config   *Image
+}
+
+func (s *Store) lock() (*store.Lock, error) {
+	if err := os.MkdirAll(s.Root, 0755); err != nil {
+		return nil, err
+	}
+	return store.LockFile(filepath.Join(s.Root, lockFileName), syscall.LOCK_EX)
+}
+
+func (s *Store) BlobPath(d Digest) string {
+	return filepath.Join(s.Root, blobsDir, "sha256", d.Hex())
+}
+
+func (s *Store) HasBlob(d Digest) bool {
+	_, err := os.Stat(s.BlobPath(d))
+	return err == nil
+}
+
+// 写入一个blob，expected不为空时校验摘要，同样内容的blob只保存一份
+func (s *Store) PutBlob(r io.Reader, expected Digest) (Digest, int64, error) {
+	dir := filepath.Join(s.Root, blobsDir, "sha256")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	digester := newDigester()
+	size, err := io.Copy(io.MultiWriter(tmp, digester), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	d := digester.Digest()
+	if expected != "" && d != expected {
+		return "", 0, fmt.Errorf("digest mismatch: expected %s, got %s", expected, d)
+	}
+	if !s.HasBlob(d) {
+		if err = os.Rename(tmp.Name(), s.BlobPath(d)); err != nil {
+			return "", 0, err
+		}
+	}
+	return d, size, nil
+}
+
+// 读取blob，读完后如果内容和摘要不符会返回错误
+func (s *Store) OpenBlob(d Digest) (io.ReadCloser, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.BlobPath(d))
+	if err != nil {
+		return nil, err
+	}
+	return &verifyingReader{file: f, digester: newDigester(), expected: d}, nil
+}
+
+type verifyingReader struct {
+	file     *os.File
+	digester *digester
+	expected Digest
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.file.Read(p)
+	r.digester.Write(p[:n])
+	if err == io.EOF {
+		if actual := r.digester.Digest(); actual != r.expected {
+			return n, fmt.Errorf("blob %s is corrupted, actual digest %s", r.expected, actual)
+		}
+	}
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.file.Close()
+}
+
+func (s *Store) PutJSON(v interface{}, mediaType string) (Descriptor, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	d, size, err := s.PutBlob(bytes.NewReader(b), "")
+	if err != nil {
+		return Descriptor{}, err
+	}
+	return Descriptor{MediaType: mediaType, Digest: d, Size: size}, nil
+}
+
+func (s *Store) ReadJSON(d Digest, v interface{}) error {
+	r, err := s.OpenBlob(d)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// 把未压缩的tar压缩后保存为一层，返回层的描述和diff_id
+func (s *Store) PutLayer(r io.Reader) (Descriptor, Digest, error) {
+	pr, pw := io.Pipe()
+	diffDigester := newDigester()
+	go func() {
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(io.MultiWriter(gz, diffDigester), r)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	d, size, err := s.PutBlob(pr, "")
+	if err != nil {
+		pr.CloseWithError(err)
+		return Descriptor{}, "", err
+	}
+	diffID := diffDigester.Digest()
+	if err = s.setLayerSize(diffID, diffDigester.Size()); err != nil {
+		return Descriptor{}, "", err
+	}
+	return Descriptor{MediaType: MediaTypeLayer, Digest: d, Size: size}, diffID, nil
+}
+
+func (s *Store) LayerDir(diffID Digest) string {
+	return filepath.Join(s.Root, layersDir, diffID.Hex())
+}
+
+// 解压一层，已经解压过的层直接复用，解压时校验diff_id
+func (s *Store) ExtractLayer(layer Descriptor, diffID Digest) error {
+	dir := s.LayerDir(diffID)
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	lock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if _, err = os.Stat(dir); err == nil {
+		return nil
+	}
+	if err = os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmpDir, err := ioutil.TempDir(filepath.Dir(dir), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	// 层中没有根目录的记录时保持和普通目录一样的权限
+	if err = os.Chmod(tmpDir, 0755); err != nil {
+		return err
+	}
+	blob, err := s.OpenBlob(layer.Digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	// 仓库中的层也可能没有压缩
+	rc, err := archive.DecompressStream(blob)
+	if err != nil {
+		return fmt.Errorf("layer %s:%v", layer.Digest, err)
+	}
+	defer rc.Close()
+	digester := newDigester()
+	if err = archive.Untar(io.TeeReader(rc, digester), tmpDir); err != nil {
+		return fmt.Errorf("extract layer %s:%v", layer.Digest, err)
+	}
+	// tar读到结束标记就返回，把剩下的内容读完才能得到完整的摘要
+	if _, err = io.Copy(digester, rc); err != nil {
+		return fmt.Errorf("layer %s:%v", layer.Digest, err)
+	}
+	if actual := digester.Digest(); actual != diffID {
+		return fmt.Errorf("layer %s has diff id %s, expected %s", layer.Digest, actual, diffID)
+	}
+	if err = s.setLayerSize(diffID, digester.Size()); err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, dir)
+}
+
+// 保存配置和manifest，返回镜像ID
+func (s *Store) CreateImage(config *Image, layers []Descriptor) (Digest, error) {
+	if len(config.RootFS.DiffIDs) != len(layers) {
+		return "", fmt.Errorf("image has %d layers but %d diff ids", len(layers), len(config.RootFS.DiffIDs))
+	}
+	configDesc, err := s.PutJSON(config, MediaTypeConfig)
+	if err != nil {
+		return "", err
+	}
+	manifest := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeManifest,
+		Config:        configDesc,
+		Layers:        layers,
+	}
+	if manifest.Layers == nil {
+		manifest.Layers = []Descriptor{}
+	}
+	manifestDesc, err := s.PutJSON(manifest, MediaTypeManifest)
+	if err != nil {
+		return "", err
+	}
+	return manifestDesc.Digest, nil
+}
+
+// 在parent之上生成新镜像，layer为nil时只修改配置，历史中记为空层，本地记录parent
+func (s *Store) CreateChildImage(parent *LocalImage, runConfig *Config, layer *Descriptor, diffID Digest, history History) (Digest, error) {
+	config := *parent.Config
+	config.Created = time.Now().UTC()
+	config.Author = history.Author
+	config.Config = runConfig
+	config.RootFS.DiffIDs = append([]Digest{}, parent.Config.RootFS.DiffIDs...)
+	layers := append([]Descriptor{}, parent.Manifest.Layers...)
+	history.Created = config.Created
+	history.EmptyLayer = layer == nil
+	if layer != nil {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+		layers = append(layers, *layer)
+	}
+	config.History = append(append([]History{}, parent.Config.History...), history)
+	id, err := s.CreateImage(&config, layers)
+	if err != nil {
+		return "", err
+	}
+	lock, err := s.lock()
+	if err != nil {
+		return "", err
+	}
+	defer lock.Unlock()
+	if err = s.updateMetadata(id, func(m *Metadata) {
+		m.Parent = parent.Id
+	}); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// 没有层的空镜像的配置，FROM scratch时使用，创建时间为零值，每次生成的镜像ID相同
+func Scratch() *Image {
+	return &Image{
+		Architecture: runtime.GOARCH,
+		OS:           runtime.GOOS,
+		RootFS:       RootFS{Type: "layers", DiffIDs: []Digest{}},
+	}
+}
+
+// 读取镜像的manifest和配置
+func (s *Store) GetImage(id Digest) (*LocalImage, error) {
+	img := &LocalImage{Id: id, Manifest: &Manifest{}, Config: &Image{}}
+	if err := s.ReadJSON(id, img.Manifest); err != nil {
+		return nil, fmt.Errorf("read manifest of image %s:%v", id.ShortID(), err)
+	}
+	if err := s.ReadJSON(img.Manifest.Config.Digest, img.Config); err != nil {
+		return nil, fmt.Errorf("read config of image %s:%v", id.ShortID(), err)
+	}
+	if len(img.Config.RootFS.DiffIDs) != len(img.Manifest.Layers) {
+		return nil, fmt.Errorf("image %s has %d layers but %d diff ids",
+			id.ShortID(), len(img.Manifest.Layers), len(img.Config.RootFS.DiffIDs))
+	}
+	return img, nil
+}
+
+// 解压镜像的所有层，返回overlay的lowerdir列表，最上层在前
+func (s *Store) PrepareLayers(img *LocalImage) ([]string, error) {
+	dirs := make([]string, len(img.Manifest.Layers))
+	for i, layer := range img.Manifest.Layers {
+		diffID := img.Config.RootFS.DiffIDs[i]
+		if err := s.ExtractLayer(layer, diffID); err != nil {
+			return nil, err
+		}
+		dirs[len(dirs)-1-i] = s.LayerDir(diffID)
+	}
+	return dirs, nil
+}
+
+// 层未压缩时的大小，在保存和解压层时记录，不需要解压层
+func (s *Store) LayerSize(layer Descriptor, diffID Digest) (int64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.Root, layerSizesDir, diffID.Hex()))
+	if err == nil {
+		return strconv.ParseInt(string(b), 10, 64)
+	}
+	if !os.IsNotExist(err) {
+		return 0, err
+	}
+	// 以前保存的层没有记录大小，读一遍压缩的层计算后记录
+	blob, err := s.OpenBlob(layer.Digest)
+	if err != nil {
+		return 0, err
+	}
+	defer blob.Close()
+	rc, err := archive.DecompressStream(blob)
+	if err != nil {
+		return 0, fmt.Errorf("layer %s:%v", layer.Digest, err)
+	}
+	defer rc.Close()
+	size, err := io.Copy(ioutil.Discard, rc)
+	if err != nil {
+		return 0, fmt.Errorf("layer %s:%v", layer.Digest, err)
+	}
+	return size, s.setLayerSize(diffID, size)
+}
+
+func (s *Store) setLayerSize(diffID Digest, size int64) error {
+	if err := os.MkdirAll(filepath.Join(s.Root, layerSizesDir), 0755); err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(filepath.Join(s.Root, layerSizesDir, diffID.Hex()), []byte(strconv.FormatInt(size, 10)), 0644)
+}
+
+// 在镜像的层中从上往下查找文件，返回最上面一层中的路径，文件不存在或者已经被删除时返回空
+func (s *Store) LookupPath(img *LocalImage, p string) string {
+	diffIDs := img.Config.RootFS.DiffIDs
+	for i := len(diffIDs) - 1; i >= 0; i-- {
+		hostPath, hidden := lookupLayer(s.LayerDir(diffIDs[i]), p)
+		if hostPath != "" {
+			return hostPath
+		}
+		if hidden {
+			return ""
+		}
+	}
+	return ""
+}
+
+// 从根目录开始逐级查找，文件或者上级目录被删除、上级目录不是目录时下面的层中的文件不可见，
+// 上级目录不透明时这一层中没有的文件在下面的层中也不可见
+func lookupLayer(dir, p string) (hostPath string, hidden bool) {
+	opaque := false
+	parts := strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/")
+	for i, part := range parts {
+		// 以前用tar解压的层中whiteout还是.wh.开头的文件
+		if _, err := os.Lstat(filepath.Join(dir, archive.WhiteoutPrefix+part)); err == nil && part != "" {
+			return "", true
+		}
+		dir = filepath.Join(dir, part)
+		fi, err := os.Lstat(dir)
+		if err != nil {
+			return "", opaque
+		}
+		if archive.IsWhiteout(fi) {
+			return "", true
+		}
+		if i == len(parts)-1 {
+			return dir, false
+		}
+		if !fi.IsDir() {
+			return "", true
+		}
+		if archive.IsOpaque(dir) {
+			opaque = true
+		}
+	}
+	return "", opaque
+}
+
+// 镜像名没有tag时默认为latest
+func NormalizeReference(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref
+	}
+	return ref + ":latest"
+}
+
+func (s *Store) readRepositories() (map[string]Digest, error) {
+	repositories := make(map[string]Digest)
+	b, err := ioutil.ReadFile(filepath.Join(s.Root, repositoriesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return repositories, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &repositories); err != nil {
+		return nil, err
+	}
+	return repositories, nil
+}
+
+// 所有镜像名到镜像ID的映射
+func (s *Store) References() (map[string]Digest, error) {
+	return s.readRepositories()
+}
+
+// 返回指向镜像的所有镜像名
+func (s *Store) ReferencesOf(id Digest) ([]string, error) {
+	repositories, err := s.readRepositories()
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	for ref, target := range repositories {
+		if target == id {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+	return refs, nil
+}
+
+// 让镜像名指向镜像，已有的同名镜像会被覆盖
+func (s *Store) Tag(ref string, id Digest) error {
+	if !s.HasBlob(id) {
+		return fmt.Errorf("no such image: %s", id)
+	}
+	lock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	repositories, err := s.readRepositories()
+	if err != nil {
+		return err
+	}
+	repositories[NormalizeReference(ref)] = id
+	b, err := json.Marshal(repositories)
+	if err != nil {
+		return err
+	}
+	if err = store.WriteFileAtomic(filepath.Join(s.Root, repositoriesFile), b, 0644); err != nil {
+		return err
+	}
+	return s.updateMetadata(id, func(m *Metadata) {
+		m.LastTagTime = time.Now().UTC()
+	})
+}
+
+// 按镜像名、完整的镜像ID或者唯一的ID前缀查找镜像
+func (s *Store) Resolve(ref string) (Digest, error) {
+	repositories, err := s.readRepositories()
+	if err != nil {
+		return "", err
+	}
+	if id, ok := repositories[NormalizeReference(ref)]; ok {
+		return id, nil
+	}
+	prefix := strings.TrimPrefix(ref, sha256Prefix)
+	if len(prefix) == 0 || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("no such image: %s", ref)
+	}
+	if id := Digest(sha256Prefix + prefix); id.Validate() == nil && s.HasBlob(id) {
+		return id, nil
+	}
+	var found Digest
+	for _, id := range repositories {
+		if strings.HasPrefix(id.Hex(), prefix) {
+			if found != "" && found != id {
+				return "", fmt.Errorf("multiple images found with prefix %s", ref)
+			}
+			found = id
+		}
+	}
+	if found == "" {
+		return "", fmt.Errorf("no such image: %s", ref)
+	}
+	return found, nil
+}
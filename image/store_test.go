@@ -0,0 +1,92 @@
+package image
+
+import (
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 按描述创建文件：dir/为目录，+dir/为不透明的目录，-name为whiteout，其余为普通文件
+func createLayerFiles(t *testing.T, dir string, files []string) {
+	for _, f := range files {
+		path := filepath.Join(dir, strings.TrimLeft(f, "+-"))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		switch {
+		case strings.HasPrefix(f, "-"):
+			err = unix.Mknod(path, unix.S_IFCHR, 0)
+		case strings.HasSuffix(f, "/"):
+			if err = os.MkdirAll(path, 0755); err == nil && strings.HasPrefix(f, "+") {
+				err = unix.Lsetxattr(path, "trusted.overlay.opaque", []byte("y"), 0)
+			}
+		default:
+			err = ioutil.WriteFile(path, []byte(f), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// 第一个是最下面的层
+func createTestImage(t *testing.T, s *Store, layers [][]string) *LocalImage {
+	img := &LocalImage{Config: Scratch()}
+	for i, files := range layers {
+		diffID := FromBytes([]byte(strconv.Itoa(i)))
+		createLayerFiles(t, s.LayerDir(diffID), files)
+		img.Config.RootFS.DiffIDs = append(img.Config.RootFS.DiffIDs, diffID)
+	}
+	return img
+}
+
+func TestLookupPath(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating whiteouts requires root")
+	}
+	tests := []struct {
+		name   string
+		layers [][]string
+		path   string
+		// 找到文件的层，-1表示不存在
+		want int
+	}{
+		{"lower layer", [][]string{{"a/b"}, {"c"}}, "/a/b", 0},
+		{"upper layer wins", [][]string{{"a/b"}, {"a/b"}}, "/a/b", 1},
+		{"missing", [][]string{{"a/b"}}, "/a/c", -1},
+		{"root", [][]string{{"a/b"}}, "/", 0},
+		{"whiteout", [][]string{{"a/b"}, {"-a/b"}}, "/a/b", -1},
+		{"whiteout of parent", [][]string{{"a/b"}, {"-a"}}, "/a/b", -1},
+		{"parent re-created after whiteout", [][]string{{"a/b"}, {"-a"}, {"a/c"}}, "/a/b", -1},
+		{"parent replaced by a file", [][]string{{"a/b"}, {"a"}}, "/a/b", -1},
+		{"opaque parent", [][]string{{"a/b"}, {"+a/"}}, "/a/b", -1},
+		{"opaque grandparent", [][]string{{"a/b/c"}, {"+a/", "a/b/"}}, "/a/b/c", -1},
+		{"file in opaque parent", [][]string{{"a/b"}, {"+a/", "a/b"}}, "/a/b", 1},
+		{"opaque sibling", [][]string{{"a/b"}, {"+c/"}}, "/a/b", 0},
+		{"legacy whiteout", [][]string{{"a/b"}, {"a/.wh.b"}}, "/a/b", -1},
+		{"legacy whiteout of parent", [][]string{{"a/b"}, {".wh.a"}}, "/a/b", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "image")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			s := New(root)
+			img := createTestImage(t, s, tt.layers)
+			want := ""
+			if tt.want >= 0 {
+				want = filepath.Join(s.LayerDir(img.Config.RootFS.DiffIDs[tt.want]), tt.path)
+			}
+			if got := s.LookupPath(img, tt.path); got != want {
+				t.Errorf("LookupPath(%q) = %q, want %q", tt.path, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package image
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	MediaTypeIndex             = "application/vnd.oci.image.index.v1+json"
+	MediaTypeManifest          = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeConfig            = "application/vnd.oci.image.config.v1+json"
+	MediaTypeLayer             = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeLayerUncompressed = "application/vnd.oci.image.layer.v1.tar"
+
+	// docker的镜像格式，结构和OCI相同
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerConfig       = "application/vnd.docker.container.image.v1+json"
+	MediaTypeDockerLayer        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeDockerForeignLayer = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+)
+
+const (
+	// 镜像的完整名字，containerd和docker导入时使用
+	AnnotationImageName = "io.containerd.image.name"
+	// OCI规范中的镜像名，通常只有tag
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+)
+
+// 指向一个blob
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      Digest            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// OCI的image index或者docker的manifest list，指向多个平台的manifest
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// OCI镜像的manifest，layers按照从底到顶的顺序排列
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// OCI镜像的配置
+type Image struct {
+	Created      time.Time `json:"created"`
+	Author       string    `json:"author,omitempty"`
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Config       *Config   `json:"config,omitempty"`
+	RootFS       RootFS    `json:"rootfs"`
+	History      []History `json:"history,omitempty"`
+}
+
+// 用镜像运行容器时的默认配置，字段名和docker、OCI一致
+type Config struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+// 深拷贝，修改拷贝不影响原来的配置
+func (c *Config) Copy() *Config {
+	if c == nil {
+		return &Config{}
+	}
+	config := *c
+	config.Env = append([]string(nil), c.Env...)
+	config.Entrypoint = append([]string(nil), c.Entrypoint...)
+	config.Cmd = append([]string(nil), c.Cmd...)
+	config.ExposedPorts = copySet(c.ExposedPorts)
+	config.Volumes = copySet(c.Volumes)
+	if c.Labels != nil {
+		config.Labels = make(map[string]string)
+		for k, v := range c.Labels {
+			config.Labels[k] = v
+		}
+	}
+	return &config
+}
+
+func copySet(set map[string]struct{}) map[string]struct{} {
+	if set == nil {
+		return nil
+	}
+	result := make(map[string]struct{})
+	for k := range set {
+		result[k] = struct{}{}
+	}
+	return result
+}
+
+// 按变量名合并环境变量，overrides中的同名变量覆盖base中的，顺序保持不变
+func MergeEnv(base, overrides []string) []string {
+	env := append([]string(nil), base...)
+	for _, kv := range overrides {
+		key := strings.SplitN(kv, "=", 2)[0]
+		replaced := false
+		for i, existing := range env {
+			if strings.SplitN(existing, "=", 2)[0] == key {
+				env[i] = kv
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// diff_ids是每层未压缩的tar的摘要，和manifest中的layers一一对应
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []Digest `json:"diff_ids"`
+}
+
+type History struct {
+	Created    time.Time `json:"created"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	Author     string    `json:"author,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	EmptyLayer bool      `json:"empty_layer,omitempty"`
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"io/ioutil"
 	"mydocker/container"
+	"mydocker/image"
+	"mydocker/registry"
 	"mydocker/subsystems"
+	"mydocker/util"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var initCmd = cli.Command{
@@ -15,7 +23,7 @@ var initCmd = cli.Command{
 	Usage: "init some env",
 	Action: func(ctx *cli.Context) error {
 		log.Infof("init execute")
-		if err := container.InitContainerProcess(); err != nil {
+		if err := container.InitContainerProcess(ctx.Args().First()); err != nil {
 			return err
 		}
 		return nil
@@ -23,23 +31,89 @@ var initCmd = cli.Command{
 }
 
 var runCmd = cli.Command{
-	Name:  "run",
-	Usage: "create container",
+	Name:      "run",
+	Usage:     "create container",
+	ArgsUsage: "IMAGE [COMMAND] [ARG...]",
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name: "ti",
 		},
 		cli.StringFlag{
 			Name:  "m",
-			Usage: "memory limit",
+			Usage: "memory limit, e.g. 512m or 1g",
 		},
 		cli.StringFlag{
 			Name:  "cpushare",
-			Usage: "cpushare limit",
+			Usage: "cpu shares (relative weight)",
 		},
 		cli.StringFlag{
 			Name:  "cpuset",
-			Usage: "cpuset limit",
+			Usage: "cpus in which to allow execution, e.g. 0-2,4",
+		},
+		cli.StringFlag{
+			Name:  "cpus",
+			Usage: "number of cpus, e.g. 1.5",
+		},
+		cli.Uint64Flag{
+			Name:  "cpu-period",
+			Usage: "limit cpu CFS period in microseconds",
+		},
+		cli.Int64Flag{
+			Name:  "cpu-quota",
+			Usage: "limit cpu CFS quota in microseconds, -1 means unlimited",
+		},
+		cli.StringFlag{
+			Name:  "memory-swap",
+			Usage: "total memory plus swap limit, -1 means unlimited swap",
+		},
+		cli.StringFlag{
+			Name:  "memory-reservation",
+			Usage: "memory soft limit",
+		},
+		cli.IntFlag{
+			Name:  "memory-swappiness",
+			Usage: "tune container memory swappiness (0 to 100)",
+			Value: -1,
+		},
+		cli.StringFlag{
+			Name:  "kernel-memory",
+			Usage: "kernel memory limit",
+		},
+		cli.BoolFlag{
+			Name:  "oom-kill-disable",
+			Usage: "disable OOM killer",
+		},
+		cli.IntFlag{
+			Name:  "oom-score-adj",
+			Usage: "tune host's OOM preferences (-1000 to 1000)",
+		},
+		cli.Int64Flag{
+			Name:  "pids-limit",
+			Usage: "limit the number of processes, -1 means unlimited",
+		},
+		cli.Uint64Flag{
+			Name:  "blkio-weight",
+			Usage: "block IO relative weight, between 10 and 1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-read-bps",
+			Usage: "limit read rate from a device, e.g. /dev/sda:1mb",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-write-bps",
+			Usage: "limit write rate to a device, e.g. /dev/sda:1mb",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-read-iops",
+			Usage: "limit read rate (IO per second) from a device, e.g. /dev/sda:1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-write-iops",
+			Usage: "limit write rate (IO per second) to a device, e.g. /dev/sda:1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "add a host device to the container, e.g. /dev/sdc:/dev/xvdc:rwm",
 		},
 		cli.StringFlag{
 			Name:  "v",
@@ -53,110 +127,903 @@ var runCmd = cli.Command{
 			Name:  "name",
 			Usage: "create container with name",
 		},
+		cli.StringFlag{
+			Name:  "restart",
+			Usage: "restart policy: no|on-failure[:N]|always|unless-stopped",
+			Value: container.RestartNo,
+		},
+		cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "set metadata on container, key=value",
+		},
+		cli.StringFlag{
+			Name:  "entrypoint",
+			Usage: "overwrite the default ENTRYPOINT of the image",
+		},
+		cli.StringSliceFlag{
+			Name:  "env, e",
+			Usage: "set environment variables, KEY=VALUE or KEY to pass the value from host",
+		},
+		cli.StringFlag{
+			Name:  "workdir, w",
+			Usage: "working directory inside the container",
+		},
+		cli.StringFlag{
+			Name:  "user, u",
+			Usage: "username or UID (format: <name|uid>[:<group|gid>])",
+		},
 	},
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 1 {
-			return errors.New("at lease one arg on run")
+			return errors.New("at lease image on run")
 		}
-		commandArr := ctx.Args()
+		imageName := ctx.Args().Get(0)
+		commandArr := ctx.Args().Tail()
 		tty := ctx.Bool("ti")
 		detach := ctx.Bool("d")
 		if tty && detach {
 			return errors.New("can not get tty when detach")
 		}
-		resConfig := &subsystems.ResourceConfig{
-			MemoryLimit: ctx.String("m"),
-			CpuShare:    ctx.String("cpushare"),
-			CpuSet:      ctx.String("cpuset"),
+		resConfig, err := parseResourceConfig(ctx)
+		if err != nil {
+			return err
+		}
+		var devices []*container.Device
+		for _, v := range ctx.StringSlice("device") {
+			device, err := container.ParseDevice(v)
+			if err != nil {
+				return flagError("device", v, err)
+			}
+			devices = append(devices, device)
+			resConfig.Devices = append(resConfig.Devices, device.Rule())
+		}
+		restartPolicy, err := container.ParseRestartPolicy(ctx.String("restart"))
+		if err != nil {
+			return err
+		}
+		labels := make(map[string]string)
+		for _, label := range ctx.StringSlice("label") {
+			kv := strings.SplitN(label, "=", 2)
+			if len(kv) == 1 {
+				kv = append(kv, "")
+			}
+			labels[kv[0]] = kv[1]
+		}
+		oomScoreAdj := ctx.Int("oom-score-adj")
+		if oomScoreAdj < -1000 || oomScoreAdj > 1000 {
+			return flagError("oom-score-adj", strconv.Itoa(oomScoreAdj), errors.New("should be in range [-1000, 1000]"))
+		}
+		// 和镜像的配置合并后才是最终的配置
+		runConfig := &image.Config{
+			Cmd:        commandArr,
+			WorkingDir: ctx.String("workdir"),
+			User:       ctx.String("user"),
+		}
+		// 指定为空字符串时清除镜像的ENTRYPOINT
+		if ctx.IsSet("entrypoint") {
+			runConfig.Entrypoint = []string{ctx.String("entrypoint")}
+		}
+		for _, kv := range ctx.StringSlice("env") {
+			if !strings.Contains(kv, "=") {
+				// 只有变量名时使用主机上的值，主机上没有时忽略
+				value, ok := os.LookupEnv(kv)
+				if !ok {
+					continue
+				}
+				kv += "=" + value
+			}
+			runConfig.Env = append(runConfig.Env, kv)
+		}
+		info := &container.ContainerInfo{
+			Name:           ctx.String("name"),
+			Image:          imageName,
+			Config:         runConfig,
+			Volume:         ctx.String("v"),
+			ResourceConfig: resConfig,
+			RestartPolicy:  restartPolicy,
+			Labels:         labels,
+			OomScoreAdj:    oomScoreAdj,
+			Devices:        devices,
 		}
-		volume := ctx.String("v")
-		containerName := ctx.String("name")
 		// 实际运行的命令
-		if err := Run(tty, commandArr, resConfig, volume, containerName); err != nil {
+		if err := Run(tty, info); err != nil {
 			log.Fatal(err)
 		}
 		return nil
 	},
 }
 
-var commitCommand = cli.Command{
-	Name:  "commit",
-	Usage: "commit a change for image",
+func flagError(name, value string, err error) error {
+	prefix := "--"
+	if len(name) == 1 {
+		prefix = "-"
+	}
+	return fmt.Errorf("invalid argument %q for %s%s flag: %v", value, prefix, name, err)
+}
+
+// 解析并校验资源限制相关的参数，在创建任何资源之前发现错误
+func parseResourceConfig(ctx *cli.Context) (*subsystems.ResourceConfig, error) {
+	config := &subsystems.ResourceConfig{}
+	if v := ctx.String("m"); v != "" {
+		memory, err := subsystems.ParseBytes(v)
+		if err == nil {
+			err = subsystems.ValidateMemory(memory)
+		}
+		if err != nil {
+			return nil, flagError("m", v, err)
+		}
+		config.Memory = memory
+	}
+	if v := ctx.String("memory-swap"); v != "" {
+		swap := int64(-1)
+		if v != "-1" {
+			var err error
+			if swap, err = subsystems.ParseBytes(v); err != nil {
+				return nil, flagError("memory-swap", v, err)
+			}
+		}
+		if err := subsystems.ValidateMemorySwap(config.Memory, swap); err != nil {
+			return nil, flagError("memory-swap", v, err)
+		}
+		config.MemorySwap = swap
+	}
+	if v := ctx.String("memory-reservation"); v != "" {
+		reservation, err := subsystems.ParseBytes(v)
+		if err == nil {
+			err = subsystems.ValidateMemoryReservation(config.Memory, reservation)
+		}
+		if err != nil {
+			return nil, flagError("memory-reservation", v, err)
+		}
+		config.MemoryReservation = reservation
+	}
+	if swappiness := ctx.Int("memory-swappiness"); swappiness != -1 {
+		value := uint64(swappiness)
+		err := subsystems.ValidateMemorySwappiness(swappiness)
+		if err != nil {
+			return nil, flagError("memory-swappiness", strconv.Itoa(swappiness), err)
+		}
+		config.MemorySwappiness = &value
+	}
+	if v := ctx.String("kernel-memory"); v != "" {
+		kernelMemory, err := subsystems.ParseBytes(v)
+		if err == nil {
+			err = subsystems.ValidateKernelMemory(kernelMemory)
+		}
+		if err != nil {
+			return nil, flagError("kernel-memory", v, err)
+		}
+		config.KernelMemory = kernelMemory
+	}
+	if ctx.Bool("oom-kill-disable") {
+		if err := subsystems.ValidateOomKillDisable(); err != nil {
+			return nil, flagError("oom-kill-disable", "true", err)
+		}
+		config.OomKillDisable = true
+	}
+	if v := ctx.String("cpushare"); v != "" {
+		shares, err := strconv.ParseUint(v, 10, 64)
+		if err == nil {
+			err = subsystems.ValidateCpuShares(shares)
+		}
+		if err != nil {
+			return nil, flagError("cpushare", v, err)
+		}
+		config.CpuShares = shares
+	}
+	if v := ctx.String("cpuset"); v != "" {
+		if err := subsystems.ValidateCpuSet(v); err != nil {
+			return nil, flagError("cpuset", v, err)
+		}
+		config.CpusetCpus = v
+	}
+	if period := ctx.Uint64("cpu-period"); period != 0 {
+		if err := subsystems.ValidateCpuPeriod(period); err != nil {
+			return nil, flagError("cpu-period", strconv.FormatUint(period, 10), err)
+		}
+		config.CpuPeriod = period
+	}
+	if quota := ctx.Int64("cpu-quota"); quota != 0 {
+		if err := subsystems.ValidateCpuQuota(quota); err != nil {
+			return nil, flagError("cpu-quota", strconv.FormatInt(quota, 10), err)
+		}
+		config.CpuQuota = quota
+	}
+	if limit := ctx.Int64("pids-limit"); limit != 0 {
+		if err := subsystems.ValidatePidsLimit(limit); err != nil {
+			return nil, flagError("pids-limit", strconv.FormatInt(limit, 10), err)
+		}
+		config.PidsLimit = limit
+	}
+	if weight := ctx.Uint64("blkio-weight"); weight != 0 {
+		if err := subsystems.ValidateBlkioWeight(weight); err != nil {
+			return nil, flagError("blkio-weight", strconv.FormatUint(weight, 10), err)
+		}
+		config.BlkioWeight = uint16(weight)
+	}
+	throttles := []struct {
+		name    string
+		bps     bool
+		devices *[]*subsystems.ThrottleDevice
+	}{
+		{"device-read-bps", true, &config.BlkioDeviceReadBps},
+		{"device-write-bps", true, &config.BlkioDeviceWriteBps},
+		{"device-read-iops", false, &config.BlkioDeviceReadIOps},
+		{"device-write-iops", false, &config.BlkioDeviceWriteIOps},
+	}
+	for _, throttle := range throttles {
+		for _, v := range ctx.StringSlice(throttle.name) {
+			device, err := subsystems.ParseThrottleDevice(v, throttle.bps)
+			if err != nil {
+				return nil, flagError(throttle.name, v, err)
+			}
+			*throttle.devices = append(*throttle.devices, device)
+		}
+	}
+	if v := ctx.String("cpus"); v != "" {
+		if config.CpuPeriod != 0 || config.CpuQuota != 0 {
+			return nil, errors.New("conflicting options: --cpus and --cpu-period/--cpu-quota can not be used together")
+		}
+		nanoCpus, err := subsystems.ParseCpus(v)
+		if err != nil {
+			return nil, flagError("cpus", v, err)
+		}
+		config.NanoCpus = nanoCpus
+		config.CpuPeriod, config.CpuQuota = subsystems.NanoCpusToQuota(nanoCpus)
+	}
+	return config, nil
+}
+
+var updateCommand = cli.Command{
+	Name:      "update",
+	Usage:     "update resource limits of one or more containers",
+	ArgsUsage: "CONTAINER [CONTAINER...]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "memory, m",
+			Usage: "memory limit, e.g. 512m or 1g",
+		},
+		cli.StringFlag{
+			Name:  "cpus",
+			Usage: "number of CPUs, e.g. 1.5",
+		},
+		cli.StringFlag{
+			Name:  "cpuset-cpus",
+			Usage: "CPUs in which to allow execution, e.g. 0-2,4",
+		},
+		cli.Uint64Flag{
+			Name:  "cpu-shares",
+			Usage: "cpu shares (relative weight)",
+		},
+		cli.Int64Flag{
+			Name:  "pids-limit",
+			Usage: "limit the number of processes, -1 means unlimited",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 		if len(ctx.Args()) < 1 {
-			return errors.New("must at lease one arg")
+			return errors.New("missing container name")
 		}
-		imageName := ctx.Args().Get(0)
-		if err := container.CommitContainer(imageName); err != nil {
+		update, err := parseResourceUpdate(ctx)
+		if err != nil {
 			return err
 		}
+		for _, ref := range ctx.Args() {
+			if _, err = container.UpdateContainerResources(ref, update); err != nil {
+				return err
+			}
+			fmt.Println(ref)
+		}
 		return nil
 	},
 }
 
-var listCommand = cli.Command{
-	Name:  "ps",
-	Usage: "list container list",
+// 解析update的参数，返回修改资源配置的函数，只修改命令行中指定的项
+func parseResourceUpdate(ctx *cli.Context) (func(config *subsystems.ResourceConfig) error, error) {
+	var updates []func(config *subsystems.ResourceConfig)
+	if ctx.IsSet("memory") {
+		v := ctx.String("memory")
+		memory, err := subsystems.ParseBytes(v)
+		if err == nil {
+			err = subsystems.ValidateMemory(memory)
+		}
+		if err != nil {
+			return nil, flagError("memory", v, err)
+		}
+		updates = append(updates, func(config *subsystems.ResourceConfig) {
+			config.Memory = memory
+		})
+	}
+	if ctx.IsSet("cpus") {
+		v := ctx.String("cpus")
+		nanoCpus, err := subsystems.ParseCpus(v)
+		if err != nil {
+			return nil, flagError("cpus", v, err)
+		}
+		updates = append(updates, func(config *subsystems.ResourceConfig) {
+			config.NanoCpus = nanoCpus
+			config.CpuPeriod, config.CpuQuota = subsystems.NanoCpusToQuota(nanoCpus)
+		})
+	}
+	if ctx.IsSet("cpuset-cpus") {
+		v := ctx.String("cpuset-cpus")
+		if err := subsystems.ValidateCpuSet(v); err != nil {
+			return nil, flagError("cpuset-cpus", v, err)
+		}
+		updates = append(updates, func(config *subsystems.ResourceConfig) {
+			config.CpusetCpus = v
+		})
+	}
+	if ctx.IsSet("cpu-shares") {
+		shares := ctx.Uint64("cpu-shares")
+		if err := subsystems.ValidateCpuShares(shares); err != nil {
+			return nil, flagError("cpu-shares", strconv.FormatUint(shares, 10), err)
+		}
+		updates = append(updates, func(config *subsystems.ResourceConfig) {
+			config.CpuShares = shares
+		})
+	}
+	if ctx.IsSet("pids-limit") {
+		limit := ctx.Int64("pids-limit")
+		if err := subsystems.ValidatePidsLimit(limit); err != nil {
+			return nil, flagError("pids-limit", strconv.FormatInt(limit, 10), err)
+		}
+		// 0和-1都表示取消限制
+		if limit == 0 {
+			limit = -1
+		}
+		updates = append(updates, func(config *subsystems.ResourceConfig) {
+			config.PidsLimit = limit
+		})
+	}
+	if len(updates) == 0 {
+		return nil, errors.New("you must provide one or more flags when using this command")
+	}
+	return func(config *subsystems.ResourceConfig) error {
+		for _, update := range updates {
+			update(config)
+		}
+		return nil
+	}, nil
+}
+
+var commitCommand = cli.Command{
+	Name:      "commit",
+	Usage:     "create a new image from a container's changes",
+	ArgsUsage: "CONTAINER REPOSITORY[:TAG]",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "change, c",
+			Usage: "apply Dockerfile instruction to the created image, e.g. 'CMD [\"/bin/sh\"]'",
+		},
+		cli.StringFlag{
+			Name:  "author, a",
+			Usage: "author (e.g., \"John Hannibal Smith <hannibal@a-team.com>\")",
+		},
+		cli.StringFlag{
+			Name:  "message, m",
+			Usage: "commit message",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
-		if err := container.ListContainers(); err != nil {
+		if len(ctx.Args()) < 2 {
+			return errors.New("missing container name or image name")
+		}
+		opts := container.CommitOptions{
+			Changes: ctx.StringSlice("change"),
+			Author:  ctx.String("author"),
+			Comment: ctx.String("message"),
+		}
+		id, err := container.CommitContainer(ctx.Args().Get(0), ctx.Args().Get(1), opts)
+		if err != nil {
 			return err
 		}
+		fmt.Println(id)
 		return nil
 	},
 }
 
-// 实际运行的命令
-func Run(tty bool, commandArr []string, resConfig *subsystems.ResourceConfig, volume, containerName string) (err error) {
-	// 创建命令环境,并且返回一个写管道，用于写入命令字符串
-	parent, writePipe, err := container.NewContainerProcess(tty, volume)
-	if err != nil {
-		return err
-	}
-	if err = parent.Start(); err != nil {
-		return err
-	}
-	containerName, err = container.RecordContainerInfo(parent.Process.Pid, commandArr, containerName)
-	if err != nil {
-		return err
-	}
-	// 设置容器资源限制
-	cgroupManager := subsystems.NewCgroupManager("mydocker")
-	// 命令结束时候清理容器限制
-	defer cgroupManager.Destroy()
-	// 设置对应的资源
-	if err = cgroupManager.Set(resConfig); err != nil {
-		return err
-	}
-	// 把对应的进程pid写入cgroup
-	cgroupManager.Apply(parent.Process.Pid)
-	// 发送命令到管道
-	if err = sendCommand(commandArr, writePipe); err != nil {
+var buildCommand = cli.Command{
+	Name:      "build",
+	Usage:     "build an image from a Dockerfile",
+	ArgsUsage: "PATH",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "tag, t",
+			Usage: "name and optionally a tag in the 'name:tag' format",
+		},
+		cli.StringFlag{
+			Name:  "file, f",
+			Usage: "name of the Dockerfile (default is 'PATH/Dockerfile')",
+		},
+		cli.StringSliceFlag{
+			Name:  "build-arg",
+			Usage: "set build-time variables, KEY=VALUE or KEY to pass the value from host",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "do not use cache when building the image",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			return errors.New("build requires exactly 1 argument: the build context")
+		}
+		buildArgs := make(map[string]string)
+		for _, kv := range ctx.StringSlice("build-arg") {
+			pair := strings.SplitN(kv, "=", 2)
+			if len(pair) == 1 {
+				// 只有变量名时使用主机上的值，主机上没有时忽略
+				value, ok := os.LookupEnv(kv)
+				if !ok {
+					continue
+				}
+				pair = append(pair, value)
+			}
+			buildArgs[pair[0]] = pair[1]
+		}
+		opts := container.BuildOptions{
+			ContextDir: ctx.Args().First(),
+			Dockerfile: ctx.String("file"),
+			Tags:       ctx.StringSlice("tag"),
+			BuildArgs:  buildArgs,
+			NoCache:    ctx.Bool("no-cache"),
+			// RUN的临时容器在前台运行，输出直接打印出来
+			Run: func(info *container.ContainerInfo) error {
+				return runContainer(info, true)
+			},
+		}
+		_, err := container.Build(opts)
 		return err
-	}
-	if tty {
-		if err = parent.Wait(); err != nil {
+	},
+}
+
+var tagCommand = cli.Command{
+	Name:      "tag",
+	Usage:     "create a tag TARGET_IMAGE that refers to SOURCE_IMAGE",
+	ArgsUsage: "SOURCE_IMAGE[:TAG] TARGET_IMAGE[:TAG]",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 2 {
+			return errors.New("tag requires exactly 2 arguments")
+		}
+		return container.TagImage(ctx.Args().Get(0), ctx.Args().Get(1))
+	},
+}
+
+var historyCommand = cli.Command{
+	Name:      "history",
+	Usage:     "show the history of an image",
+	ArgsUsage: "IMAGE",
+	Flags: []cli.Flag{
+		cli.BoolTFlag{
+			Name:  "human, H",
+			Usage: "print sizes and dates in human readable format",
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "only show image IDs",
+		},
+		cli.BoolFlag{
+			Name:  "no-trunc",
+			Usage: "do not truncate output",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "format the output using the given go template, or json",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			return errors.New("history requires exactly 1 argument")
+		}
+		opts := container.HistoryOptions{
+			Human:   ctx.BoolT("human"),
+			Quiet:   ctx.Bool("quiet"),
+			NoTrunc: ctx.Bool("no-trunc"),
+			Format:  ctx.String("format"),
+		}
+		return container.ShowHistory(ctx.Args().First(), opts)
+	},
+}
+
+var imageCommand = cli.Command{
+	Name:  "image",
+	Usage: "manage images",
+	Subcommands: []cli.Command{
+		{
+			Name:      "inspect",
+			Usage:     "display detailed information on one or more images",
+			ArgsUsage: "IMAGE [IMAGE...]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "format, f",
+					Usage: "format the output using the given go template",
+				},
+			},
+			Action: func(ctx *cli.Context) error {
+				if len(ctx.Args()) < 1 {
+					return errors.New("missing image name")
+				}
+				for _, name := range ctx.Args() {
+					obj, err := container.InspectImage(name)
+					if err != nil {
+						return err
+					}
+					if err = util.FormatOutput(os.Stdout, obj, ctx.String("format")); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		historyCommand,
+		tagCommand,
+	},
+}
+
+var saveCommand = cli.Command{
+	Name:      "save",
+	Usage:     "save images to a tar archive in OCI image layout",
+	ArgsUsage: "IMAGE [IMAGE...]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "write to a file, instead of STDOUT",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return errors.New("missing image name")
+		}
+		return container.SaveImages(ctx.Args(), ctx.String("output"))
+	},
+}
+
+var loadCommand = cli.Command{
+	Name:  "load",
+	Usage: "load images from an OCI image layout or docker save archive",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "input, i",
+			Usage: "read from tar archive file, instead of STDIN",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		return container.LoadImages(ctx.String("input"))
+	},
+}
+
+var pullCommand = cli.Command{
+	Name:      "pull",
+	Usage:     "pull an image from a registry",
+	ArgsUsage: "NAME[:TAG|@DIGEST]",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return errors.New("missing image name")
+		}
+		return container.PullImage(ctx.Args().Get(0))
+	},
+}
+
+var pushCommand = cli.Command{
+	Name:      "push",
+	Usage:     "push an image to a registry",
+	ArgsUsage: "NAME[:TAG]",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return errors.New("missing image name")
+		}
+		return container.PushImage(ctx.Args().Get(0))
+	},
+}
+
+var loginCommand = cli.Command{
+	Name:      "login",
+	Usage:     "log in to a registry",
+	ArgsUsage: "[SERVER]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "username, u",
+			Usage: "username",
+		},
+		cli.StringFlag{
+			Name:  "password, p",
+			Usage: "password",
+		},
+		cli.BoolFlag{
+			Name:  "password-stdin",
+			Usage: "take the password from stdin",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		domain := registry.ServerDomain(ctx.Args().First())
+		creds := &registry.Credentials{Username: ctx.String("username"), Password: ctx.String("password")}
+		if ctx.Bool("password-stdin") {
+			if creds.Password != "" {
+				return errors.New("--password and --password-stdin are mutually exclusive")
+			}
+			if creds.Username == "" {
+				return errors.New("must provide --username with --password-stdin")
+			}
+			b, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			creds.Password = strings.TrimRight(string(b), "\r\n")
+		} else if creds.Password != "" {
+			log.Warn("using --password via the CLI is insecure, use --password-stdin")
+		}
+		// 用户名和密码从同一个reader读取，标准输入是管道时不会丢失缓存的密码
+		stdin := bufio.NewReader(os.Stdin)
+		if creds.Username == "" {
+			fmt.Print("Username: ")
+			username, err := util.ReadLine(stdin)
+			if err != nil {
+				return err
+			}
+			creds.Username = strings.TrimSpace(username)
+		}
+		if creds.Password == "" {
+			fmt.Print("Password: ")
+			password, err := util.ReadPassword(os.Stdin, stdin)
+			fmt.Println()
+			if err != nil {
+				return err
+			}
+			creds.Password = password
+		}
+		if creds.Username == "" || creds.Password == "" {
+			return errors.New("username and password are required")
+		}
+		if err := registry.Login(domain, creds); err != nil {
 			return err
 		}
-		//rootURL := "/root/test1/"
-		//mntURL := "/root/test1/mnt/"
-		//if err = container.DeleteWorkSpace(rootURL, mntURL, volume); err != nil {
-		//	return err
-		//}
-		if err = container.DeleteContainerInfo(containerName); err != nil {
+		config, err := registry.LoadConfig()
+		if err != nil {
 			return err
 		}
-	}
-	return
+		if err = config.StoreCredentials(domain, creds); err != nil {
+			return err
+		}
+		fmt.Println("Login Succeeded")
+		return nil
+	},
 }
 
-func sendCommand(commandArr []string, writePipe *os.File) (err error) {
-	command := strings.Join(commandArr, " ")
-	log.Infof("command is %s", command)
-	if _, err = writePipe.WriteString(command); err != nil {
-		return err
+var logoutCommand = cli.Command{
+	Name:      "logout",
+	Usage:     "log out from a registry",
+	ArgsUsage: "[SERVER]",
+	Action: func(ctx *cli.Context) error {
+		domain := registry.ServerDomain(ctx.Args().First())
+		config, err := registry.LoadConfig()
+		if err != nil {
+			return err
+		}
+		erased, err := config.EraseCredentials(domain)
+		if err != nil {
+			return err
+		}
+		if !erased {
+			fmt.Printf("Not logged in to %s\n", registry.ServerAddress(domain))
+			return nil
+		}
+		fmt.Printf("Removing login credentials for %s\n", registry.ServerAddress(domain))
+		return nil
+	},
+}
+
+var monitorCommand = cli.Command{
+	Name:   "monitor",
+	Usage:  "wait on a detached container and restart it by its restart policy",
+	Hidden: true,
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return errors.New("missing container name")
+		}
+		return Monitor(ctx.Args().Get(0))
+	},
+}
+
+var stopCommand = cli.Command{
+	Name:  "stop",
+	Usage: "stop a container",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "t",
+			Usage: "seconds to wait before killing the container",
+			Value: 10,
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return errors.New("missing container name")
+		}
+		timeout := time.Duration(ctx.Int("t")) * time.Second
+		return container.StopContainer(ctx.Args().Get(0), timeout)
+	},
+}
+
+var inspectCommand = cli.Command{
+	Name:  "inspect",
+	Usage: "display detailed information of a container or image",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format, f",
+			Usage: "format the output using the given go template",
+		},
+		cli.StringFlag{
+			Name:  "type",
+			Usage: "return JSON for specified type: container|image|network|volume",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return errors.New("must at lease one arg")
+		}
+		for _, name := range ctx.Args() {
+			obj, err := inspectObject(name, ctx.String("type"))
+			if err != nil {
+				return err
+			}
+			if err = util.FormatOutput(os.Stdout, obj, ctx.String("format")); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// 没有指定类型时依次按容器、镜像查找
+func inspectObject(name, objType string) (interface{}, error) {
+	switch objType {
+	case "container":
+		return container.InspectContainer(name)
+	case "image":
+		return container.InspectImage(name)
+	case "network", "volume":
+		return nil, fmt.Errorf("%s %s not found, mydocker does not manage %ss", objType, name, objType)
+	case "":
+		if obj, err := container.InspectContainer(name); err == nil {
+			return obj, nil
+		}
+		if obj, err := container.InspectImage(name); err == nil {
+			return obj, nil
+		}
+		return nil, fmt.Errorf("no such object: %s", name)
 	}
-	writePipe.Close()
-	return
+	return nil, fmt.Errorf("unknown type %s", objType)
+}
+
+var listCommand = cli.Command{
+	Name:  "ps",
+	Usage: "list container list",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "a",
+			Usage: "show all containers, including exited ones",
+		},
+		cli.BoolFlag{
+			Name:  "q",
+			Usage: "only display container IDs",
+		},
+		cli.StringSliceFlag{
+			Name:  "filter",
+			Usage: "filter output: id|name|status|label|ancestor|exited|since|before=value",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "format the output using the given go template, or json",
+		},
+		cli.BoolFlag{
+			Name:  "no-trunc",
+			Usage: "do not truncate output",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		opts := container.ListOptions{
+			All:     ctx.Bool("a"),
+			Quiet:   ctx.Bool("q"),
+			NoTrunc: ctx.Bool("no-trunc"),
+			Filters: ctx.StringSlice("filter"),
+			Format:  ctx.String("format"),
+		}
+		if err := container.ListContainers(opts); err != nil {
+			return err
+		}
+		return nil
+	},
+}
+
+var statsCommand = cli.Command{
+	Name:      "stats",
+	Usage:     "display a live stream of container resource usage",
+	ArgsUsage: "[CONTAINER...]",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-stream",
+			Usage: "disable streaming stats and only pull the first result",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "format the output using the given go template, or json",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		opts := container.StatsOptions{
+			Containers: ctx.Args(),
+			NoStream:   ctx.Bool("no-stream"),
+			Format:     ctx.String("format"),
+		}
+		return container.ShowStats(opts)
+	},
+}
+
+var pauseCommand = cli.Command{
+	Name:      "pause",
+	Usage:     "pause all processes within one or more containers",
+	ArgsUsage: "CONTAINER [CONTAINER...]",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return errors.New("missing container name")
+		}
+		for _, ref := range ctx.Args() {
+			if err := container.PauseContainer(ref); err != nil {
+				return err
+			}
+			fmt.Println(ref)
+		}
+		return nil
+	},
+}
+
+var unpauseCommand = cli.Command{
+	Name:      "unpause",
+	Usage:     "unpause all processes within one or more containers",
+	ArgsUsage: "CONTAINER [CONTAINER...]",
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return errors.New("missing container name")
+		}
+		for _, ref := range ctx.Args() {
+			if err := container.UnpauseContainer(ref); err != nil {
+				return err
+			}
+			fmt.Println(ref)
+		}
+		return nil
+	},
+}
+
+var topCommand = cli.Command{
+	Name:            "top",
+	Usage:           "display the running processes of a container",
+	ArgsUsage:       "CONTAINER [ps OPTIONS]",
+	SkipFlagParsing: true,
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) < 1 {
+			return errors.New("missing container name")
+		}
+		return container.Top(ctx.Args().First(), ctx.Args().Tail())
+	},
+}
+
+var diffCommand = cli.Command{
+	Name:      "diff",
+	Usage:     "inspect changes to files or directories on a container's filesystem",
+	ArgsUsage: "CONTAINER",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "format the output using the given go template, or json",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if len(ctx.Args()) != 1 {
+			return errors.New("diff requires exactly 1 argument")
+		}
+		return container.ShowContainerChanges(ctx.Args().First(), ctx.String("format"))
+	},
 }
 
 func main() {
@@ -168,11 +1035,42 @@ func main() {
 		initCmd,
 		runCmd,
 		commitCommand,
+		buildCommand,
+		tagCommand,
+		historyCommand,
+		imageCommand,
+		saveCommand,
+		loadCommand,
+		pullCommand,
+		pushCommand,
+		loginCommand,
+		logoutCommand,
 		listCommand,
+		stopCommand,
+		pauseCommand,
+		unpauseCommand,
+		updateCommand,
+		statsCommand,
+		topCommand,
+		diffCommand,
+		inspectCommand,
+		monitorCommand,
 	}
 	app.Before = func(context *cli.Context) error {
 		log.SetFormatter(&log.JSONFormatter{})
 		log.SetOutput(os.Stdout)
+		// init在容器内执行，不需要处理状态目录
+		if context.Args().First() == initCmd.Name {
+			return nil
+		}
+		if err := container.MigrateState(); err != nil {
+			return err
+		}
+		// 只在会修改容器状态的命令中清理异常退出的容器遗留的资源，查询命令只在读取时修正状态
+		switch context.Args().First() {
+		case runCmd.Name, stopCommand.Name, pauseCommand.Name, unpauseCommand.Name, updateCommand.Name, buildCommand.Name:
+			return container.CleanupOrphans()
+		}
 		return nil
 	}
 	if err := app.Run(os.Args); err != nil {
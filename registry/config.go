@@ -0,0 +1,211 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mydocker/image"
+	"mydocker/store"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	configFileName = "config.json"
+	// 可以用环境变量修改配置目录，和DOCKER_CONFIG一样
+	configDirEnv = "MYDOCKER_CONFIG"
+	// docker hub的凭据按这个地址保存，和docker一致
+	dockerHubServer = "https://index.docker.io/v1/"
+)
+
+// 凭据的配置文件，格式兼容docker的config.json，不认识的字段原样保留
+type ConfigFile struct {
+	Filename    string
+	Auths       map[string]AuthConfig
+	CredsStore  string
+	CredHelpers map[string]string
+	raw         map[string]json.RawMessage
+}
+
+// auths中的一项，auth为base64编码的 username:password
+type AuthConfig struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+func configDir() string {
+	if dir := os.Getenv(configDirEnv); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "/root"
+	}
+	return filepath.Join(home, ".mydocker")
+}
+
+// 读取配置文件，文件不存在时返回空的配置
+func LoadConfig() (*ConfigFile, error) {
+	c := &ConfigFile{
+		Filename:    filepath.Join(configDir(), configFileName),
+		Auths:       make(map[string]AuthConfig),
+		CredHelpers: make(map[string]string),
+		raw:         make(map[string]json.RawMessage),
+	}
+	b, err := ioutil.ReadFile(c.Filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &c.raw); err != nil {
+		return nil, fmt.Errorf("invalid config file %s:%v", c.Filename, err)
+	}
+	fields := map[string]interface{}{"auths": &c.Auths, "credsStore": &c.CredsStore, "credHelpers": &c.CredHelpers}
+	for key, v := range fields {
+		if value, ok := c.raw[key]; ok {
+			if err = json.Unmarshal(value, v); err != nil {
+				return nil, fmt.Errorf("invalid %s in config file %s:%v", key, c.Filename, err)
+			}
+		}
+	}
+	return c, nil
+}
+
+// 配置文件中保存着密码，只允许所有者读写
+func (c *ConfigFile) Save() error {
+	fields := map[string]interface{}{"auths": c.Auths, "credsStore": c.CredsStore, "credHelpers": c.CredHelpers}
+	for key, v := range fields {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		c.raw[key] = b
+	}
+	if c.CredsStore == "" {
+		delete(c.raw, "credsStore")
+	}
+	if len(c.CredHelpers) == 0 {
+		delete(c.raw, "credHelpers")
+	}
+	b, err := json.MarshalIndent(c.raw, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(c.Filename), 0700); err != nil {
+		return err
+	}
+	return store.WriteFileAtomic(c.Filename, b, 0600)
+}
+
+// 凭据按仓库地址保存，docker hub使用docker的地址
+func ServerAddress(domain string) string {
+	if domain == image.DefaultDomain || domain == dockerHubHost || domain == "index.docker.io" {
+		return dockerHubServer
+	}
+	return domain
+}
+
+// 去掉地址中的协议和路径，返回仓库地址，为空或者是docker hub时返回docker.io
+func ServerDomain(server string) string {
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	if i := strings.IndexByte(server, '/'); i != -1 {
+		server = server[:i]
+	}
+	if server == "" || ServerAddress(server) == dockerHubServer {
+		return image.DefaultDomain
+	}
+	return server
+}
+
+func normalizeServer(server string) string {
+	return ServerAddress(ServerDomain(server))
+}
+
+// 优先使用仓库单独配置的helper，其次是credsStore，最后是配置文件
+func (c *ConfigFile) helper(server string) string {
+	if helper, ok := c.CredHelpers[server]; ok {
+		return helper
+	}
+	return c.CredsStore
+}
+
+func (c *ConfigFile) authKey(server string) (string, bool) {
+	if _, ok := c.Auths[server]; ok {
+		return server, true
+	}
+	for key := range c.Auths {
+		if normalizeServer(key) == server {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// 返回仓库的凭据，没有登录过时返回nil
+func (c *ConfigFile) GetCredentials(domain string) (*Credentials, error) {
+	server := ServerAddress(domain)
+	if helper := c.helper(server); helper != "" {
+		return helperGet(helper, server)
+	}
+	key, ok := c.authKey(server)
+	if !ok {
+		return nil, nil
+	}
+	auth := c.Auths[key]
+	creds := &Credentials{Username: auth.Username, Password: auth.Password, IdentityToken: auth.IdentityToken}
+	if auth.Auth != "" {
+		b, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auth for %s in %s:%v", key, c.Filename, err)
+		}
+		kv := strings.SplitN(string(b), ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid auth for %s in %s", key, c.Filename)
+		}
+		creds.Username, creds.Password = kv[0], kv[1]
+	}
+	return creds, nil
+}
+
+// 保存凭据，配置了helper时交给helper保存
+func (c *ConfigFile) StoreCredentials(domain string, creds *Credentials) error {
+	server := ServerAddress(domain)
+	if helper := c.helper(server); helper != "" {
+		if err := helperStore(helper, server, creds); err != nil {
+			return err
+		}
+		// 配置文件中只保留一个空的记录，表示登录过
+		c.Auths[server] = AuthConfig{}
+		return c.Save()
+	}
+	if key, ok := c.authKey(server); ok {
+		delete(c.Auths, key)
+	}
+	c.Auths[server] = AuthConfig{
+		Auth: base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password)),
+	}
+	return c.Save()
+}
+
+// 删除凭据，没有登录过时返回false
+func (c *ConfigFile) EraseCredentials(domain string) (bool, error) {
+	server := ServerAddress(domain)
+	key, ok := c.authKey(server)
+	if helper := c.helper(server); helper != "" {
+		if err := helperErase(helper, server); err != nil {
+			return false, err
+		}
+		ok = true
+	}
+	if !ok {
+		return false, nil
+	}
+	delete(c.Auths, key)
+	return true, c.Save()
+}
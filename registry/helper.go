@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// helper是名为docker-credential-<name>的程序，按docker的协议从标准输入读取请求，向标准输出写结果
+const helperPrefix = "docker-credential-"
+
+// helper没有找到凭据时输出的错误
+const helperNotFound = "credentials not found in native keychain"
+
+// 用户名为这个值时Secret是identity token
+const helperTokenUsername = "<token>"
+
+type helperCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+func runHelper(helper, action string, input []byte) ([]byte, error) {
+	cmd := exec.Command(helperPrefix+helper, action)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// 出错时错误信息写在标准输出中
+		message := strings.TrimSpace(stdout.String())
+		if message == "" {
+			message = strings.TrimSpace(stderr.String())
+		}
+		if message == "" {
+			message = err.Error()
+		}
+		return nil, fmt.Errorf("%s", message)
+	}
+	return stdout.Bytes(), nil
+}
+
+func helperGet(helper, server string) (*Credentials, error) {
+	out, err := runHelper(helper, "get", []byte(server))
+	if err != nil {
+		if err.Error() == helperNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get credentials of %s from %s%s: %v", server, helperPrefix, helper, err)
+	}
+	var creds helperCredentials
+	if err = json.Unmarshal(out, &creds); err != nil {
+		return nil, fmt.Errorf("invalid output of %s%s:%v", helperPrefix, helper, err)
+	}
+	if creds.Username == helperTokenUsername {
+		return &Credentials{IdentityToken: creds.Secret}, nil
+	}
+	return &Credentials{Username: creds.Username, Password: creds.Secret}, nil
+}
+
+func helperStore(helper, server string, creds *Credentials) error {
+	input, err := json.Marshal(&helperCredentials{ServerURL: server, Username: creds.Username, Secret: creds.Password})
+	if err != nil {
+		return err
+	}
+	if _, err = runHelper(helper, "store", input); err != nil {
+		return fmt.Errorf("store credentials of %s to %s%s: %v", server, helperPrefix, helper, err)
+	}
+	return nil
+}
+
+func helperErase(helper, server string) error {
+	if _, err := runHelper(helper, "erase", []byte(server)); err != nil && err.Error() != helperNotFound {
+		return fmt.Errorf("erase credentials of %s from %s%s: %v", server, helperPrefix, helper, err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mydocker/image"
+	"net/http"
+	"strings"
+)
+
+// 拉取manifest时接受的类型，schema1已经废弃不再支持
+var manifestAcceptTypes = []string{
+	image.MediaTypeManifest,
+	image.MediaTypeDockerManifest,
+	image.MediaTypeIndex,
+	image.MediaTypeDockerManifestList,
+}
+
+// 按tag或者digest获取manifest，校验内容的摘要
+func (r *Repository) GetManifest(reference string) ([]byte, string, image.Digest, error) {
+	name := r.path + ":" + reference
+	if strings.HasPrefix(reference, "sha256:") {
+		name = r.path + "@" + reference
+	}
+	req, err := http.NewRequest("GET", r.url("/manifests/%s", reference), nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	req.Header.Set("Accept", strings.Join(manifestAcceptTypes, ", "))
+	resp, err := r.do(req)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", fmt.Errorf("manifest for %s: %v", name, responseError(resp))
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+	d := image.FromBytes(b)
+	expected := image.Digest(resp.Header.Get("Docker-Content-Digest"))
+	if strings.HasPrefix(reference, "sha256:") {
+		expected = image.Digest(reference)
+	}
+	if expected != "" && expected != d {
+		return nil, "", "", fmt.Errorf("manifest for %s has digest %s, expected %s", name, d, expected)
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	if i := strings.IndexByte(mediaType, ';'); i != -1 {
+		mediaType = mediaType[:i]
+	}
+	// 有些仓库不返回准确的Content-Type，以manifest中的mediaType为准
+	var versioned struct {
+		SchemaVersion int    `json:"schemaVersion"`
+		MediaType     string `json:"mediaType"`
+	}
+	if err = json.Unmarshal(b, &versioned); err != nil {
+		return nil, "", "", fmt.Errorf("invalid manifest for %s:%v", name, err)
+	}
+	if versioned.SchemaVersion != 2 {
+		return nil, "", "", fmt.Errorf("unsupported manifest schema version %d for %s", versioned.SchemaVersion, name)
+	}
+	if versioned.MediaType != "" {
+		mediaType = versioned.MediaType
+	}
+	return b, mediaType, d, nil
+}
+
+// 读取blob，摘要由写入本地存储时校验
+func (r *Repository) GetBlob(desc image.Descriptor) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", r.url("/blobs/%s", desc.Digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("blob %s: %v", desc.Digest, responseError(resp))
+	}
+	return resp.Body, nil
+}
+
+// 从仓库拉取镜像保存到本地，按镜像名打tag，返回镜像ID
+func Pull(store *image.Store, ref *image.Reference, credentials CredentialsFunc, out io.Writer) (image.Digest, error) {
+	repo, err := NewRepository(ref, []string{"pull"}, credentials)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(out, "%s: Pulling from %s\n", ref.Reference(), ref.Path)
+	manifestBytes, mediaType, manifestDigest, err := repo.GetManifest(ref.Reference())
+	if err != nil {
+		return "", err
+	}
+	// 多平台的镜像选择当前平台的manifest
+	if mediaType == image.MediaTypeIndex || mediaType == image.MediaTypeDockerManifestList {
+		index := &image.Index{}
+		if err = json.Unmarshal(manifestBytes, index); err != nil {
+			return "", fmt.Errorf("invalid manifest list for %s:%v", ref, err)
+		}
+		desc, err := image.MatchPlatform(index)
+		if err != nil {
+			return "", err
+		}
+		if manifestBytes, _, _, err = repo.GetManifest(desc.Digest.String()); err != nil {
+			return "", err
+		}
+	}
+	id, err := store.ImportImage(manifestBytes, func(desc image.Descriptor) (io.ReadCloser, error) {
+		if desc.MediaType != image.MediaTypeConfig && desc.MediaType != image.MediaTypeDockerConfig {
+			fmt.Fprintf(out, "%s: Pulling fs layer\n", desc.Digest.ShortID())
+		}
+		return repo.GetBlob(desc)
+	})
+	if err != nil {
+		return "", err
+	}
+	if ref.Tag != "" {
+		if err = store.Tag(ref.Name()+":"+ref.Tag, id); err != nil {
+			return "", err
+		}
+	}
+	if ref.Digest != "" {
+		if err = store.Tag(ref.Name()+"@"+ref.Digest.String(), id); err != nil {
+			return "", err
+		}
+	}
+	fmt.Fprintf(out, "Digest: %s\n", manifestDigest)
+	fmt.Fprintf(out, "Status: Downloaded image for %s\n", ref)
+	return id, nil
+}
@@ -0,0 +1,207 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mydocker/image"
+	"net/http"
+	"net/url"
+)
+
+// 大于这个大小的blob分块上传，每块的大小也是这个值
+const uploadChunkSize = 5 * 1024 * 1024
+
+// 检查仓库中是否已经有blob
+func (r *Repository) HasBlob(d image.Digest) (bool, error) {
+	req, err := http.NewRequest("HEAD", r.url("/blobs/%s", d), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := r.do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("check blob %s: %v", d, responseError(resp))
+}
+
+// 上传blob，小的blob一次PUT完成，大的blob分多次PATCH后再PUT
+func (r *Repository) PutBlob(desc image.Descriptor, content io.Reader) error {
+	req, err := http.NewRequest("POST", r.url("/blobs/uploads/"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("start upload of blob %s: %v", desc.Digest, responseError(resp))
+	}
+	location, err := r.location(resp)
+	if err != nil {
+		return err
+	}
+	var final []byte
+	if desc.Size <= uploadChunkSize {
+		if final, err = readFull(content, desc.Size); err != nil {
+			return err
+		}
+	} else {
+		buf := make([]byte, uploadChunkSize)
+		for offset := int64(0); offset < desc.Size; {
+			n, err := io.ReadFull(content, buf)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return fmt.Errorf("read blob %s:%v", desc.Digest, err)
+			}
+			req, err := http.NewRequest("PATCH", location, bytes.NewReader(buf[:n]))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "application/octet-stream")
+			req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(n)-1))
+			resp, err := r.do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusAccepted {
+				return fmt.Errorf("upload blob %s: %v", desc.Digest, responseError(resp))
+			}
+			if location, err = r.location(resp); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	query.Set("digest", desc.Digest.String())
+	u.RawQuery = query.Encode()
+	req, err = http.NewRequest("PUT", u.String(), bytes.NewReader(final))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if resp, err = r.do(req); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("finish upload of blob %s: %v", desc.Digest, responseError(resp))
+	}
+	return nil
+}
+
+func readFull(r io.Reader, size int64) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// 上传的地址由仓库在Location中返回，可能是相对地址
+func (r *Repository) location(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("registry %s did not return upload location", r.domain)
+	}
+	u, err := resp.Request.URL.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (r *Repository) PutManifest(reference, mediaType string, manifest []byte) (image.Digest, error) {
+	req, err := http.NewRequest("PUT", r.url("/manifests/%s", reference), bytes.NewReader(manifest))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := r.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("put manifest %s:%s: %v", r.path, reference, responseError(resp))
+	}
+	d := image.FromBytes(manifest)
+	if actual := resp.Header.Get("Docker-Content-Digest"); actual != "" && image.Digest(actual) != d {
+		return "", fmt.Errorf("registry stored manifest with digest %s, expected %s", actual, d)
+	}
+	return d, nil
+}
+
+// 把本地镜像推送到仓库，已经存在的blob不再上传
+func Push(store *image.Store, ref *image.Reference, credentials CredentialsFunc, out io.Writer) (image.Digest, error) {
+	if ref.Digest != "" {
+		return "", fmt.Errorf("cannot push a digest reference %s", ref)
+	}
+	img, err := store.Lookup(ref.Name() + ":" + ref.Tag)
+	if err != nil {
+		return "", err
+	}
+	repo, err := NewRepository(ref, []string{"pull", "push"}, credentials)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(out, "The push refers to repository [%s/%s]\n", ref.Domain, ref.Path)
+	// 先上传层和配置，最后上传manifest
+	blobs := append([]image.Descriptor{}, img.Manifest.Layers...)
+	blobs = append(blobs, img.Manifest.Config)
+	for _, desc := range blobs {
+		exists, err := repo.HasBlob(desc.Digest)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			fmt.Fprintf(out, "%s: Layer already exists\n", desc.Digest.ShortID())
+			continue
+		}
+		if err = pushBlob(store, repo, desc); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(out, "%s: Pushed\n", desc.Digest.ShortID())
+	}
+	manifest, err := ioutil.ReadFile(store.BlobPath(img.Id))
+	if err != nil {
+		return "", err
+	}
+	if actual := image.FromBytes(manifest); actual != img.Id {
+		return "", fmt.Errorf("manifest %s is corrupted, actual digest %s", img.Id, actual)
+	}
+	mediaType := img.Manifest.MediaType
+	if mediaType == "" {
+		mediaType = image.MediaTypeManifest
+	}
+	d, err := repo.PutManifest(ref.Tag, mediaType, manifest)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(out, "%s: digest: %s size: %d\n", ref.Tag, d, len(manifest))
+	return d, nil
+}
+
+func pushBlob(store *image.Store, repo *Repository, desc image.Descriptor) error {
+	r, err := store.OpenBlob(desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return repo.PutBlob(desc, r)
+}
@@ -0,0 +1,332 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mydocker/image"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// docker hub实际提供API的地址
+const dockerHubHost = "registry-1.docker.io"
+
+// 用identity token换取token时的client_id
+const oauthClientID = "mydocker"
+
+// 登录仓库的用户名和密码
+type Credentials struct {
+	Username string
+	Password string
+	// OAuth2的refresh token，不为空时用它代替密码换取token
+	IdentityToken string
+}
+
+// 按仓库地址返回凭据，没有凭据时返回nil
+type CredentialsFunc func(domain string) (*Credentials, error)
+
+// 仓库中的一个镜像仓库，实现registry v2 HTTP API
+type Repository struct {
+	domain  string
+	path    string
+	baseURL string
+	// pull或者pull,push
+	actions     string
+	credentials CredentialsFunc
+	client      *http.Client
+	// 认证后每个请求带上的Authorization
+	authorization string
+}
+
+func NewRepository(ref *image.Reference, actions []string, credentials CredentialsFunc) (*Repository, error) {
+	r := &Repository{
+		domain:      ref.Domain,
+		path:        ref.Path,
+		actions:     strings.Join(actions, ","),
+		credentials: credentials,
+		client:      newClient(),
+	}
+	if err := r.ping(apiHost(ref.Domain)); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// 只限制建立连接和等待响应的时间，大的层下载和上传需要多久都可以
+func newClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: time.Minute,
+			ExpectContinueTimeout: time.Second,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConns:          10,
+		},
+	}
+}
+
+func apiHost(domain string) string {
+	if domain == image.DefaultDomain {
+		return dockerHubHost
+	}
+	return domain
+}
+
+// 用凭据访问仓库的/v2/，需要token时向token服务认证，确认凭据有效
+func Login(domain string, creds *Credentials) error {
+	r := &Repository{
+		domain: domain,
+		credentials: func(string) (*Credentials, error) {
+			return creds, nil
+		},
+		client: newClient(),
+	}
+	if err := r.ping(apiHost(domain)); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", r.baseURL+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("login to %s: %v", domain, responseError(resp))
+	}
+	return nil
+}
+
+// 本机的仓库允许使用http
+func isInsecure(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// 访问/v2/确认仓库支持v2 API，本机的仓库https不可用时退回http
+func (r *Repository) ping(host string) error {
+	schemes := []string{"https"}
+	if isInsecure(host) {
+		schemes = append(schemes, "http")
+	}
+	var err error
+	for _, scheme := range schemes {
+		var resp *http.Response
+		resp, err = r.client.Get(scheme + "://" + host + "/v2/")
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+			return fmt.Errorf("registry %s does not support v2 API: %s", r.domain, resp.Status)
+		}
+		r.baseURL = scheme + "://" + host
+		return nil
+	}
+	return fmt.Errorf("ping registry %s:%v", r.domain, err)
+}
+
+func (r *Repository) url(format string, args ...interface{}) string {
+	return r.baseURL + "/v2/" + r.path + fmt.Sprintf(format, args...)
+}
+
+// 发送请求，收到401时按WWW-Authenticate认证后重试一次
+func (r *Repository) do(req *http.Request) (*http.Response, error) {
+	if r.authorization != "" {
+		req.Header.Set("Authorization", r.authorization)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+	if err = r.authenticate(resp.Header.Get("WWW-Authenticate")); err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("%s %s: unauthorized", req.Method, req.URL)
+		}
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", r.authorization)
+	return r.client.Do(retry)
+}
+
+// 支持Basic和Bearer两种认证，Bearer先用凭据向realm换取token
+func (r *Repository) authenticate(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	var creds *Credentials
+	if r.credentials != nil {
+		var err error
+		if creds, err = r.credentials(r.domain); err != nil {
+			return err
+		}
+	}
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if creds == nil {
+			return fmt.Errorf("unauthorized: authentication required for %s", r.domain)
+		}
+		if creds.IdentityToken != "" {
+			return fmt.Errorf("registry %s does not support identity tokens", r.domain)
+		}
+		req, _ := http.NewRequest("GET", "", nil)
+		req.SetBasicAuth(creds.Username, creds.Password)
+		r.authorization = req.Header.Get("Authorization")
+		return nil
+	case "bearer":
+		token, err := r.fetchToken(params, creds)
+		if err != nil {
+			return err
+		}
+		r.authorization = "Bearer " + token
+		return nil
+	}
+	return fmt.Errorf("unsupported authentication scheme %q from %s", scheme, r.domain)
+}
+
+func (r *Repository) fetchToken(params map[string]string, creds *Credentials) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm %q from %s", params["realm"], r.domain)
+	}
+	values := url.Values{}
+	if service := params["service"]; service != "" {
+		values.Set("service", service)
+	}
+	// 登录时没有仓库，只验证凭据
+	if r.path != "" {
+		values.Set("scope", fmt.Sprintf("repository:%s:%s", r.path, r.actions))
+	}
+	var req *http.Request
+	if creds != nil && creds.IdentityToken != "" {
+		// 按OAuth2的refresh token流程用POST换取access token
+		values.Set("grant_type", "refresh_token")
+		values.Set("refresh_token", creds.IdentityToken)
+		values.Set("client_id", oauthClientID)
+		if req, err = http.NewRequest("POST", realm.String(), strings.NewReader(values.Encode())); err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := realm.Query()
+		for key := range values {
+			query.Set(key, values.Get(key))
+		}
+		if creds != nil {
+			query.Set("account", creds.Username)
+		}
+		realm.RawQuery = query.Encode()
+		if req, err = http.NewRequest("GET", realm.String(), nil); err != nil {
+			return "", err
+		}
+		if creds != nil {
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("get token from %s: %v", realm.Host, responseError(resp))
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("get token from %s:%v", realm.Host, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("no token in response from %s", realm.Host)
+	}
+	return token.Token, nil
+}
+
+// 解析 Bearer realm="...",service="...",scope="a,b" 这样的认证要求
+func parseChallenge(header string) (string, map[string]string) {
+	params := make(map[string]string)
+	header = strings.TrimSpace(header)
+	i := strings.IndexByte(header, ' ')
+	if i == -1 {
+		return header, params
+	}
+	scheme, rest := header[:i], header[i+1:]
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq == -1 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimSpace(rest[eq+1:])
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			value, rest = rest[1:end+1], rest[end+1:]
+			if rest != "" {
+				rest = rest[1:]
+			}
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end == -1 {
+				end = len(rest)
+			}
+			value, rest = strings.TrimSpace(rest[:end]), rest[end:]
+		}
+		params[key] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return scheme, params
+}
+
+// 把仓库返回的错误 {"errors":[{"code":...,"message":...}]} 转换为error
+func responseError(resp *http.Response) error {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(b, &body) == nil && len(body.Errors) > 0 {
+		var messages []string
+		for _, e := range body.Errors {
+			messages = append(messages, strings.ToLower(e.Code)+": "+e.Message)
+		}
+		return fmt.Errorf("%s", strings.Join(messages, "; "))
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("unauthorized: authentication required")
+	}
+	return fmt.Errorf("unexpected status %s", resp.Status)
+}
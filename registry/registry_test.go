@@ -0,0 +1,365 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"mydocker/image"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 只实现推送和拉取用到的接口，所有/v2/请求都需要token
+type fakeRegistry struct {
+	mu        sync.Mutex
+	server    *httptest.Server
+	blobs     map[image.Digest][]byte
+	uploads   map[string][]byte
+	manifests map[string][]byte
+	// 不为空时PUT和GET manifest返回这个摘要
+	manifestDigest string
+	tokens         int
+	posts          int
+	patches        int
+}
+
+func newFakeRegistry() *fakeRegistry {
+	f := &fakeRegistry{
+		blobs:     make(map[image.Digest][]byte),
+		uploads:   make(map[string][]byte),
+		manifests: make(map[string][]byte),
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+func (f *fakeRegistry) host() string {
+	return strings.TrimPrefix(f.server.URL, "http://")
+}
+
+func (f *fakeRegistry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if req.URL.Path == "/token" {
+		// identity token用POST的表单，用户名和密码用GET的basic认证
+		if req.Method == "POST" {
+			if req.PostFormValue("grant_type") != "refresh_token" || req.PostFormValue("refresh_token") != "refresh" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			f.tokens++
+			fmt.Fprint(w, `{"access_token":"tok"}`)
+			return
+		}
+		user, password, ok := req.BasicAuth()
+		if !ok || user != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if scope := req.URL.Query().Get("scope"); scope != "" && !strings.HasPrefix(scope, "repository:test/img:") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		f.tokens++
+		fmt.Fprint(w, `{"token":"tok"}`)
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer tok" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake"`, f.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/v2/test/img")
+	body, _ := ioutil.ReadAll(req.Body)
+	switch {
+	case req.URL.Path == "/v2/":
+	case strings.HasPrefix(path, "/blobs/uploads/"):
+		f.serveUpload(w, req, strings.TrimPrefix(path, "/blobs/uploads/"), body)
+	case strings.HasPrefix(path, "/blobs/"):
+		b, ok := f.blobs[image.Digest(strings.TrimPrefix(path, "/blobs/"))]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if req.Method == "GET" {
+			w.Write(b)
+		}
+	case strings.HasPrefix(path, "/manifests/") && req.Method == "PUT":
+		f.manifests[strings.TrimPrefix(path, "/manifests/")] = body
+		w.Header().Set("Docker-Content-Digest", f.digestOf(body))
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(path, "/manifests/"):
+		b, ok := f.manifests[strings.TrimPrefix(path, "/manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", image.MediaTypeManifest)
+		w.Header().Set("Docker-Content-Digest", f.digestOf(b))
+		w.Write(b)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeRegistry) digestOf(b []byte) string {
+	if f.manifestDigest != "" {
+		return f.manifestDigest
+	}
+	return image.FromBytes(b).String()
+}
+
+// POST开始上传，PATCH按Content-Range追加，PUT带上digest结束
+func (f *fakeRegistry) serveUpload(w http.ResponseWriter, req *http.Request, id string, body []byte) {
+	switch req.Method {
+	case "POST":
+		f.posts++
+		id = strconv.Itoa(f.posts)
+		f.uploads[id] = nil
+	case "PATCH":
+		f.patches++
+		start := strconv.Itoa(len(f.uploads[id])) + "-"
+		if !strings.HasPrefix(req.Header.Get("Content-Range"), start) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		f.uploads[id] = append(f.uploads[id], body...)
+	case "PUT":
+		b := append(f.uploads[id], body...)
+		d := image.Digest(req.URL.Query().Get("digest"))
+		if image.FromBytes(b) != d {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors":[{"code":"DIGEST_INVALID","message":"digest mismatch"}]}`)
+			return
+		}
+		f.blobs[d] = b
+		delete(f.uploads, id)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	// 返回相对地址
+	w.Header().Set("Location", "/v2/test/img/blobs/uploads/"+id)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func testCredentials(string) (*Credentials, error) {
+	return &Credentials{Username: "user", Password: "secret"}, nil
+}
+
+func newTestRepository(t *testing.T, f *fakeRegistry, credentials CredentialsFunc) *Repository {
+	ref := &image.Reference{Domain: f.host(), Path: "test/img", Tag: "latest"}
+	repo, err := NewRepository(ref, []string{"pull", "push"}, credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// 本地存储中一个只有一层的镜像
+func newTestImage(t *testing.T, store *image.Store) (image.Digest, image.Descriptor) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	content := []byte("hello\n")
+	if err := tw.WriteHeader(&tar.Header{Name: "hello", Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(content)
+	tw.Close()
+	layer, diffID, err := store.PutLayer(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := image.Scratch()
+	config.RootFS.DiffIDs = []image.Digest{diffID}
+	id, err := store.CreateImage(config, []image.Descriptor{layer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, layer
+}
+
+func TestPushSkipsExistingBlobs(t *testing.T) {
+	f := newFakeRegistry()
+	defer f.server.Close()
+	root, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	store := image.New(root)
+	id, layer := newTestImage(t, store)
+	ref := &image.Reference{Domain: f.host(), Path: "test/img", Tag: "latest"}
+	if err = store.Tag(ref.Name()+":latest", id); err != nil {
+		t.Fatal(err)
+	}
+	// 层已经在仓库中，只需要上传配置
+	layerBytes, err := ioutil.ReadFile(store.BlobPath(layer.Digest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.blobs[layer.Digest] = layerBytes
+	d, err := Push(store, ref, testCredentials, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != id {
+		t.Errorf("Push() = %s, want %s", d, id)
+	}
+	if f.posts != 1 {
+		t.Errorf("started %d uploads, want 1 for the config", f.posts)
+	}
+	if f.tokens == 0 {
+		t.Error("no token was fetched")
+	}
+	img, err := store.GetImage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.blobs[img.Manifest.Config.Digest]; !ok {
+		t.Error("config was not uploaded")
+	}
+	if _, ok := f.manifests["latest"]; !ok {
+		t.Error("manifest was not uploaded")
+	}
+}
+
+func TestPutBlobChunked(t *testing.T) {
+	f := newFakeRegistry()
+	defer f.server.Close()
+	repo := newTestRepository(t, f, testCredentials)
+	content := make([]byte, 2*uploadChunkSize+100)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	desc := image.Descriptor{Digest: image.FromBytes(content), Size: int64(len(content))}
+	if err := repo.PutBlob(desc, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if f.patches != 3 {
+		t.Errorf("sent %d PATCH requests, want 3", f.patches)
+	}
+	if !bytes.Equal(f.blobs[desc.Digest], content) {
+		t.Error("uploaded blob differs from the content")
+	}
+	// 摘要不对时仓库拒绝，返回仓库的错误信息
+	desc.Digest = image.FromBytes([]byte("other"))
+	err := repo.PutBlob(desc, bytes.NewReader(content))
+	if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Errorf("PutBlob() with a wrong digest: %v", err)
+	}
+}
+
+func TestTokenAuthentication(t *testing.T) {
+	f := newFakeRegistry()
+	defer f.server.Close()
+	repo := newTestRepository(t, f, func(string) (*Credentials, error) {
+		return &Credentials{Username: "user", Password: "wrong"}, nil
+	})
+	if _, err := repo.HasBlob(image.FromBytes(nil)); err == nil {
+		t.Error("HasBlob() with wrong password succeeded")
+	}
+	repo = newTestRepository(t, f, testCredentials)
+	exists, err := repo.HasBlob(image.FromBytes(nil))
+	if err != nil || exists {
+		t.Errorf("HasBlob() = %v, %v, want false, nil", exists, err)
+	}
+	if repo.authorization != "Bearer tok" {
+		t.Errorf("authorization = %q, want the bearer token", repo.authorization)
+	}
+	if err = Login(f.host(), &Credentials{Username: "user", Password: "secret"}); err != nil {
+		t.Errorf("Login() = %v", err)
+	}
+}
+
+func TestIdentityToken(t *testing.T) {
+	f := newFakeRegistry()
+	defer f.server.Close()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv(configDirEnv, dir)
+	defer os.Unsetenv(configDirEnv)
+	config := `{"auths":{"` + f.host() + `":{"identitytoken":"refresh"}}}`
+	if err = ioutil.WriteFile(filepath.Join(dir, configFileName), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := c.GetCredentials(f.host())
+	if err != nil || creds == nil || creds.IdentityToken != "refresh" {
+		t.Fatalf("GetCredentials() = %+v, %v", creds, err)
+	}
+	repo := newTestRepository(t, f, c.GetCredentials)
+	if _, err = repo.HasBlob(image.FromBytes(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if repo.authorization != "Bearer tok" {
+		t.Errorf("authorization = %q, want the bearer token", repo.authorization)
+	}
+}
+
+func TestManifestDigestMismatch(t *testing.T) {
+	f := newFakeRegistry()
+	defer f.server.Close()
+	repo := newTestRepository(t, f, testCredentials)
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"` + image.MediaTypeManifest + `"}`)
+	f.manifests["latest"] = manifest
+	if _, _, d, err := repo.GetManifest("latest"); err != nil || d != image.FromBytes(manifest) {
+		t.Fatalf("GetManifest() = %s, %v", d, err)
+	}
+	f.manifestDigest = image.FromBytes([]byte("other")).String()
+	if _, _, _, err := repo.GetManifest("latest"); err == nil || !strings.Contains(err.Error(), "expected") {
+		t.Errorf("GetManifest() with a wrong digest header: %v", err)
+	}
+	if _, err := repo.PutManifest("v1", image.MediaTypeManifest, manifest); err == nil {
+		t.Error("PutManifest() accepted a wrong digest from the registry")
+	}
+	// 按digest拉取时以请求的digest为准
+	f.manifestDigest = ""
+	f.manifests["sha256:"+strings.Repeat("0", 64)] = manifest
+	if _, _, _, err := repo.GetManifest("sha256:" + strings.Repeat("0", 64)); err == nil {
+		t.Error("GetManifest() by digest accepted different content")
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		header     string
+		wantScheme string
+		wantParams map[string]string
+	}{
+		{"Basic", "Basic", map[string]string{}},
+		{`Basic realm="registry"`, "Basic", map[string]string{"realm": "registry"}},
+		{
+			`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/busybox:pull,push"`,
+			"Bearer",
+			map[string]string{
+				"realm":   "https://auth.docker.io/token",
+				"service": "registry.docker.io",
+				"scope":   "repository:library/busybox:pull,push",
+			},
+		},
+		{`Bearer realm=https://r/token, service=r`, "Bearer", map[string]string{"realm": "https://r/token", "service": "r"}},
+		{`Bearer Realm="x"`, "Bearer", map[string]string{"realm": "x"}},
+		{`Bearer realm="unterminated`, "Bearer", map[string]string{"realm": "unterminated"}},
+	}
+	for _, tt := range tests {
+		scheme, params := parseChallenge(tt.header)
+		if scheme != tt.wantScheme || !reflect.DeepEqual(params, tt.wantParams) {
+			t.Errorf("parseChallenge(%q) = %q, %v, want %q, %v", tt.header, scheme, params, tt.wantScheme, tt.wantParams)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"mydocker/container"
+	"mydocker/subsystems"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 实际运行的命令
+func Run(tty bool, info *container.ContainerInfo) (err error) {
+	if err = container.RecordContainerInfo(info); err != nil {
+		return err
+	}
+	// 后台运行的容器交给monitor进程等待和重启
+	if !tty {
+		return startMonitor(info.Id)
+	}
+	if err = runContainer(info, tty); err != nil {
+		return err
+	}
+	//rootURL := "/root/test1/"
+	//mntURL := "/root/test1/mnt/"
+	//if err = container.DeleteWorkSpace(rootURL, mntURL, volume); err != nil {
+	//	return err
+	//}
+	return container.DeleteContainerInfo(info.Id)
+}
+
+// 启动一个脱离当前会话的monitor进程
+func startMonitor(containerID string) error {
+	cmd := exec.Command("/proc/self/exe", "monitor", containerID)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+func Monitor(containerID string) error {
+	info, err := container.GetContainerInfo(containerID)
+	if err != nil {
+		return err
+	}
+	return runContainer(info, false)
+}
+
+// 运行容器并等待退出，按重启策略以同样的rootfs、cgroup重新拉起
+func runContainer(info *container.ContainerInfo, tty bool) error {
+	// 设置容器资源限制
+	cgroupManager := subsystems.NewCgroupManager(info.CgroupPath)
+	// 命令结束时候清理容器限制
+	defer cgroupManager.Destroy()
+	var backoff container.RestartBackoff
+	for {
+		startTime := time.Now()
+		exitCode, started, err := startContainer(info, tty, cgroupManager)
+		if err != nil || !started {
+			return err
+		}
+		restart := false
+		latest, err := container.UpdateContainerInfo(info.Id, func(latest *container.ContainerInfo) error {
+			stopped := latest.Status == container.Stop
+			restart = latest.RestartPolicy.ShouldRestart(exitCode, latest.RestartCount, stopped)
+			latest.ExitCode = exitCode
+			if restart {
+				latest.Status = container.Restarting
+			} else if !stopped {
+				latest.Status = container.Exit
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		*info = *latest
+		if !restart {
+			return nil
+		}
+		delay := backoff.Next(time.Since(startTime))
+		log.Infof("container %s exited with %d, restart in %s", info.Name, exitCode, delay)
+		time.Sleep(delay)
+	}
+}
+
+// 启动一次容器进程并等待其退出，返回退出码
+// 启动在容器锁内进行，容器已经被stop时不再启动
+func startContainer(info *container.ContainerInfo, tty bool, cgroupManager *subsystems.CgroupManager) (exitCode int, started bool, err error) {
+	// 创建命令环境,并且返回一个写管道，用于写入命令字符串
+	parent, writePipe, err := container.NewContainerProcess(tty, info.Volume, info.Id)
+	if err != nil {
+		return
+	}
+	defer writePipe.Close()
+	defer container.CloseProcessFiles(parent)
+	latest, err := container.UpdateContainerInfo(info.Id, func(latest *container.ContainerInfo) error {
+		if latest.Status == container.Stop {
+			return nil
+		}
+		// 每次启动时按最新的配置设置资源，update修改的限制在重启后继续生效
+		if err := cgroupManager.Set(latest.ResourceConfig); err != nil {
+			return err
+		}
+		if err := parent.Start(); err != nil {
+			return err
+		}
+		// 把对应的进程pid写入cgroup
+		if err := cgroupManager.Apply(parent.Process.Pid); err != nil {
+			parent.Process.Kill()
+			parent.Wait()
+			return err
+		}
+		if latest.Status == container.Restarting {
+			latest.RestartCount++
+		}
+		latest.Pid = strconv.Itoa(parent.Process.Pid)
+		latest.PidStartTime, _ = container.ProcessStartTime(parent.Process.Pid)
+		if latest.OomScoreAdj != 0 {
+			if err := container.SetOomScoreAdj(parent.Process.Pid, latest.OomScoreAdj); err != nil {
+				parent.Process.Kill()
+				parent.Wait()
+				return err
+			}
+		}
+		latest.Status = container.Running
+		// 记录等待容器的进程，它退出之前容器状态由它维护
+		latest.MonitorPid = os.Getpid()
+		latest.MonitorStartTime, _ = container.ProcessStartTime(latest.MonitorPid)
+		started = true
+		return nil
+	})
+	if err != nil || !started {
+		return
+	}
+	*info = *latest
+	// 发送命令到管道
+	if err = sendCommand(info.Argv(), writePipe); err != nil {
+		return
+	}
+	exitCode, err = waitExitCode(parent)
+	return
+}
+
+func waitExitCode(cmd *exec.Cmd) (int, error) {
+	err := cmd.Wait()
+	if err == nil {
+		return 0, nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, err
+	}
+	// 被信号杀死的进程按照shell的约定返回128+信号值
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal()), nil
+	}
+	return exitErr.ExitCode(), nil
+}
+
+func sendCommand(commandArr []string, writePipe *os.File) (err error) {
+	log.Infof("command is %s", strings.Join(commandArr, " "))
+	// 用json编码，参数中可以有空格
+	b, err := json.Marshal(commandArr)
+	if err != nil {
+		return err
+	}
+	if _, err = writePipe.Write(b); err != nil {
+		return err
+	}
+	writePipe.Close()
+	return
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// migrations[i]把状态目录从版本i迁移到版本i+1
+var migrations = []func(s *Store) error{
+	migrateNameDirs,
+}
+
+// 把状态目录迁移到当前版本
+func (s *Store) Migrate() error {
+	lock, err := s.LockGlobal()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	version, err := s.readVersion()
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("state schema version %d in %s is newer than supported version %d",
+			version, s.Root, SchemaVersion)
+	}
+	if _, err = os.Stat(filepath.Join(s.Root, versionFile)); os.IsNotExist(err) && version == SchemaVersion {
+		return WriteFileAtomic(filepath.Join(s.Root, versionFile), []byte(strconv.Itoa(version)), 0644)
+	}
+	for ; version < SchemaVersion; version++ {
+		logrus.Debugf("migrate state in %s from version %d", s.Root, version)
+		if err = migrations[version](s); err != nil {
+			return fmt.Errorf("migrate state from version %d:%v", version, err)
+		}
+		if err = WriteFileAtomic(filepath.Join(s.Root, versionFile), []byte(strconv.Itoa(version+1)), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 版本0中容器信息保存在<root>/<name>/config.json，迁移为按ID保存并建立名字索引
+func migrateNameDirs(s *Store) error {
+	fileList, err := ioutil.ReadDir(s.Root)
+	if err != nil {
+		return err
+	}
+	for _, fileInfo := range fileList {
+		if !fileInfo.IsDir() || fileInfo.Name() == containersDir || fileInfo.Name() == namesDir {
+			continue
+		}
+		oldDir := filepath.Join(s.Root, fileInfo.Name())
+		b, err := ioutil.ReadFile(filepath.Join(oldDir, configName))
+		if err != nil {
+			logrus.Warnf("skip migrating %s:%v", oldDir, err)
+			continue
+		}
+		var info struct {
+			Id   string `json:"id"`
+			Name string `json:"name"`
+		}
+		if err = json.Unmarshal(b, &info); err != nil || info.Id == "" {
+			logrus.Warnf("skip migrating %s: invalid config", oldDir)
+			continue
+		}
+		if info.Name == "" {
+			info.Name = fileInfo.Name()
+		}
+		if err = os.MkdirAll(filepath.Join(s.Root, containersDir), 0755); err != nil {
+			return err
+		}
+		if err = os.Rename(oldDir, s.ContainerDir(info.Id)); err != nil {
+			return err
+		}
+		if err = s.linkName(info.Name, info.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
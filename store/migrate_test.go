@@ -0,0 +1,81 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	// 版本0按名字保存容器信息，旧的记录可能没有name
+	v0 := map[string]string{
+		"web": `{"id":"abc","name":"web","status":"running"}`,
+		"db":  `{"id":"def"}`,
+		"bad": `not json`,
+	}
+	for name, config := range v0 {
+		if err := os.MkdirAll(filepath.Join(s.Root, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(s.Root, name, configName), []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := s.readVersion(); err != nil || version != SchemaVersion {
+		t.Errorf("version = %d, %v, want %d", version, err, SchemaVersion)
+	}
+	for name, id := range map[string]string{"web": "abc", "db": "def"} {
+		if got, err := s.LookupName(name); err != nil || got != id {
+			t.Errorf("LookupName(%s) = %q, %v, want %s", name, got, err, id)
+		}
+		if _, err := os.Stat(filepath.Join(s.Root, name)); !os.IsNotExist(err) {
+			t.Errorf("old directory %s was not moved: %v", name, err)
+		}
+	}
+	var info map[string]string
+	if err := s.ReadContainer("abc", &info); err != nil || info["status"] != "running" {
+		t.Errorf("migrated config = %v, %v", info, err)
+	}
+	// 无法识别的目录保留原样
+	if _, err := os.Stat(filepath.Join(s.Root, "bad", configName)); err != nil {
+		t.Errorf("invalid v0 directory was touched: %v", err)
+	}
+
+	// 再次迁移不做任何修改
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := s.LookupName("web"); err != nil || id != "abc" {
+		t.Errorf("LookupName(web) after second migrate = %q, %v", id, err)
+	}
+}
+
+func TestMigrateVersion(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	// 没有版本文件但已经有containers目录时记录为当前版本
+	if err := os.MkdirAll(filepath.Join(s.Root, containersDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(s.Root, versionFile))
+	if err != nil || string(b) != strconv.Itoa(SchemaVersion) {
+		t.Errorf("version file = %q, %v", b, err)
+	}
+	// 更新的版本拒绝迁移
+	if err = WriteFileAtomic(filepath.Join(s.Root, versionFile), []byte(strconv.Itoa(SchemaVersion+1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Migrate(); err == nil {
+		t.Error("Migrate of a newer schema succeeded")
+	}
+}
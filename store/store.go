@@ -0,0 +1,259 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 磁盘上状态目录的版本，目录结构变化时递增并在migrations中增加对应的迁移
+const SchemaVersion = 1
+
+const (
+	DefaultRoot   = "/var/run/mydocker"
+	versionFile   = "version"
+	lockFileName  = "lock"
+	containersDir = "containers"
+	namesDir      = "names"
+	configName    = "config.json"
+)
+
+// 容器状态的存储，目录结构如下:
+//
+//	<root>/version                     状态目录的版本
+//	<root>/lock                        全局锁
+//	<root>/containers/<id>/config.json 容器信息
+//	<root>/containers/<id>/lock        容器锁
+//	<root>/names/<name> -> <id>        名字索引
+type Store struct {
+	Root string
+}
+
+func New(root string) *Store {
+	return &Store{Root: root}
+}
+
+type Lock struct {
+	file *os.File
+}
+
+// 对path加flock，文件不存在时创建
+func LockFile(path string, how int) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("lock %s:%v", path, err)
+	}
+	return &Lock{file: file}, nil
+}
+
+func (l *Lock) Unlock() error {
+	defer l.file.Close()
+	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+}
+
+// 创建、删除容器和修改名字索引时持有全局锁
+func (s *Store) LockGlobal() (*Lock, error) {
+	if err := os.MkdirAll(s.Root, 0755); err != nil {
+		return nil, err
+	}
+	return LockFile(filepath.Join(s.Root, lockFileName), syscall.LOCK_EX)
+}
+
+// 修改单个容器信息时持有容器锁
+func (s *Store) LockContainer(id string) (*Lock, error) {
+	if !s.ContainerExists(id) {
+		return nil, fmt.Errorf("no such container: %s", id)
+	}
+	return LockFile(filepath.Join(s.ContainerDir(id), lockFileName), syscall.LOCK_EX)
+}
+
+func (s *Store) ContainerDir(id string) string {
+	return filepath.Join(s.Root, containersDir, id)
+}
+
+func (s *Store) ContainerExists(id string) bool {
+	if id == "" || strings.Contains(id, "/") {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(s.ContainerDir(id), configName))
+	return err == nil
+}
+
+func (s *Store) ListContainers() ([]string, error) {
+	fileList, err := ioutil.ReadDir(filepath.Join(s.Root, containersDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, fileInfo := range fileList {
+		if fileInfo.IsDir() {
+			ids = append(ids, fileInfo.Name())
+		}
+	}
+	return ids, nil
+}
+
+// 原子地创建容器，名字已被占用时返回错误
+func (s *Store) CreateContainer(id, name string, v interface{}) error {
+	lock, err := s.LockGlobal()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if owner, err := s.LookupName(name); err == nil {
+		return fmt.Errorf("container name %s is already in use by container %s", name, owner)
+	}
+	dir := s.ContainerDir(id)
+	if _, err = os.Stat(dir); err == nil {
+		return fmt.Errorf("container %s already exists", id)
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err = s.WriteContainer(id, v); err == nil {
+		err = s.linkName(name, id)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) linkName(name, id string) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("invalid container name %q", name)
+	}
+	if err := os.MkdirAll(filepath.Join(s.Root, namesDir), 0755); err != nil {
+		return err
+	}
+	link := filepath.Join(s.Root, namesDir, name)
+	// 持有全局锁时遗留的名字可以直接覆盖
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(id, link)
+}
+
+// 返回名字对应的容器ID，名字不存在或者指向已删除的容器时返回错误
+func (s *Store) LookupName(name string) (string, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("invalid container name %q", name)
+	}
+	id, err := os.Readlink(filepath.Join(s.Root, namesDir, name))
+	if err != nil {
+		return "", err
+	}
+	if !s.ContainerExists(id) {
+		return "", fmt.Errorf("no such container: %s", name)
+	}
+	return id, nil
+}
+
+func (s *Store) ReadContainer(id string, v interface{}) error {
+	b, err := ioutil.ReadFile(filepath.Join(s.ContainerDir(id), configName))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// 调用者需要持有容器锁或者全局锁
+func (s *Store) WriteContainer(id string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(s.ContainerDir(id), configName), b, 0644)
+}
+
+// 在容器锁内读取最新的状态到v，调用fn修改后写回
+func (s *Store) UpdateContainer(id string, v interface{}, fn func() error) error {
+	lock, err := s.LockContainer(id)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	if err = s.ReadContainer(id, v); err != nil {
+		return err
+	}
+	if err = fn(); err != nil {
+		return err
+	}
+	return s.WriteContainer(id, v)
+}
+
+func (s *Store) DeleteContainer(id, name string) error {
+	lock, err := s.LockGlobal()
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	link := filepath.Join(s.Root, namesDir, name)
+	if owner, err := os.Readlink(link); err == nil && owner == id {
+		if err = os.Remove(link); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(s.ContainerDir(id))
+}
+
+// 先写临时文件并fsync，再rename覆盖目标文件，读者不会看到写了一半的内容
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filename)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+	// 同步目录保证rename落盘
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s *Store) readVersion() (int, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.Root, versionFile))
+	if err == nil {
+		return strconv.Atoi(strings.TrimSpace(string(b)))
+	}
+	if !os.IsNotExist(err) {
+		return 0, err
+	}
+	// 没有版本文件时，已经有containers目录说明是版本1
+	if _, err = os.Stat(filepath.Join(s.Root, containersDir)); err == nil {
+		return 1, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,226 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tempStore(t *testing.T) (*Store, func()) {
+	root, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(root), func() { os.RemoveAll(root) }
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "config.json")
+	if err = WriteFileAtomic(filename, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteFileAtomic(filename, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil || string(b) != "new" {
+		t.Errorf("content = %q, %v, want new", b, err)
+	}
+	if fi, err := os.Stat(filename); err != nil || fi.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, %v, want 0644", fi.Mode(), err)
+	}
+
+	// rename到非空目录失败，目标保持不变且不留下临时文件
+	target := filepath.Join(dir, "target")
+	if err = os.MkdirAll(filepath.Join(target, "child"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = WriteFileAtomic(target, []byte("partial"), 0644); err == nil {
+		t.Fatal("WriteFileAtomic over a non-empty directory succeeded")
+	}
+	if fi, err := os.Stat(target); err != nil || !fi.IsDir() {
+		t.Errorf("target was replaced: %v, %v", fi, err)
+	}
+	// 目录不存在时不会创建文件
+	if err = WriteFileAtomic(filepath.Join(dir, "missing", "f"), []byte("x"), 0644); err == nil {
+		t.Error("WriteFileAtomic into a missing directory succeeded")
+	}
+	fileList, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fileInfo := range fileList {
+		if strings.Contains(fileInfo.Name(), ".tmp") {
+			t.Errorf("temporary file %s left behind", fileInfo.Name())
+		}
+	}
+}
+
+type counter struct {
+	Id    string `json:"id"`
+	Count int    `json:"count"`
+}
+
+func TestUpdateContainerSerialized(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	if err := s.CreateContainer("abc", "web", &counter{Id: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	// 每个写者读取后等待一会再写回，没有容器锁时会丢失更新
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var c counter
+			err := s.UpdateContainer("abc", &c, func() error {
+				time.Sleep(time.Millisecond)
+				c.Count++
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	var c counter
+	if err := s.ReadContainer("abc", &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Count != writers {
+		t.Errorf("count = %d, want %d", c.Count, writers)
+	}
+	if _, err := s.LockContainer("missing"); err == nil {
+		t.Error("LockContainer of a missing container succeeded")
+	}
+}
+
+func TestLockGlobal(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	lock, err := s.LockGlobal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan struct{})
+	go func() {
+		other, err := s.LockGlobal()
+		if err != nil {
+			t.Error(err)
+			close(acquired)
+			return
+		}
+		close(acquired)
+		other.Unlock()
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("global lock acquired while held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	lock.Unlock()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("global lock not acquired after unlock")
+	}
+}
+
+func TestCreateContainerConcurrentName(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	// 同名的并发创建只有一个成功
+	ids := []string{"a1", "a2", "a3", "a4", "a5", "a6", "a7", "a8"}
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			errs[i] = s.CreateContainer(id, "web", &counter{Id: id})
+		}(i, id)
+	}
+	wg.Wait()
+	created := 0
+	for i, err := range errs {
+		if err == nil {
+			created++
+			continue
+		}
+		if !strings.Contains(err.Error(), "already in use") {
+			t.Errorf("CreateContainer(%s) error = %v", ids[i], err)
+		}
+		if s.ContainerExists(ids[i]) {
+			t.Errorf("failed container %s was left behind", ids[i])
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d containers named web were created, want 1", created)
+	}
+}
+
+func TestNameIndex(t *testing.T) {
+	s, cleanup := tempStore(t)
+	defer cleanup()
+	if err := s.CreateContainer("abc", "web", &counter{Id: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := s.LookupName("web"); err != nil || id != "abc" {
+		t.Errorf("LookupName(web) = %q, %v, want abc", id, err)
+	}
+	if err := s.CreateContainer("def", "web", &counter{Id: "def"}); err == nil ||
+		!strings.Contains(err.Error(), "already in use by container abc") {
+		t.Errorf("duplicate name error = %v", err)
+	}
+	if err := s.CreateContainer("abc", "db", &counter{Id: "abc"}); err == nil {
+		t.Error("duplicate id was accepted")
+	}
+	for _, name := range []string{"", "a/b"} {
+		if err := s.CreateContainer("xyz", name, &counter{Id: "xyz"}); err == nil {
+			t.Errorf("CreateContainer with name %q succeeded", name)
+		}
+		if s.ContainerExists("xyz") {
+			t.Errorf("container with invalid name %q was left behind", name)
+		}
+	}
+
+	// 指向已删除容器的名字可以重新使用
+	if err := os.RemoveAll(s.ContainerDir("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LookupName("web"); err == nil {
+		t.Error("LookupName of a stale name succeeded")
+	}
+	if err := s.CreateContainer("def", "web", &counter{Id: "def"}); err != nil {
+		t.Fatal(err)
+	}
+	// 删除不再拥有名字的容器时保留名字
+	if err := s.DeleteContainer("abc", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := s.LookupName("web"); err != nil || id != "def" {
+		t.Errorf("LookupName(web) = %q, %v, want def", id, err)
+	}
+	if err := s.DeleteContainer("def", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(s.Root, namesDir, "web")); !os.IsNotExist(err) {
+		t.Errorf("name link was not removed: %v", err)
+	}
+	ids, err := s.ListContainers()
+	if err != nil || len(ids) != 0 {
+		t.Errorf("ListContainers() = %v, %v, want none", ids, err)
+	}
+}
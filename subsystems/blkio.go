@@ -0,0 +1,169 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	MinBlkioWeight = 10
+	MaxBlkioWeight = 1000
+)
+
+// 对某个块设备的读写限速
+type ThrottleDevice struct {
+	Path  string `json:"path"`
+	Major int64  `json:"major"`
+	Minor int64  `json:"minor"`
+	Rate  uint64 `json:"rate"`
+}
+
+func (d *ThrottleDevice) String() string {
+	return fmt.Sprintf("%d:%d %d", d.Major, d.Minor, d.Rate)
+}
+
+type BlkioSubsystem struct {
+}
+
+func (s *BlkioSubsystem) Name() string {
+	return "blkio"
+}
+
+func (s *BlkioSubsystem) Set(cpath string, config *ResourceConfig) error {
+	if config.BlkioWeight == 0 && len(config.BlkioDeviceReadBps) == 0 && len(config.BlkioDeviceWriteBps) == 0 &&
+		len(config.BlkioDeviceReadIOps) == 0 && len(config.BlkioDeviceWriteIOps) == 0 {
+		return nil
+	}
+	cpath, err := GetCgroupPathInfo(s.Name(), cpath, true)
+	if err != nil {
+		return err
+	}
+	if IsCgroup2() {
+		return s.setCgroup2(cpath, config)
+	}
+	if config.BlkioWeight != 0 {
+		// 没有CFQ调度器时只有BFQ的权重文件
+		weightFile := path.Join(cpath, "blkio.weight")
+		if _, err := os.Stat(weightFile); os.IsNotExist(err) {
+			weightFile = path.Join(cpath, "blkio.bfq.weight")
+		}
+		if err := ioutil.WriteFile(weightFile, []byte(strconv.FormatUint(uint64(config.BlkioWeight), 10)), 0644); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("blkio weight is not supported by the kernel")
+			}
+			return err
+		}
+	}
+	throttles := []struct {
+		file    string
+		devices []*ThrottleDevice
+	}{
+		{"blkio.throttle.read_bps_device", config.BlkioDeviceReadBps},
+		{"blkio.throttle.write_bps_device", config.BlkioDeviceWriteBps},
+		{"blkio.throttle.read_iops_device", config.BlkioDeviceReadIOps},
+		{"blkio.throttle.write_iops_device", config.BlkioDeviceWriteIOps},
+	}
+	for _, throttle := range throttles {
+		// 每次只能写入一个设备
+		for _, device := range throttle.devices {
+			if err := ioutil.WriteFile(path.Join(cpath, throttle.file), []byte(device.String()), 0644); err != nil {
+				return fmt.Errorf("write %s for %s:%v", throttle.file, device.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// v2中用io.weight和io.max，同一个设备的限制写在一行
+func (s *BlkioSubsystem) setCgroup2(cpath string, config *ResourceConfig) error {
+	if config.BlkioWeight != 0 {
+		weight := BlkioWeightToIOWeight(config.BlkioWeight)
+		if err := ioutil.WriteFile(path.Join(cpath, "io.weight"), []byte(fmt.Sprintf("default %d", weight)), 0644); err != nil {
+			return err
+		}
+	}
+	var devices []string
+	limits := make(map[string][]string)
+	throttles := []struct {
+		key     string
+		devices []*ThrottleDevice
+	}{
+		{"rbps", config.BlkioDeviceReadBps},
+		{"wbps", config.BlkioDeviceWriteBps},
+		{"riops", config.BlkioDeviceReadIOps},
+		{"wiops", config.BlkioDeviceWriteIOps},
+	}
+	for _, throttle := range throttles {
+		for _, device := range throttle.devices {
+			dev := fmt.Sprintf("%d:%d", device.Major, device.Minor)
+			if _, ok := limits[dev]; !ok {
+				devices = append(devices, dev)
+			}
+			limits[dev] = append(limits[dev], fmt.Sprintf("%s=%d", throttle.key, device.Rate))
+		}
+	}
+	for _, dev := range devices {
+		line := dev + " " + strings.Join(limits[dev], " ")
+		if err := ioutil.WriteFile(path.Join(cpath, "io.max"), []byte(line), 0644); err != nil {
+			return fmt.Errorf("write io.max %q:%v", line, err)
+		}
+	}
+	return nil
+}
+
+// 把blkio.weight的[10, 1000]映射到io.weight的[1, 10000]
+func BlkioWeightToIOWeight(weight uint16) uint64 {
+	return 1 + (uint64(weight)-MinBlkioWeight)*9999/(MaxBlkioWeight-MinBlkioWeight)
+}
+
+func (s *BlkioSubsystem) Apply(cpath string, pid int) error {
+	return applyPid(s.Name(), cpath, pid)
+}
+
+func (s *BlkioSubsystem) Remove(cpath string) error {
+	return removeCgroup(s.Name(), cpath)
+}
+
+func ValidateBlkioWeight(weight uint64) error {
+	if weight != 0 && (weight < MinBlkioWeight || weight > MaxBlkioWeight) {
+		return fmt.Errorf("blkio weight should be in range [%d, %d]", MinBlkioWeight, MaxBlkioWeight)
+	}
+	return nil
+}
+
+// 解析 /dev/sda:1mb 形式的限速参数，bps为true时速率可以带单位
+func ParseThrottleDevice(value string, bps bool) (*ThrottleDevice, error) {
+	i := strings.LastIndex(value, ":")
+	if i <= 0 || i == len(value)-1 {
+		return nil, fmt.Errorf("bad format, expected <device-path>:<rate>")
+	}
+	devicePath, rateStr := value[:i], value[i+1:]
+	var rate uint64
+	if bps {
+		bytes, err := ParseBytes(rateStr)
+		if err != nil {
+			return nil, err
+		}
+		rate = uint64(bytes)
+	} else {
+		var err error
+		if rate, err = strconv.ParseUint(rateStr, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid rate %q", rateStr)
+		}
+	}
+	if rate == 0 {
+		return nil, fmt.Errorf("rate must be greater than 0")
+	}
+	deviceType, major, minor, _, err := StatDevice(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	if deviceType != "b" {
+		return nil, fmt.Errorf("%s is not a block device", devicePath)
+	}
+	return &ThrottleDevice{Path: devicePath, Major: major, Minor: minor, Rate: rate}, nil
+}
@@ -0,0 +1,99 @@
+package subsystems
+
+import (
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlkioSet(t *testing.T) {
+	blkio := &BlkioSubsystem{}
+	sda := func(rate uint64) []*ThrottleDevice {
+		return []*ThrottleDevice{{Path: "/dev/sda", Major: 8, Minor: 0, Rate: rate}}
+	}
+	config := &ResourceConfig{
+		BlkioWeight:          500,
+		BlkioDeviceReadBps:   sda(1 << 20),
+		BlkioDeviceWriteIOps: sda(100),
+	}
+	root, cleanup := fakeCgroup1Root(t)
+	defer cleanup()
+	// 有CFQ调度器时写blkio.weight，否则写BFQ的权重
+	writeFiles(t, root, map[string]string{"blkio/mydocker/c1/blkio.weight": ""})
+	if err := blkio.Set("mydocker/c1", config); err != nil {
+		t.Fatal(err)
+	}
+	if err := blkio.Set("mydocker/c2", &ResourceConfig{BlkioWeight: 10}); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, root, map[string]string{
+		"blkio/mydocker/c1/blkio.weight":                     "500",
+		"blkio/mydocker/c1/blkio.throttle.read_bps_device":   "8:0 1048576",
+		"blkio/mydocker/c1/blkio.throttle.write_iops_device": "8:0 100",
+		"blkio/mydocker/c2/blkio.bfq.weight":                 "10",
+	})
+
+	root2, cleanup2 := fakeCgroup2Root(t)
+	defer cleanup2()
+	if err := blkio.Set("mydocker/c1", config); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, root2, map[string]string{
+		"mydocker/c1/io.weight": "default 4950",
+		"mydocker/c1/io.max":    "8:0 rbps=1048576 wiops=100",
+	})
+}
+
+func TestParseThrottleDevice(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating device nodes requires root")
+	}
+	dir, err := ioutil.TempDir("", "blkio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// 次设备号大于255时需要用到dev_t的高位
+	block := filepath.Join(dir, "disk")
+	if err = unix.Mknod(block, unix.S_IFBLK|0600, int(unix.Mkdev(259, 300))); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "file")
+	if err = ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		value   string
+		bps     bool
+		want    ThrottleDevice
+		wantErr string
+	}{
+		{value: block + ":1mb", bps: true, want: ThrottleDevice{Path: block, Major: 259, Minor: 300, Rate: 1 << 20}},
+		{value: block + ":100", want: ThrottleDevice{Path: block, Major: 259, Minor: 300, Rate: 100}},
+		{value: block + ":1mb", wantErr: "invalid rate"},
+		{value: block + ":0", wantErr: "greater than 0"},
+		{value: block, wantErr: "bad format"},
+		{value: "/dev/null:100", wantErr: "not a block device"},
+		{value: file + ":100", wantErr: "not a device"},
+		{value: filepath.Join(dir, "missing") + ":100", wantErr: "no such file"},
+	}
+	for _, tt := range tests {
+		got, err := ParseThrottleDevice(tt.value, tt.bps)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseThrottleDevice(%q) error = %v, want %q", tt.value, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseThrottleDevice(%q) error = %v", tt.value, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("ParseThrottleDevice(%q) = %+v, want %+v", tt.value, *got, tt.want)
+		}
+	}
+}
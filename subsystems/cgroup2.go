@@ -0,0 +1,87 @@
+package subsystems
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const cgroup2SuperMagic = 0x63677270
+
+var (
+	unifiedMountpoint = "/sys/fs/cgroup"
+	isUnifiedOnce     sync.Once
+	isUnified         bool
+	// v1的子系统在v2中对应的控制器，freezer和devices在v2中没有对应的控制器
+	cgroup2Controllers = map[string]string{
+		"memory":  "memory",
+		"cpu":     "cpu",
+		"cpuacct": "cpu",
+		"cpuset":  "cpuset",
+		"pids":    "pids",
+		"blkio":   "io",
+	}
+)
+
+// /sys/fs/cgroup挂载的是cgroup2时认为是v2的统一层级
+func IsCgroup2() bool {
+	isUnifiedOnce.Do(func() {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(unifiedMountpoint, &st); err == nil {
+			isUnified = st.Type == cgroup2SuperMagic
+		}
+	})
+	return isUnified
+}
+
+// v2中所有控制器共用一个目录，需要在各级父cgroup中开启对应的控制器
+// 目录可能已经由前面的子系统创建，所以每次都检查父cgroup中是否开启了当前子系统的控制器
+func getCgroup2Path(subsystem, cgroupRoot string, autoCreate bool) (string, error) {
+	fullPath := path.Join(unifiedMountpoint, cgroupRoot)
+	if !autoCreate {
+		_, err := os.Stat(fullPath)
+		return fullPath, err
+	}
+	if err := os.MkdirAll(fullPath, 0755); err != nil {
+		return "", err
+	}
+	controller := cgroup2Controllers[subsystem]
+	if controller == "" {
+		return fullPath, nil
+	}
+	current := unifiedMountpoint
+	for _, elem := range strings.Split(path.Clean(cgroupRoot), "/") {
+		if elem == "" {
+			continue
+		}
+		if err := enableController(current, controller); err != nil {
+			return "", err
+		}
+		current = path.Join(current, elem)
+	}
+	return fullPath, nil
+}
+
+func enableController(dir, controller string) error {
+	if controllerEnabled(dir, controller) {
+		return nil
+	}
+	return ioutil.WriteFile(path.Join(dir, "cgroup.subtree_control"), []byte("+"+controller), 0644)
+}
+
+// cgroup的子cgroup中是否开启了控制器
+func controllerEnabled(dir, controller string) bool {
+	b, err := ioutil.ReadFile(path.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return false
+	}
+	for _, enabled := range strings.Fields(string(b)) {
+		if enabled == controller {
+			return true
+		}
+	}
+	return false
+}
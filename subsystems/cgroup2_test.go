@@ -0,0 +1,49 @@
+package subsystems
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// 用普通目录模拟cgroup2，cgroup.subtree_control中记录最后一次写入的内容
+func TestGetCgroup2PathEnablesEachController(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	saved := unifiedMountpoint
+	unifiedMountpoint = root
+	defer func() { unifiedMountpoint = saved }()
+	for _, dir := range []string{root, path.Join(root, "mydocker")} {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path.Join(dir, "cgroup.subtree_control"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, subsystem := range []string{"memory", "cpu", "pids", "blkio"} {
+		if _, err = getCgroup2Path(subsystem, "mydocker/c1", true); err != nil {
+			t.Fatalf("%s: %v", subsystem, err)
+		}
+		for _, dir := range []string{root, path.Join(root, "mydocker")} {
+			b, err := ioutil.ReadFile(path.Join(dir, "cgroup.subtree_control"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "+" + cgroup2Controllers[subsystem]; string(b) != want {
+				t.Errorf("%s: %s/cgroup.subtree_control = %q, want %q", subsystem, dir, b, want)
+			}
+		}
+	}
+	// 没有对应控制器的子系统只需要目录存在
+	if _, err = getCgroup2Path("freezer", "mydocker/c1", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = getCgroup2Path("memory", "mydocker/c2", false); !os.IsNotExist(err) {
+		t.Errorf("autoCreate=false on a missing cgroup: got %v, want not exist", err)
+	}
+}
@@ -0,0 +1,132 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 设备访问规则，类型为a表示所有设备，设备号为-1表示通配
+type DeviceRule struct {
+	Type        string `json:"type"`
+	Major       int64  `json:"major"`
+	Minor       int64  `json:"minor"`
+	Permissions string `json:"permissions"`
+}
+
+func (r *DeviceRule) String() string {
+	if r.Type == "a" {
+		return "a"
+	}
+	return fmt.Sprintf("%s %s:%s %s", r.Type, deviceNumber(r.Major), deviceNumber(r.Minor), r.Permissions)
+}
+
+func deviceNumber(n int64) string {
+	if n == -1 {
+		return "*"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// 默认允许创建任意设备节点，读写标准的/dev设备
+var DefaultDeviceRules = []*DeviceRule{
+	{Type: "c", Major: -1, Minor: -1, Permissions: "m"},
+	{Type: "b", Major: -1, Minor: -1, Permissions: "m"},
+	// /dev/null
+	{Type: "c", Major: 1, Minor: 3, Permissions: "rwm"},
+	// /dev/zero
+	{Type: "c", Major: 1, Minor: 5, Permissions: "rwm"},
+	// /dev/full
+	{Type: "c", Major: 1, Minor: 7, Permissions: "rwm"},
+	// /dev/random
+	{Type: "c", Major: 1, Minor: 8, Permissions: "rwm"},
+	// /dev/urandom
+	{Type: "c", Major: 1, Minor: 9, Permissions: "rwm"},
+	// /dev/tty
+	{Type: "c", Major: 5, Minor: 0, Permissions: "rwm"},
+	// /dev/console
+	{Type: "c", Major: 5, Minor: 1, Permissions: "rwm"},
+	// /dev/ptmx
+	{Type: "c", Major: 5, Minor: 2, Permissions: "rwm"},
+	// /dev/pts/*
+	{Type: "c", Major: 136, Minor: -1, Permissions: "rwm"},
+	// /dev/net/tun
+	{Type: "c", Major: 10, Minor: 200, Permissions: "rwm"},
+}
+
+type DevicesSubsystem struct {
+}
+
+func (s *DevicesSubsystem) Name() string {
+	return "devices"
+}
+
+// 先拒绝所有设备，再逐条写入默认规则和--device指定的设备
+func (s *DevicesSubsystem) Set(cpath string, config *ResourceConfig) error {
+	rules := append(append([]*DeviceRule{}, DefaultDeviceRules...), config.Devices...)
+	cpath, err := GetCgroupPathInfo(s.Name(), cpath, true)
+	if err != nil {
+		return err
+	}
+	if IsCgroup2() {
+		return attachDeviceFilter(cpath, rules)
+	}
+	if err := ioutil.WriteFile(path.Join(cpath, "devices.deny"), []byte("a"), 0644); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if err := ioutil.WriteFile(path.Join(cpath, "devices.allow"), []byte(rule.String()), 0644); err != nil {
+			return fmt.Errorf("allow device %s:%v", rule, err)
+		}
+	}
+	return nil
+}
+
+func (s *DevicesSubsystem) Apply(cpath string, pid int) error {
+	// v2中设备控制通过eBPF程序实现，进程加入其它控制器所在的cgroup即可
+	return applyPid(s.Name(), cpath, pid)
+}
+
+func (s *DevicesSubsystem) Remove(cpath string) error {
+	return removeCgroup(s.Name(), cpath)
+}
+
+func ValidateDevicePermissions(permissions string) error {
+	if permissions == "" {
+		return fmt.Errorf("empty device permissions")
+	}
+	for _, c := range permissions {
+		if !strings.ContainsRune("rwm", c) {
+			return fmt.Errorf("invalid device permissions %q, only r, w and m are allowed", permissions)
+		}
+	}
+	return nil
+}
+
+// 解析主机上的设备文件，返回设备类型(c或b)、设备号和文件权限
+func StatDevice(devicePath string) (deviceType string, major, minor int64, fileMode uint32, err error) {
+	var st syscall.Stat_t
+	if err = syscall.Stat(devicePath, &st); err != nil {
+		return "", 0, 0, 0, fmt.Errorf("stat %s:%v", devicePath, err)
+	}
+	major, minor = splitDeviceNumber(uint64(st.Rdev))
+	switch st.Mode & syscall.S_IFMT {
+	case syscall.S_IFCHR:
+		deviceType = "c"
+	case syscall.S_IFBLK:
+		deviceType = "b"
+	default:
+		return "", 0, 0, 0, fmt.Errorf("%s is not a device", devicePath)
+	}
+	return deviceType, major, minor, st.Mode &^ syscall.S_IFMT, nil
+}
+
+// 按照glibc的编码从dev_t中取出主次设备号
+func splitDeviceNumber(rdev uint64) (major, minor int64) {
+	major = int64((rdev>>8)&0xfff | (rdev>>32)&^0xfff)
+	minor = int64(rdev&0xff | (rdev>>12)&^0xff)
+	return major, minor
+}
@@ -0,0 +1,196 @@
+package subsystems
+
+import (
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"runtime"
+	"strings"
+	"unsafe"
+)
+
+// cgroup v2没有devices.allow，需要挂载一个BPF_PROG_TYPE_CGROUP_DEVICE程序
+const (
+	bpfProgLoad   = 5
+	bpfProgAttach = 8
+
+	bpfProgTypeCgroupDevice = 15
+	bpfCgroupDevice         = 6
+
+	// bpf_cgroup_dev_ctx中的设备类型和访问类型
+	bpfDevcgDevBlock  = 1
+	bpfDevcgDevChar   = 2
+	bpfDevcgAccMknod  = 1
+	bpfDevcgAccRead   = 2
+	bpfDevcgAccWrite  = 4
+	bpfDevcgAccAll    = bpfDevcgAccMknod | bpfDevcgAccRead | bpfDevcgAccWrite
+	bpfVerifierLogLen = 64 * 1024
+)
+
+// 用到的eBPF指令
+const (
+	bpfLdxW     = 0x61
+	bpfMovReg   = 0xbf
+	bpfMovImm   = 0xb7
+	bpfAndImm   = 0x57
+	bpfRshImm   = 0x77
+	bpfJneImm   = 0x55
+	bpfExitInsn = 0x95
+)
+
+type bpfInsn struct {
+	code uint8
+	dst  uint8
+	src  uint8
+	off  int16
+	imm  int32
+}
+
+// 程序的上下文是bpf_cgroup_dev_ctx{access_type, major, minor}，
+// access_type的低16位是设备类型，高16位是访问类型
+func buildDeviceFilter(rules []*DeviceRule) ([]bpfInsn, error) {
+	insns := []bpfInsn{
+		{code: bpfLdxW, dst: 2, src: 1, off: 0},
+		{code: bpfMovReg, dst: 3, src: 2},
+		{code: bpfAndImm, dst: 2, imm: 0xffff},
+		{code: bpfRshImm, dst: 3, imm: 16},
+		{code: bpfLdxW, dst: 4, src: 1, off: 4},
+		{code: bpfLdxW, dst: 5, src: 1, off: 8},
+	}
+	for _, rule := range rules {
+		var block []bpfInsn
+		switch rule.Type {
+		case "a":
+		case "c":
+			block = append(block, bpfInsn{code: bpfJneImm, dst: 2, imm: bpfDevcgDevChar})
+		case "b":
+			block = append(block, bpfInsn{code: bpfJneImm, dst: 2, imm: bpfDevcgDevBlock})
+		default:
+			return nil, fmt.Errorf("invalid device type %q", rule.Type)
+		}
+		if rule.Major != -1 {
+			block = append(block, bpfInsn{code: bpfJneImm, dst: 4, imm: int32(rule.Major)})
+		}
+		if rule.Minor != -1 {
+			block = append(block, bpfInsn{code: bpfJneImm, dst: 5, imm: int32(rule.Minor)})
+		}
+		access := accessMask(rule.Permissions)
+		if rule.Type == "a" {
+			access = bpfDevcgAccAll
+		}
+		if access != bpfDevcgAccAll {
+			// 请求的访问类型必须是规则允许的子集
+			block = append(block,
+				bpfInsn{code: bpfMovReg, dst: 1, src: 3},
+				bpfInsn{code: bpfAndImm, dst: 1, imm: int32(bpfDevcgAccAll &^ access)},
+				bpfInsn{code: bpfJneImm, dst: 1, imm: 0},
+			)
+		}
+		block = append(block,
+			bpfInsn{code: bpfMovImm, dst: 0, imm: 1},
+			bpfInsn{code: bpfExitInsn},
+		)
+		// 条件不满足时跳过这条规则剩下的指令
+		for i := range block {
+			if block[i].code == bpfJneImm {
+				block[i].off = int16(len(block) - i - 1)
+			}
+		}
+		insns = append(insns, block...)
+	}
+	return append(insns,
+		bpfInsn{code: bpfMovImm, dst: 0, imm: 0},
+		bpfInsn{code: bpfExitInsn},
+	), nil
+}
+
+func accessMask(permissions string) int {
+	access := 0
+	if strings.Contains(permissions, "m") {
+		access |= bpfDevcgAccMknod
+	}
+	if strings.Contains(permissions, "r") {
+		access |= bpfDevcgAccRead
+	}
+	if strings.Contains(permissions, "w") {
+		access |= bpfDevcgAccWrite
+	}
+	return access
+}
+
+func encodeInsns(insns []bpfInsn) []byte {
+	b := make([]byte, 8*len(insns))
+	for i, insn := range insns {
+		b[8*i] = insn.code
+		b[8*i+1] = insn.src<<4 | insn.dst&0xf
+		binary.LittleEndian.PutUint16(b[8*i+2:], uint16(insn.off))
+		binary.LittleEndian.PutUint32(b[8*i+4:], uint32(insn.imm))
+	}
+	return b
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// 加载设备过滤程序并挂载到cgroup目录上，已有的程序会被替换
+func attachDeviceFilter(cgroupDir string, rules []*DeviceRule) error {
+	insns, err := buildDeviceFilter(rules)
+	if err != nil {
+		return err
+	}
+	code := encodeInsns(insns)
+	license := []byte("GPL\x00")
+	logBuf := make([]byte, bpfVerifierLogLen)
+	loadAttr := struct {
+		progType    uint32
+		insnCnt     uint32
+		insns       uint64
+		license     uint64
+		logLevel    uint32
+		logSize     uint32
+		logBuf      uint64
+		kernVersion uint32
+		progFlags   uint32
+	}{
+		progType: bpfProgTypeCgroupDevice,
+		insnCnt:  uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&code[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(logBuf)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&logBuf[0]))),
+	}
+	progFd, err := bpf(bpfProgLoad, unsafe.Pointer(&loadAttr), unsafe.Sizeof(loadAttr))
+	runtime.KeepAlive(code)
+	runtime.KeepAlive(license)
+	runtime.KeepAlive(logBuf)
+	if err != nil {
+		return fmt.Errorf("load device filter:%v: %s", err, strings.TrimRight(string(logBuf), "\x00"))
+	}
+	defer unix.Close(progFd)
+
+	dirFd, err := unix.Open(cgroupDir, unix.O_DIRECTORY|unix.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open %s:%v", cgroupDir, err)
+	}
+	defer unix.Close(dirFd)
+	attachAttr := struct {
+		targetFd    uint32
+		attachBpfFd uint32
+		attachType  uint32
+		attachFlags uint32
+	}{
+		targetFd:    uint32(dirFd),
+		attachBpfFd: uint32(progFd),
+		attachType:  bpfCgroupDevice,
+	}
+	if _, err = bpf(bpfProgAttach, unsafe.Pointer(&attachAttr), unsafe.Sizeof(attachAttr)); err != nil {
+		return fmt.Errorf("attach device filter to %s:%v", cgroupDir, err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package subsystems
+
+import (
+	"testing"
+)
+
+// 执行buildDeviceFilter生成的指令，只支持用到的几种指令
+func runDeviceFilter(t *testing.T, insns []bpfInsn, devType, access, major, minor uint32) bool {
+	var regs [11]uint64
+	ctx := []uint32{access<<16 | devType, major, minor}
+	for pc := 0; pc < len(insns); pc++ {
+		insn := insns[pc]
+		switch insn.code {
+		case bpfLdxW:
+			regs[insn.dst] = uint64(ctx[insn.off/4])
+		case bpfMovReg:
+			regs[insn.dst] = regs[insn.src]
+		case bpfMovImm:
+			regs[insn.dst] = uint64(insn.imm)
+		case bpfAndImm:
+			regs[insn.dst] &= uint64(insn.imm)
+		case bpfRshImm:
+			regs[insn.dst] >>= uint(insn.imm)
+		case bpfJneImm:
+			if regs[insn.dst] != uint64(insn.imm) {
+				pc += int(insn.off)
+			}
+		case bpfExitInsn:
+			return regs[0] == 1
+		default:
+			t.Fatalf("unexpected instruction %#x", insn.code)
+		}
+	}
+	t.Fatal("program did not exit")
+	return false
+}
+
+func TestBuildDeviceFilter(t *testing.T) {
+	rules := append(append([]*DeviceRule{}, DefaultDeviceRules...),
+		&DeviceRule{Type: "b", Major: 8, Minor: 0, Permissions: "r"})
+	insns, err := buildDeviceFilter(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const (
+		c = bpfDevcgDevChar
+		b = bpfDevcgDevBlock
+		m = bpfDevcgAccMknod
+		r = bpfDevcgAccRead
+		w = bpfDevcgAccWrite
+	)
+	tests := []struct {
+		name         string
+		devType      uint32
+		access       uint32
+		major, minor uint32
+		want         bool
+	}{
+		{"mknod any char device", c, m, 4, 1, true},
+		{"mknod any block device", b, m, 8, 1, true},
+		{"read /dev/null", c, r | w, 1, 3, true},
+		{"read a pts", c, r, 136, 7, true},
+		{"read a tty", c, r, 4, 1, false},
+		{"read an allowed block device", b, r, 8, 0, true},
+		{"write a read-only block device", b, w, 8, 0, false},
+		{"read and write a read-only block device", b, r | w, 8, 0, false},
+		{"read a block device with another minor", b, r, 8, 1, false},
+		{"char rule does not match block", b, r, 1, 3, false},
+	}
+	for _, tt := range tests {
+		if got := runDeviceFilter(t, insns, tt.devType, tt.access, tt.major, tt.minor); got != tt.want {
+			t.Errorf("%s: allowed = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	// 允许所有设备的规则
+	insns, err = buildDeviceFilter([]*DeviceRule{{Type: "a", Major: -1, Minor: -1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !runDeviceFilter(t, insns, b, r|w|m, 259, 3) {
+		t.Error("type a rule should allow every device")
+	}
+	if _, err = buildDeviceFilter([]*DeviceRule{{Type: "x", Major: -1, Minor: -1}}); err == nil {
+		t.Error("invalid device type accepted")
+	}
+}
@@ -0,0 +1,107 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// 等待cgroup冻结或者解冻的最长时间
+	freezeTimeout = 10 * time.Second
+	freezePoll    = 10 * time.Millisecond
+)
+
+type FreezerSubsystem struct {
+}
+
+func (s *FreezerSubsystem) Name() string {
+	return "freezer"
+}
+
+func (s *FreezerSubsystem) Set(cpath string, config *ResourceConfig) error {
+	return nil
+}
+
+func (s *FreezerSubsystem) Apply(cpath string, pid int) error {
+	return applyPid(s.Name(), cpath, pid)
+}
+
+func (s *FreezerSubsystem) Remove(cpath string) error {
+	return removeCgroup(s.Name(), cpath)
+}
+
+// 冻结cgroup中的所有进程，等到真正冻结后返回，超时会恢复原状
+func Freeze(cpath string) error {
+	if err := setFreezerState(cpath, true); err != nil {
+		setFreezerState(cpath, false)
+		return err
+	}
+	return nil
+}
+
+// 解冻cgroup中的所有进程，等到真正解冻后返回
+func Thaw(cpath string) error {
+	return setFreezerState(cpath, false)
+}
+
+// v1写freezer.state，FREEZING表示还没有冻结完成；v2写cgroup.freeze，从cgroup.events读取状态
+func setFreezerState(cpath string, frozen bool) error {
+	if !subsystemAvailable("freezer") {
+		return fmt.Errorf("freezer cgroup controller not available on this host")
+	}
+	cpath, err := GetCgroupPathInfo("freezer", cpath, false)
+	if err != nil {
+		return err
+	}
+	file, value, want := "freezer.state", "THAWED", "THAWED"
+	if frozen {
+		value, want = "FROZEN", "FROZEN"
+	}
+	if IsCgroup2() {
+		file, value, want = "cgroup.freeze", "0", "frozen 0"
+		if frozen {
+			value, want = "1", "frozen 1"
+		}
+	}
+	deadline := time.Now().Add(freezeTimeout)
+	for {
+		// v1中有进程卡在不可中断的状态时内核会放弃冻结，需要重新写入
+		if err = ioutil.WriteFile(path.Join(cpath, file), []byte(value), 0644); err != nil {
+			return err
+		}
+		state, err := readFreezerState(cpath)
+		if err != nil {
+			return err
+		}
+		if state == want {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for cgroup %s to become %s, current state %s", cpath, want, state)
+		}
+		time.Sleep(freezePoll)
+	}
+}
+
+func readFreezerState(cpath string) (string, error) {
+	if !IsCgroup2() {
+		b, err := ioutil.ReadFile(path.Join(cpath, "freezer.state"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	b, err := ioutil.ReadFile(path.Join(cpath, "cgroup.events"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "frozen ") {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("no frozen state in %s", path.Join(cpath, "cgroup.events"))
+}
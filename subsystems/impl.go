@@ -1,10 +1,12 @@
 package subsystems
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"strconv"
+	"strings"
 )
 
 type MemorySubsystem struct {
@@ -15,32 +17,121 @@ func (s *MemorySubsystem) Name() string {
 }
 
 func (s *MemorySubsystem) Set(cpath string, config *ResourceConfig) error {
-	if config.MemoryLimit != "" {
-		cpath, err := GetCgroupPathInfo(s.Name(), cpath, true)
+	if config.Memory == 0 && config.MemorySwap == 0 && config.MemoryReservation == 0 &&
+		config.MemorySwappiness == nil && config.KernelMemory == 0 && !config.OomKillDisable {
+		return nil
+	}
+	cpath, err := GetCgroupPathInfo(s.Name(), cpath, true)
+	if err != nil {
+		return err
+	}
+	if IsCgroup2() {
+		return s.setCgroup2(cpath, config)
+	}
+	// 内核要求limit_in_bytes不能大于memsw.limit_in_bytes，所以扩大swap限制时要先写memsw
+	var files []memoryFile
+	limit := memoryFile{"memory.limit_in_bytes", config.Memory}
+	memsw := memoryFile{"memory.memsw.limit_in_bytes", config.MemorySwap}
+	if config.MemorySwap != 0 {
+		current, err := readCgroupInt(path.Join(cpath, memsw.name))
 		if err != nil {
+			return fmt.Errorf("the kernel does not support swap accounting:%v", err)
+		}
+		if config.MemorySwap == -1 || config.MemorySwap > current {
+			files = append(files, memsw, limit)
+		} else {
+			files = append(files, limit, memsw)
+		}
+	} else {
+		files = append(files, limit)
+	}
+	files = append(files,
+		memoryFile{"memory.soft_limit_in_bytes", config.MemoryReservation},
+		memoryFile{"memory.kmem.limit_in_bytes", config.KernelMemory},
+	)
+	for _, file := range files {
+		if file.value == 0 {
+			continue
+		}
+		if err := ioutil.WriteFile(path.Join(cpath, file.name), []byte(strconv.FormatInt(file.value, 10)), 0644); err != nil {
+			return fmt.Errorf("write %s:%v", file.name, err)
+		}
+	}
+	if config.MemorySwappiness != nil {
+		if err := ioutil.WriteFile(path.Join(cpath, "memory.swappiness"), []byte(strconv.FormatUint(*config.MemorySwappiness, 10)), 0644); err != nil {
 			return err
 		}
-		if err := ioutil.WriteFile(path.Join(cpath, "memory.limit_in_bytes"), []byte(config.MemoryLimit), 0644); err != nil {
+	}
+	if config.OomKillDisable {
+		if err := ioutil.WriteFile(path.Join(cpath, "memory.oom_control"), []byte("1"), 0644); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (s *MemorySubsystem) Apply(cpath string, pid int) error {
-	cpath, err := GetCgroupPathInfo(s.Name(), cpath, false)
+type memoryFile struct {
+	name  string
+	value int64
+}
+
+// v2中memory.swap.max只限制swap，memory.low作为软限制
+func (s *MemorySubsystem) setCgroup2(cpath string, config *ResourceConfig) error {
+	if config.KernelMemory != 0 || config.MemorySwappiness != nil || config.OomKillDisable {
+		return fmt.Errorf("kernel memory, swappiness and oom kill disable are not supported on cgroup v2")
+	}
+	if config.Memory != 0 {
+		if err := ioutil.WriteFile(path.Join(cpath, "memory.max"), []byte(strconv.FormatInt(config.Memory, 10)), 0644); err != nil {
+			return err
+		}
+	}
+	if config.MemorySwap != 0 {
+		swap := "max"
+		if config.MemorySwap > 0 {
+			swap = strconv.FormatInt(config.MemorySwap-config.Memory, 10)
+		}
+		if err := ioutil.WriteFile(path.Join(cpath, "memory.swap.max"), []byte(swap), 0644); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("the kernel does not support swap accounting")
+			}
+			return err
+		}
+	}
+	if config.MemoryReservation != 0 {
+		if err := ioutil.WriteFile(path.Join(cpath, "memory.low"), []byte(strconv.FormatInt(config.MemoryReservation, 10)), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readCgroupInt(file string) (int64, error) {
+	b, err := ioutil.ReadFile(file)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return ioutil.WriteFile(path.Join(cpath, "tasks"), []byte(strconv.Itoa(pid)), 0644);
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
 }
 
-func (s *MemorySubsystem) Remove(cpath string) error {
-	cpath, err := GetCgroupPathInfo(s.Name(), cpath, false)
+// 读取cgroup当前的内存使用量，v1读memory.usage_in_bytes，v2读memory.current
+func GetMemoryUsage(cpath string) (int64, error) {
+	cpath, err := GetCgroupPathInfo("memory", cpath, false)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return os.RemoveAll(cpath)
+	file := "memory.usage_in_bytes"
+	if IsCgroup2() {
+		file = "memory.current"
+	}
+	return readCgroupInt(path.Join(cpath, file))
+}
+
+func (s *MemorySubsystem) Apply(cpath string, pid int) error {
+	return applyPid(s.Name(), cpath, pid)
+}
+
+func (s *MemorySubsystem) Remove(cpath string) error {
+	return removeCgroup(s.Name(), cpath)
 }
 
 type CpuSubsystem struct {
@@ -51,32 +142,90 @@ func (s *CpuSubsystem) Name() string {
 }
 
 func (s *CpuSubsystem) Set(cpath string, config *ResourceConfig) error {
-	if config.CpuShare != "" {
-		cpath, err := GetCgroupPathInfo(s.Name(), cpath, true)
-		if err != nil {
+	if config.CpuShares == 0 && config.CpuQuota == 0 && config.CpuPeriod == 0 {
+		return nil
+	}
+	cpath, err := GetCgroupPathInfo(s.Name(), cpath, true)
+	if err != nil {
+		return err
+	}
+	if IsCgroup2() {
+		return s.setCgroup2(cpath, config)
+	}
+	if config.CpuShares != 0 {
+		if err := ioutil.WriteFile(path.Join(cpath, "cpu.shares"), []byte(strconv.FormatUint(config.CpuShares, 10)), 0644); err != nil {
+			return err
+		}
+	}
+	// 先写周期再写配额，配额是相对周期而言的
+	if config.CpuPeriod != 0 {
+		if err := ioutil.WriteFile(path.Join(cpath, "cpu.cfs_period_us"), []byte(strconv.FormatUint(config.CpuPeriod, 10)), 0644); err != nil {
 			return err
 		}
-		if err := ioutil.WriteFile(path.Join(cpath, "cpu.shares"), []byte(config.MemoryLimit), 0644); err != nil {
+	}
+	if config.CpuQuota != 0 {
+		if err := ioutil.WriteFile(path.Join(cpath, "cpu.cfs_quota_us"), []byte(strconv.FormatInt(config.CpuQuota, 10)), 0644); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (s *CpuSubsystem) Apply(cpath string, pid int) error {
-	cpath, err := GetCgroupPathInfo(s.Name(), cpath, false)
-	if err != nil {
-		return err
+// v2中用cpu.weight代替cpu.shares，cpu.max同时写入配额和周期
+func (s *CpuSubsystem) setCgroup2(cpath string, config *ResourceConfig) error {
+	if config.CpuShares != 0 {
+		weight := CpuSharesToWeight(config.CpuShares)
+		if err := ioutil.WriteFile(path.Join(cpath, "cpu.weight"), []byte(strconv.FormatUint(weight, 10)), 0644); err != nil {
+			return err
+		}
 	}
-	return ioutil.WriteFile(path.Join(cpath, "tasks"), []byte(strconv.Itoa(pid)), 0644);
+	if config.CpuQuota != 0 || config.CpuPeriod != 0 {
+		quota := "max"
+		if config.CpuQuota > 0 {
+			quota = strconv.FormatInt(config.CpuQuota, 10)
+		}
+		period := config.CpuPeriod
+		if period == 0 {
+			period = DefaultCpuPeriod
+		}
+		if err := ioutil.WriteFile(path.Join(cpath, "cpu.max"), []byte(fmt.Sprintf("%s %d", quota, period)), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 把cpu.shares的[2, 262144]映射到cpu.weight的[1, 10000]
+func CpuSharesToWeight(shares uint64) uint64 {
+	return 1 + ((shares-MinCpuShares)*9999)/(MaxCpuShares-MinCpuShares)
+}
+
+func (s *CpuSubsystem) Apply(cpath string, pid int) error {
+	return applyPid(s.Name(), cpath, pid)
 }
 
 func (s *CpuSubsystem) Remove(cpath string) error {
-	cpath, err := GetCgroupPathInfo(s.Name(), cpath, false)
-	if err != nil {
-		return err
-	}
-	return os.RemoveAll(cpath)
+	return removeCgroup(s.Name(), cpath)
+}
+
+// cpuacct只用于统计CPU使用时间，cpu和cpuacct可能挂载在不同的层级，需要单独加入进程
+type CpuacctSubsystem struct {
+}
+
+func (s *CpuacctSubsystem) Name() string {
+	return "cpuacct"
+}
+
+func (s *CpuacctSubsystem) Set(cpath string, config *ResourceConfig) error {
+	return nil
+}
+
+func (s *CpuacctSubsystem) Apply(cpath string, pid int) error {
+	return applyPid(s.Name(), cpath, pid)
+}
+
+func (s *CpuacctSubsystem) Remove(cpath string) error {
+	return removeCgroup(s.Name(), cpath)
 }
 
 type CpuSetSubsystem struct {
@@ -87,12 +236,16 @@ func (s *CpuSetSubsystem) Name() string {
 }
 
 func (s *CpuSetSubsystem) Set(cpath string, config *ResourceConfig) error {
-	if config.CpuSet != "" {
+	if config.CpusetCpus != "" {
 		cpath, err := GetCgroupPathInfo(s.Name(), cpath, true)
 		if err != nil {
 			return err
 		}
-		if err := ioutil.WriteFile(path.Join(cpath, "cpuset.cpus"), []byte(config.MemoryLimit), 0644); err != nil {
+		// 父cgroup的cpus为空时无法写入子cgroup
+		if err := initCpuset(cpath); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(cpath, "cpuset.cpus"), []byte(config.CpusetCpus), 0644); err != nil {
 			return err
 		}
 	}
@@ -100,17 +253,44 @@ func (s *CpuSetSubsystem) Set(cpath string, config *ResourceConfig) error {
 }
 
 func (s *CpuSetSubsystem) Apply(cpath string, pid int) error {
-	cpath, err := GetCgroupPathInfo(s.Name(), cpath, false)
+	fullPath, err := GetCgroupPathInfo(s.Name(), cpath, true)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(path.Join(cpath, "tasks"), []byte(strconv.Itoa(pid)), 0644);
+	// cpus和mems为空的cpuset不能加入进程
+	if err := initCpuset(fullPath); err != nil {
+		return err
+	}
+	return applyPid(s.Name(), cpath, pid)
 }
 
-func (s *CpuSetSubsystem) Remove(cpath string) error {
-	cpath, err := GetCgroupPathInfo(s.Name(), cpath, false)
-	if err != nil {
-		return err
+// v1中新建的cpuset中cpus和mems为空，从父cgroup继承；v2中为空表示继承父cgroup
+func initCpuset(cpath string) error {
+	if IsCgroup2() {
+		return nil
 	}
-	return os.RemoveAll(cpath)
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+		b, err := ioutil.ReadFile(path.Join(cpath, file))
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(b)) != "" {
+			continue
+		}
+		parent := path.Dir(cpath)
+		if err = initCpuset(parent); err != nil {
+			return err
+		}
+		if b, err = ioutil.ReadFile(path.Join(parent, file)); err != nil {
+			return err
+		}
+		if err = ioutil.WriteFile(path.Join(cpath, file), b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *CpuSetSubsystem) Remove(cpath string) error {
+	return removeCgroup(s.Name(), cpath)
 }
@@ -0,0 +1,201 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// 用普通目录模拟v1，每个子系统挂载在root/<子系统>，mountinfo中记录挂载点
+func fakeCgroup1Root(t *testing.T) (string, func()) {
+	root, err := ioutil.TempDir("", "cgroup1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mountinfo []string
+	for i, subsystem := range []string{"memory", "cpu", "cpuacct", "cpuset", "pids", "blkio"} {
+		if err = os.Mkdir(path.Join(root, subsystem), 0755); err != nil {
+			t.Fatal(err)
+		}
+		mountinfo = append(mountinfo, fmt.Sprintf("%d 25 0:%d / %s rw,nosuid - cgroup cgroup rw,%s",
+			30+i, 30+i, path.Join(root, subsystem), subsystem))
+	}
+	if err = ioutil.WriteFile(path.Join(root, "mountinfo"), []byte(strings.Join(mountinfo, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	IsCgroup2()
+	savedMountinfo, savedUnified := mountinfoPath, isUnified
+	mountinfoPath, isUnified = path.Join(root, "mountinfo"), false
+	return root, func() {
+		mountinfoPath, isUnified = savedMountinfo, savedUnified
+		os.RemoveAll(root)
+	}
+}
+
+// 用普通目录模拟v2，根cgroup和mydocker中已经开启了所有控制器
+func fakeCgroup2Root(t *testing.T) (string, func()) {
+	root, err := ioutil.TempDir("", "cgroup2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	controllers := "cpuset cpu io memory pids"
+	writeFiles(t, root, map[string]string{
+		"cgroup.subtree_control":          controllers,
+		"mydocker/cgroup.subtree_control": controllers,
+	})
+	IsCgroup2()
+	savedMountpoint, savedUnified := unifiedMountpoint, isUnified
+	unifiedMountpoint, isUnified = root, true
+	return root, func() {
+		unifiedMountpoint, isUnified = savedMountpoint, savedUnified
+		os.RemoveAll(root)
+	}
+}
+
+// 创建files中的文件，用于模拟cgroup中已有的文件
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.MkdirAll(path.Dir(path.Join(root, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkFiles(t *testing.T, root string, want map[string]string) {
+	t.Helper()
+	for name, content := range want {
+		b, err := ioutil.ReadFile(path.Join(root, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if string(b) != content {
+			t.Errorf("%s = %q, want %q", name, b, content)
+		}
+	}
+}
+
+func TestSetCgroup1(t *testing.T) {
+	root, cleanup := fakeCgroup1Root(t)
+	defer cleanup()
+	// 新建的cpuset中cpus和mems为空
+	writeFiles(t, root, map[string]string{
+		"cpuset/cpuset.cpus":             "0-3\n",
+		"cpuset/cpuset.mems":             "0\n",
+		"cpuset/mydocker/cpuset.cpus":    "",
+		"cpuset/mydocker/cpuset.mems":    "",
+		"cpuset/mydocker/c1/cpuset.cpus": "",
+		"cpuset/mydocker/c1/cpuset.mems": "",
+	})
+	config := &ResourceConfig{Memory: 64 << 20, CpuShares: 512, CpusetCpus: "1-2"}
+	for _, subsystem := range []SubSystem{&MemorySubsystem{}, &CpuSubsystem{}, &CpuSetSubsystem{}} {
+		if err := subsystem.Set("mydocker/c1", config); err != nil {
+			t.Fatalf("%s: %v", subsystem.Name(), err)
+		}
+	}
+	checkFiles(t, root, map[string]string{
+		"memory/mydocker/c1/memory.limit_in_bytes": "67108864",
+		"cpu/mydocker/c1/cpu.shares":               "512",
+		"cpuset/mydocker/cpuset.cpus":              "0-3\n",
+		"cpuset/mydocker/cpuset.mems":              "0\n",
+		"cpuset/mydocker/c1/cpuset.cpus":           "1-2",
+		"cpuset/mydocker/c1/cpuset.mems":           "0\n",
+	})
+	// 没有设置的限制不写文件
+	if _, err := os.Stat(path.Join(root, "cpu/mydocker/c1/cpu.cfs_quota_us")); !os.IsNotExist(err) {
+		t.Errorf("cpu.cfs_quota_us written without a quota: %v", err)
+	}
+}
+
+func TestSetCpuQuota(t *testing.T) {
+	config := &ResourceConfig{CpuShares: 1024, CpuPeriod: 50000, CpuQuota: 25000}
+	root, cleanup := fakeCgroup1Root(t)
+	if err := (&CpuSubsystem{}).Set("mydocker/c1", config); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, root, map[string]string{
+		"cpu/mydocker/c1/cpu.shares":        "1024",
+		"cpu/mydocker/c1/cpu.cfs_period_us": "50000",
+		"cpu/mydocker/c1/cpu.cfs_quota_us":  "25000",
+	})
+	cleanup()
+
+	// v2中cpu.max同时写入配额和周期，没有配额时为max
+	root, cleanup = fakeCgroup2Root(t)
+	defer cleanup()
+	if err := (&CpuSubsystem{}).Set("mydocker/c1", config); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&CpuSubsystem{}).Set("mydocker/c2", &ResourceConfig{CpuQuota: -1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&MemorySubsystem{}).Set("mydocker/c1", &ResourceConfig{Memory: 64 << 20}); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, root, map[string]string{
+		"mydocker/c1/cpu.weight": "39",
+		"mydocker/c1/cpu.max":    "25000 50000",
+		"mydocker/c2/cpu.max":    "max 100000",
+		"mydocker/c1/memory.max": "67108864",
+	})
+}
+
+func TestMemorySet(t *testing.T) {
+	memory := &MemorySubsystem{}
+	swappiness := uint64(10)
+	config := &ResourceConfig{
+		Memory:            64 << 20,
+		MemorySwap:        128 << 20,
+		MemoryReservation: 32 << 20,
+		MemorySwappiness:  &swappiness,
+		OomKillDisable:    true,
+	}
+	root, cleanup := fakeCgroup1Root(t)
+	defer cleanup()
+	// 开启了swap记账才有memsw文件
+	writeFiles(t, root, map[string]string{"memory/mydocker/c1/memory.memsw.limit_in_bytes": "9223372036854771712"})
+	if err := memory.Set("mydocker/c1", config); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, root, map[string]string{
+		"memory/mydocker/c1/memory.limit_in_bytes":       "67108864",
+		"memory/mydocker/c1/memory.memsw.limit_in_bytes": "134217728",
+		"memory/mydocker/c1/memory.soft_limit_in_bytes":  "33554432",
+		"memory/mydocker/c1/memory.swappiness":           "10",
+		"memory/mydocker/c1/memory.oom_control":          "1",
+	})
+	if err := memory.Set("mydocker/c2", &ResourceConfig{Memory: 64 << 20, MemorySwap: -1}); err == nil {
+		t.Error("setting memory swap without swap accounting succeeded")
+	}
+
+	// v2中memory.swap.max只限制swap
+	root2, cleanup2 := fakeCgroup2Root(t)
+	defer cleanup2()
+	writeFiles(t, root2, map[string]string{
+		"mydocker/c1/memory.swap.max": "max",
+		"mydocker/c2/memory.swap.max": "0",
+	})
+	config.MemorySwappiness, config.OomKillDisable = nil, false
+	if err := memory.Set("mydocker/c1", config); err != nil {
+		t.Fatal(err)
+	}
+	if err := memory.Set("mydocker/c2", &ResourceConfig{Memory: 64 << 20, MemorySwap: -1}); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, root2, map[string]string{
+		"mydocker/c1/memory.max":      "67108864",
+		"mydocker/c1/memory.swap.max": "67108864",
+		"mydocker/c1/memory.low":      "33554432",
+		"mydocker/c2/memory.swap.max": "max",
+	})
+	if err := memory.Set("mydocker/c3", &ResourceConfig{Memory: 64 << 20, OomKillDisable: true}); err == nil {
+		t.Error("disabling the OOM killer on cgroup v2 succeeded")
+	}
+}
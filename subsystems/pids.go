@@ -0,0 +1,83 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type PidsSubsystem struct {
+}
+
+func (s *PidsSubsystem) Name() string {
+	return "pids"
+}
+
+func (s *PidsSubsystem) Set(cpath string, config *ResourceConfig) error {
+	if config.PidsLimit != 0 {
+		cpath, err := GetCgroupPathInfo(s.Name(), cpath, true)
+		if err != nil {
+			return err
+		}
+		// -1表示不限制
+		limit := "max"
+		if config.PidsLimit > 0 {
+			limit = strconv.FormatInt(config.PidsLimit, 10)
+		}
+		if err := ioutil.WriteFile(path.Join(cpath, "pids.max"), []byte(limit), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PidsSubsystem) Apply(cpath string, pid int) error {
+	return applyPid(s.Name(), cpath, pid)
+}
+
+func (s *PidsSubsystem) Remove(cpath string) error {
+	return removeCgroup(s.Name(), cpath)
+}
+
+func ValidatePidsLimit(limit int64) error {
+	if limit < -1 {
+		return fmt.Errorf("pids limit should be -1 or a positive number")
+	}
+	return nil
+}
+
+// 读取cgroup中当前的进程数
+func GetPidsCurrent(cpath string) (uint64, error) {
+	cpath, err := GetCgroupPathInfo("pids", cpath, false)
+	if err != nil {
+		return 0, err
+	}
+	b, err := ioutil.ReadFile(path.Join(cpath, "pids.current"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// 读取cgroup中所有进程的pid
+func GetPids(cpath string) ([]int, error) {
+	cpath, err := GetCgroupPathInfo("pids", cpath, false)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path.Join(cpath, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, line := range strings.Fields(string(b)) {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, err
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
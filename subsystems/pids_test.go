@@ -0,0 +1,28 @@
+package subsystems
+
+import (
+	"testing"
+)
+
+func TestPidsSet(t *testing.T) {
+	pids := &PidsSubsystem{}
+	root, cleanup := fakeCgroup1Root(t)
+	defer cleanup()
+	if err := pids.Set("mydocker/c1", &ResourceConfig{PidsLimit: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pids.Set("mydocker/c2", &ResourceConfig{PidsLimit: -1}); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, root, map[string]string{
+		"pids/mydocker/c1/pids.max": "100",
+		"pids/mydocker/c2/pids.max": "max",
+	})
+
+	root2, cleanup2 := fakeCgroup2Root(t)
+	defer cleanup2()
+	if err := pids.Set("mydocker/c1", &ResourceConfig{PidsLimit: 100}); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, root2, map[string]string{"mydocker/c1/pids.max": "100"})
+}
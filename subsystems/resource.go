@@ -0,0 +1,265 @@
+package subsystems
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// 和docker一致，内存限制最少6MB
+	MinMemory    = 6 * 1024 * 1024
+	MinCpuShares = 2
+	MaxCpuShares = 262144
+	// CFS周期的默认值和允许的范围，单位微秒
+	DefaultCpuPeriod = 100000
+	MinCpuPeriod     = 1000
+	MaxCpuPeriod     = 1000000
+	MinCpuQuota      = 1000
+)
+
+var (
+	cpuOnlinePath = "/sys/devices/system/cpu/online"
+	sizeRegexp    = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([kKmMgGtTpP]?)[iI]?[bB]?$`)
+	unitMap       = map[string]int64{
+		"":  1,
+		"k": 1 << 10,
+		"m": 1 << 20,
+		"g": 1 << 30,
+		"t": 1 << 40,
+		"p": 1 << 50,
+	}
+)
+
+// 解析 512m、1g、1.5GiB 这种带单位的大小，单位按1024进位
+func ParseBytes(size string) (int64, error) {
+	matches := sizeRegexp.FindStringSubmatch(strings.TrimSpace(size))
+	if matches == nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	num, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	bytes := num * float64(unitMap[strings.ToLower(matches[2])])
+	if bytes >= 1<<63 {
+		return 0, fmt.Errorf("size %q is too large", size)
+	}
+	return int64(bytes), nil
+}
+
+func ValidateMemory(memory int64) error {
+	if memory != 0 && memory < MinMemory {
+		return fmt.Errorf("minimum memory limit allowed is 6MB")
+	}
+	return nil
+}
+
+// memory-swap是内存和swap的总和，不能小于内存限制
+func ValidateMemorySwap(memory, swap int64) error {
+	if memory == 0 {
+		return fmt.Errorf("memory limit should be set together with memory swap limit")
+	}
+	if swap != -1 && swap < memory {
+		return fmt.Errorf("memory swap limit should be larger than memory limit")
+	}
+	if !swapAccountingSupported() {
+		return fmt.Errorf("the kernel does not support swap accounting, enable it with swapaccount=1")
+	}
+	return nil
+}
+
+func ValidateMemoryReservation(memory, reservation int64) error {
+	if memory != 0 && reservation > memory {
+		return fmt.Errorf("memory reservation should be smaller than memory limit")
+	}
+	return nil
+}
+
+func ValidateMemorySwappiness(swappiness int) error {
+	if swappiness < 0 || swappiness > 100 {
+		return fmt.Errorf("memory swappiness should be in range [0, 100]")
+	}
+	if IsCgroup2() {
+		return fmt.Errorf("memory swappiness is not supported on cgroup v2")
+	}
+	return nil
+}
+
+func ValidateKernelMemory(kernelMemory int64) error {
+	if kernelMemory != 0 && kernelMemory < MinMemory {
+		return fmt.Errorf("minimum kernel memory limit allowed is 6MB")
+	}
+	if !memoryFileSupported("memory.kmem.limit_in_bytes") {
+		return fmt.Errorf("kernel memory limit is not supported by the kernel")
+	}
+	return nil
+}
+
+func ValidateOomKillDisable() error {
+	if !memoryFileSupported("memory.oom_control") {
+		return fmt.Errorf("disabling OOM killer is not supported by the kernel")
+	}
+	return nil
+}
+
+// v1中检查memory根cgroup中是否有对应的文件，v2中这些v1的功能都不支持
+func memoryFileSupported(file string) bool {
+	if IsCgroup2() {
+		return false
+	}
+	root, err := findCgroupPathInfo("memory")
+	if err != nil || root == "" {
+		return false
+	}
+	_, err = os.Stat(path.Join(root, file))
+	return err == nil
+}
+
+// v1中开启swap记账才有memsw文件，v2中由memory.swap.max控制
+func swapAccountingSupported() bool {
+	if IsCgroup2() {
+		return cgroup2SwapSupported()
+	}
+	return memoryFileSupported("memory.memsw.limit_in_bytes")
+}
+
+// 真正的根cgroup中没有memory.swap.max，先检查容器的父cgroup。父cgroup还没有开启memory控制器时，
+// 开启了memory控制器的其他子cgroup也能说明是否开启了swap记账；在cgroup命名空间中挂载点本身就不是根cgroup
+func cgroup2SwapSupported() bool {
+	dirs := []string{path.Join(unifiedMountpoint, CgroupParent)}
+	if controllerEnabled(unifiedMountpoint, "memory") {
+		fileList, _ := ioutil.ReadDir(unifiedMountpoint)
+		for _, fileInfo := range fileList {
+			if fileInfo.IsDir() && fileInfo.Name() != CgroupParent {
+				dirs = append(dirs, path.Join(unifiedMountpoint, fileInfo.Name()))
+			}
+		}
+	}
+	dirs = append(dirs, unifiedMountpoint)
+	for _, dir := range dirs {
+		if _, err := os.Stat(path.Join(dir, "memory.max")); err != nil {
+			continue
+		}
+		_, err := os.Stat(path.Join(dir, "memory.swap.max"))
+		return err == nil
+	}
+	return false
+}
+
+func ValidateCpuShares(shares uint64) error {
+	if shares != 0 && (shares < MinCpuShares || shares > MaxCpuShares) {
+		return fmt.Errorf("cpu shares should be in range [%d, %d]", MinCpuShares, MaxCpuShares)
+	}
+	return nil
+}
+
+// 解析 0-2,4 这种CPU列表，返回排好序的CPU编号
+func ParseCpuList(list string) ([]int, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(strings.TrimSpace(list), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid cpu list %q", list)
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = strconv.Atoi(bounds[1]); err != nil || end < start {
+				return nil, fmt.Errorf("invalid cpu list %q", list)
+			}
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			set[cpu] = true
+		}
+	}
+	cpus := make([]int, 0, len(set))
+	for cpu := range set {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// 检查CPU列表的格式，并且所有CPU都在线
+func ValidateCpuSet(list string) error {
+	if list == "" {
+		return nil
+	}
+	cpus, err := ParseCpuList(list)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(cpuOnlinePath)
+	if err != nil {
+		return err
+	}
+	online, err := ParseCpuList(string(b))
+	if err != nil {
+		return err
+	}
+	onlineSet := make(map[int]bool)
+	for _, cpu := range online {
+		onlineSet[cpu] = true
+	}
+	for _, cpu := range cpus {
+		if !onlineSet[cpu] {
+			return fmt.Errorf("cpu %d is not available, online cpus are %s", cpu, strings.TrimSpace(string(b)))
+		}
+	}
+	return nil
+}
+
+// 在线的CPU个数
+func OnlineCpuCount() (int, error) {
+	b, err := ioutil.ReadFile(cpuOnlinePath)
+	if err != nil {
+		return 0, err
+	}
+	online, err := ParseCpuList(string(b))
+	if err != nil {
+		return 0, err
+	}
+	return len(online), nil
+}
+
+// 解析 1.5 这样的CPU个数，返回乘以1e9的整数
+func ParseCpus(cpus string) (int64, error) {
+	value, err := strconv.ParseFloat(cpus, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cpus %q", cpus)
+	}
+	nano := int64(value * 1e9)
+	count, err := OnlineCpuCount()
+	if err != nil {
+		return 0, err
+	}
+	if nano < 1e7 || nano > int64(count)*1e9 {
+		return 0, fmt.Errorf("range of cpus is from 0.01 to %d.00, as there are only %d cpus available", count, count)
+	}
+	return nano, nil
+}
+
+// 按默认周期把CPU个数换算成CFS配额
+func NanoCpusToQuota(nanoCpus int64) (period uint64, quota int64) {
+	return DefaultCpuPeriod, nanoCpus * DefaultCpuPeriod / 1e9
+}
+
+func ValidateCpuPeriod(period uint64) error {
+	if period != 0 && (period < MinCpuPeriod || period > MaxCpuPeriod) {
+		return fmt.Errorf("cpu period should be in range [%d, %d]", MinCpuPeriod, MaxCpuPeriod)
+	}
+	return nil
+}
+
+func ValidateCpuQuota(quota int64) error {
+	if quota != 0 && quota != -1 && quota < MinCpuQuota {
+		return fmt.Errorf("cpu quota should be -1 or at least %d", MinCpuQuota)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package subsystems
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"1024", 1024, false},
+		{"512k", 512 << 10, false},
+		{"512m", 512 << 20, false},
+		{"512M", 512 << 20, false},
+		{"1g", 1 << 30, false},
+		{"1.5GiB", 3 << 29, false},
+		{"2gb", 2 << 30, false},
+		{"1 t", 1 << 40, false},
+		{" 100m ", 100 << 20, false},
+		{"", 0, true},
+		{"m", 0, true},
+		{"-1m", 0, true},
+		{"1x", 0, true},
+		{"1.5.2m", 0, true},
+		{"99999999p", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBytes(tt.size)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseBytes(%q) = %d, %v, want %d", tt.size, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseCpuList(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []int
+		wantErr bool
+	}{
+		{"0", []int{0}, false},
+		{"0-3", []int{0, 1, 2, 3}, false},
+		{"0-2,4", []int{0, 1, 2, 4}, false},
+		{"4,0-1,1", []int{0, 1, 4}, false},
+		{"0-3\n", []int{0, 1, 2, 3}, false},
+		{"", nil, true},
+		{"a", nil, true},
+		{"-1", nil, true},
+		{"3-1", nil, true},
+		{"0-", nil, true},
+		{"0,,1", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCpuList(tt.list)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("ParseCpuList(%q) = %v, %v, want %v", tt.list, got, err, tt.want)
+		}
+	}
+}
+
+// 用普通文件模拟在线的CPU
+func TestValidateCpuSet(t *testing.T) {
+	f, err := ioutil.TempFile("", "online")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("0-3\n")
+	f.Close()
+	saved := cpuOnlinePath
+	cpuOnlinePath = f.Name()
+	defer func() { cpuOnlinePath = saved }()
+	for list, wantErr := range map[string]bool{
+		"":    false,
+		"0-3": false,
+		"1,3": false,
+		"4":   true,
+		"2-5": true,
+		"x":   true,
+	} {
+		if err := ValidateCpuSet(list); (err != nil) != wantErr {
+			t.Errorf("ValidateCpuSet(%q) = %v, want error %v", list, err, wantErr)
+		}
+	}
+}
+
+// 用普通目录模拟v2的层级，以/结尾的是目录，其他的是空文件，cgroup.subtree_control中开启memory
+func TestCgroup2SwapSupported(t *testing.T) {
+	IsCgroup2()
+	savedMountpoint, savedUnified := unifiedMountpoint, isUnified
+	defer func() { unifiedMountpoint, isUnified = savedMountpoint, savedUnified }()
+	isUnified = true
+	tests := []struct {
+		name  string
+		files []string
+		want  bool
+	}{
+		{"parent with swap", []string{"mydocker/memory.max", "mydocker/memory.swap.max"}, true},
+		{"parent without swap", []string{"mydocker/memory.max", "init.scope/memory.max", "init.scope/memory.swap.max", "cgroup.subtree_control"}, false},
+		{"no parent, sibling with swap", []string{"cgroup.subtree_control", "init.scope/memory.max", "init.scope/memory.swap.max"}, true},
+		{"no parent, sibling without swap", []string{"cgroup.subtree_control", "init.scope/memory.max"}, false},
+		{"memory not delegated", []string{"init.scope/memory.max", "init.scope/memory.swap.max"}, false},
+		{"parent without memory", []string{"mydocker/", "cgroup.subtree_control", "system.slice/memory.max", "system.slice/memory.swap.max"}, true},
+		{"namespaced root", []string{"memory.max", "memory.swap.max"}, true},
+		{"nothing", nil, false},
+	}
+	for _, tt := range tests {
+		root, err := ioutil.TempDir("", "cgroup2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range tt.files {
+			name := path.Join(root, file)
+			if strings.HasSuffix(file, "/") {
+				err = os.MkdirAll(name, 0755)
+			} else if err = os.MkdirAll(path.Dir(name), 0755); err == nil {
+				content := ""
+				if path.Base(file) == "cgroup.subtree_control" {
+					content = "cpu memory pids"
+				}
+				err = ioutil.WriteFile(name, []byte(content), 0644)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		unifiedMountpoint = root
+		if got := swapAccountingSupported(); got != tt.want {
+			t.Errorf("%s: swapAccountingSupported() = %v, want %v", tt.name, got, tt.want)
+		}
+		os.RemoveAll(root)
+	}
+}
@@ -0,0 +1,171 @@
+package subsystems
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// 从cgroup中读取的资源使用量
+type Stats struct {
+	// 内存使用量、限制和其中的page cache，单位字节
+	MemoryUsage uint64 `json:"memoryUsage"`
+	MemoryLimit uint64 `json:"memoryLimit"`
+	MemoryCache uint64 `json:"memoryCache"`
+	// 累计使用的CPU时间，单位纳秒
+	CpuUsage uint64 `json:"cpuUsage"`
+	Pids     uint64 `json:"pids"`
+	// 块设备累计读写的字节数
+	BlkioRead  uint64 `json:"blkioRead"`
+	BlkioWrite uint64 `json:"blkioWrite"`
+}
+
+// 读取cgroup的资源使用量
+func GetStats(cpath string) (*Stats, error) {
+	stats := &Stats{}
+	if err := readMemoryStats(cpath, stats); err != nil {
+		return nil, err
+	}
+	if err := readCpuStats(cpath, stats); err != nil {
+		return nil, err
+	}
+	// v2中子树没有开启io控制器或者v1中没有挂载blkio时，只是没有块设备的统计
+	if err := readBlkioStats(cpath, stats); err != nil {
+		stats.BlkioRead, stats.BlkioWrite = 0, 0
+	}
+	stats.Pids, _ = GetPidsCurrent(cpath)
+	return stats, nil
+}
+
+func readMemoryStats(cpath string, stats *Stats) error {
+	cpath, err := GetCgroupPathInfo("memory", cpath, false)
+	if err != nil {
+		return err
+	}
+	usageFile, limitFile, cacheKey := "memory.usage_in_bytes", "memory.limit_in_bytes", "total_cache"
+	if IsCgroup2() {
+		usageFile, limitFile, cacheKey = "memory.current", "memory.max", "file"
+	}
+	if stats.MemoryUsage, err = readCgroupUint(path.Join(cpath, usageFile)); err != nil {
+		return err
+	}
+	if stats.MemoryLimit, err = readCgroupUint(path.Join(cpath, limitFile)); err != nil {
+		return err
+	}
+	values, err := readKeyValues(path.Join(cpath, "memory.stat"))
+	if err != nil {
+		return err
+	}
+	stats.MemoryCache = values[cacheKey]
+	return nil
+}
+
+func readCpuStats(cpath string, stats *Stats) error {
+	if IsCgroup2() {
+		cpath, err := GetCgroupPathInfo("cpu", cpath, false)
+		if err != nil {
+			return err
+		}
+		values, err := readKeyValues(path.Join(cpath, "cpu.stat"))
+		if err != nil {
+			return err
+		}
+		stats.CpuUsage = values["usage_usec"] * 1000
+		return nil
+	}
+	cpath, err := GetCgroupPathInfo("cpuacct", cpath, false)
+	if err != nil {
+		return err
+	}
+	stats.CpuUsage, err = readCgroupUint(path.Join(cpath, "cpuacct.usage"))
+	return err
+}
+
+// v1读blkio.throttle.io_service_bytes，每行是"8:0 Read 4096"；
+// v2读io.stat，每行是"8:0 rbytes=4096 wbytes=0 ..."
+func readBlkioStats(cpath string, stats *Stats) error {
+	cpath, err := GetCgroupPathInfo("blkio", cpath, false)
+	if err != nil {
+		return err
+	}
+	file := "blkio.throttle.io_service_bytes"
+	if IsCgroup2() {
+		file = "io.stat"
+	}
+	f, err := os.Open(path.Join(cpath, file))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if IsCgroup2() {
+			for _, field := range fields[1:] {
+				kv := strings.SplitN(field, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				value, _ := strconv.ParseUint(kv[1], 10, 64)
+				switch kv[0] {
+				case "rbytes":
+					stats.BlkioRead += value
+				case "wbytes":
+					stats.BlkioWrite += value
+				}
+			}
+			continue
+		}
+		if len(fields) != 3 {
+			continue
+		}
+		value, _ := strconv.ParseUint(fields[2], 10, 64)
+		switch fields[1] {
+		case "Read":
+			stats.BlkioRead += value
+		case "Write":
+			stats.BlkioWrite += value
+		}
+	}
+	return scanner.Err()
+}
+
+// 读取一个数值，v2中的max表示不限制，返回0
+func readCgroupUint(file string) (uint64, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(b))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// 读取memory.stat、cpu.stat这种每行"key value"的文件
+func readKeyValues(file string) (map[string]uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = value
+		}
+	}
+	return values, scanner.Err()
+}
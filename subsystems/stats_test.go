@@ -0,0 +1,46 @@
+package subsystems
+
+import (
+	"testing"
+)
+
+func TestGetStats(t *testing.T) {
+	files := map[string]string{
+		"mydocker/c1/memory.current": "4096\n",
+		"mydocker/c1/memory.max":     "max\n",
+		"mydocker/c1/memory.stat":    "anon 1024\nfile 2048\n",
+		"mydocker/c1/cpu.stat":       "usage_usec 150\nuser_usec 100\n",
+		"mydocker/c1/pids.current":   "3\n",
+		"mydocker/c1/io.stat":        "8:0 rbytes=100 wbytes=200 rios=1 wios=2\n\n8:16 rbytes=1 wbytes=2\n",
+	}
+	stats := func(files map[string]string) (*Stats, error) {
+		root, cleanup := fakeCgroup2Root(t)
+		defer cleanup()
+		writeFiles(t, root, files)
+		return GetStats("mydocker/c1")
+	}
+	want := Stats{MemoryUsage: 4096, MemoryCache: 2048, CpuUsage: 150000, Pids: 3, BlkioRead: 101, BlkioWrite: 202}
+	if got, err := stats(files); err != nil || *got != want {
+		t.Errorf("GetStats() = %+v, %v, want %+v", got, err, want)
+	}
+
+	// 没有开启io控制器时只是没有块设备的统计
+	delete(files, "mydocker/c1/io.stat")
+	want.BlkioRead, want.BlkioWrite = 0, 0
+	if got, err := stats(files); err != nil || *got != want {
+		t.Errorf("GetStats() without io.stat = %+v, %v, want %+v", got, err, want)
+	}
+
+	// 读不到内存或CPU时整个采样失败
+	for _, missing := range []string{"mydocker/c1/memory.current", "mydocker/c1/cpu.stat"} {
+		partial := make(map[string]string)
+		for name, content := range files {
+			if name != missing {
+				partial[name] = content
+			}
+		}
+		if _, err := stats(partial); err == nil {
+			t.Errorf("GetStats() without %s succeeded", missing)
+		}
+	}
+}
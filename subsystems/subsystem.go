@@ -2,16 +2,27 @@ package subsystems
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 )
 
+// 容器的cgroup默认创建在这个cgroup下
+const CgroupParent = "mydocker"
+
 var subsystems = []SubSystem{
 	&MemorySubsystem{},
 	&CpuSubsystem{},
+	&CpuacctSubsystem{},
 	&CpuSetSubsystem{},
+	&PidsSubsystem{},
+	&BlkioSubsystem{},
+	&DevicesSubsystem{},
+	&FreezerSubsystem{},
 }
 
 type SubSystem interface {
@@ -22,9 +33,35 @@ type SubSystem interface {
 }
 
 type ResourceConfig struct {
-	MemoryLimit string
-	CpuShare    string
-	CpuSet      string
+	// 内存限制，单位字节，0表示不限制
+	Memory int64 `json:"memory"`
+	// 内存加swap的总限制，-1表示不限制swap
+	MemorySwap int64 `json:"memorySwap"`
+	// 内存软限制
+	MemoryReservation int64 `json:"memoryReservation"`
+	// 为空表示使用系统默认值
+	MemorySwappiness *uint64 `json:"memorySwappiness"`
+	KernelMemory     int64   `json:"kernelMemory"`
+	OomKillDisable   bool    `json:"oomKillDisable"`
+	// cpu.shares，CPU的相对权重
+	CpuShares uint64 `json:"cpuShares"`
+	// CFS带宽控制，每个周期内最多使用配额的CPU时间，单位微秒，配额为-1表示不限制
+	CpuPeriod uint64 `json:"cpuPeriod"`
+	CpuQuota  int64  `json:"cpuQuota"`
+	// --cpus指定的CPU个数，乘以1e9保存
+	NanoCpus int64 `json:"nanoCpus"`
+	// 允许使用的CPU列表，例如 0-2,4
+	CpusetCpus string `json:"cpusetCpus"`
+	// 最大进程数，-1表示不限制
+	PidsLimit int64 `json:"pidsLimit"`
+	// 块设备IO的相对权重和按设备的限速
+	BlkioWeight          uint16            `json:"blkioWeight"`
+	BlkioDeviceReadBps   []*ThrottleDevice `json:"blkioDeviceReadBps"`
+	BlkioDeviceWriteBps  []*ThrottleDevice `json:"blkioDeviceWriteBps"`
+	BlkioDeviceReadIOps  []*ThrottleDevice `json:"blkioDeviceReadIOps"`
+	BlkioDeviceWriteIOps []*ThrottleDevice `json:"blkioDeviceWriteIOps"`
+	// 在默认设备之外额外允许访问的设备
+	Devices []*DeviceRule `json:"devices"`
 }
 
 type CgroupManager struct {
@@ -40,24 +77,80 @@ func NewCgroupManager(cpath string) *CgroupManager {
 
 func (c *CgroupManager) Apply(pid int) error {
 	for _, subsystem := range subsystems {
-		if err := subsystem.Apply(c.Path, pid); err != nil {
+		ok, err := checkAvailable(subsystem, c.Config)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err = subsystem.Apply(c.Path, pid); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// 设置资源限制，记录的配置在Apply时用来判断缺少的子系统是否可以跳过
 func (c *CgroupManager) Set(config *ResourceConfig) error {
+	c.Config = config
+	if config == nil {
+		return nil
+	}
+	for _, subsystem := range subsystems {
+		ok, err := checkAvailable(subsystem, config)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err = subsystem.Set(c.Path, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 修改运行中容器的限制，只写入config中设置了的项
+// 设备规则需要先全部禁止再逐条允许，重新写入时容器会短暂无法访问设备，所以不修改
+func (c *CgroupManager) Update(config *ResourceConfig) error {
 	for _, subsystem := range subsystems {
-		if err := subsystem.Set(c.Path, config); err != nil {
+		if _, ok := subsystem.(*DevicesSubsystem); ok {
+			continue
+		}
+		ok, err := checkAvailable(subsystem, config)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err = subsystem.Set(c.Path, config); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// 任意一个子系统中存在cgroup目录
+func (c *CgroupManager) Exists() bool {
+	if c.Path == "" {
+		return false
+	}
+	for _, subsystem := range subsystems {
+		if _, err := GetCgroupPathInfo(subsystem.Name(), c.Path, false); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *CgroupManager) Destroy() error {
 	for _, subsystem := range subsystems {
+		if !subsystemAvailable(subsystem.Name()) {
+			continue
+		}
 		if err := subsystem.Remove(c.Path); err != nil {
 			return err
 		}
@@ -65,7 +158,64 @@ func (c *CgroupManager) Destroy() error {
 	return nil
 }
 
+// v1中子系统对应的层级可能没有挂载，v2中所有控制器在同一个层级
+func subsystemAvailable(subsystem string) bool {
+	if IsCgroup2() {
+		return true
+	}
+	mountpoint, _ := findCgroupPathInfo(subsystem)
+	return mountpoint != ""
+}
+
+// 是否设置了子系统对应的限制
+func limitRequested(subsystem string, config *ResourceConfig) bool {
+	if config == nil {
+		return false
+	}
+	switch subsystem {
+	case "memory":
+		return config.Memory != 0 || config.MemorySwap != 0 || config.MemoryReservation != 0 ||
+			config.MemorySwappiness != nil || config.KernelMemory != 0 || config.OomKillDisable
+	case "cpu":
+		return config.CpuShares != 0 || config.CpuQuota != 0 || config.CpuPeriod != 0
+	case "cpuset":
+		return config.CpusetCpus != ""
+	case "pids":
+		return config.PidsLimit != 0
+	case "blkio":
+		return config.BlkioWeight != 0 || len(config.BlkioDeviceReadBps) != 0 || len(config.BlkioDeviceWriteBps) != 0 ||
+			len(config.BlkioDeviceReadIOps) != 0 || len(config.BlkioDeviceWriteIOps) != 0
+	case "devices":
+		return len(config.Devices) != 0
+	}
+	return false
+}
+
+// 子系统不可用时，没有设置对应的限制就跳过，设置了限制时报错
+func checkAvailable(subsystem SubSystem, config *ResourceConfig) (bool, error) {
+	if subsystemAvailable(subsystem.Name()) {
+		return true, nil
+	}
+	if limitRequested(subsystem.Name(), config) {
+		return false, fmt.Errorf("%s cgroup controller not available on this host", subsystem.Name())
+	}
+	return false, nil
+}
+
+// 检查设置的限制对应的子系统是否都可用
+func CheckAvailable(config *ResourceConfig) error {
+	for _, subsystem := range subsystems {
+		if _, err := checkAvailable(subsystem, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func GetCgroupPathInfo(subsystem string, cgroupRoot string, autoCreate bool) (string, error) {
+	if IsCgroup2() {
+		return getCgroup2Path(subsystem, cgroupRoot, autoCreate)
+	}
 	cpath, err := findCgroupPathInfo(subsystem)
 	if cpath == "" {
 		return "", fmt.Errorf("can not found %s cgroup", subsystem)
@@ -76,17 +226,44 @@ func GetCgroupPathInfo(subsystem string, cgroupRoot string, autoCreate bool) (st
 	fullPath := path.Join(cpath, cgroupRoot)
 	if _, err = os.Stat(fullPath); err == nil || (autoCreate && os.IsNotExist(err)) {
 		if os.IsNotExist(err) {
-			if err = os.Mkdir(fullPath, 0755); err != nil {
+			if err = os.MkdirAll(fullPath, 0755); err != nil {
 				return "", err
 			}
 		}
 		return fullPath, nil
 	}
-	return "", fmt.Errorf("cpath err:%s", err.Error())
+	return "", fmt.Errorf("cpath err:%w", err)
+}
+
+// 把进程加入cgroup，v1写tasks，v2写cgroup.procs
+func applyPid(subsystem, cgroupRoot string, pid int) error {
+	cpath, err := GetCgroupPathInfo(subsystem, cgroupRoot, true)
+	if err != nil {
+		return err
+	}
+	procsFile := "tasks"
+	if IsCgroup2() {
+		procsFile = "cgroup.procs"
+	}
+	return ioutil.WriteFile(path.Join(cpath, procsFile), []byte(strconv.Itoa(pid)), 0644)
 }
 
+// 删除cgroup，已经不存在时忽略，v2中多个子系统共用目录所以会被删除多次
+func removeCgroup(subsystem, cgroupRoot string) error {
+	cpath, err := GetCgroupPathInfo(subsystem, cgroupRoot, false)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return os.RemoveAll(cpath)
+}
+
+var mountinfoPath = "/proc/self/mountinfo"
+
 func findCgroupPathInfo(subsystem string) (path string, err error) {
-	f, err := os.Open("/proc/self/mountinfo")
+	f, err := os.Open(mountinfoPath)
 	if err != nil {
 		return
 	}
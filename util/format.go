@@ -0,0 +1,88 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"text/template"
+)
+
+// 模板中可以使用 {{json .State}} 输出json
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	},
+}
+
+func ParseTemplate(format string) (*template.Template, error) {
+	tmpl, err := template.New("format").Funcs(templateFuncs).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid format %q: %s", format, err.Error())
+	}
+	return tmpl, nil
+}
+
+// 没有指定format时输出缩进的json，否则按照go模板输出
+func FormatOutput(w io.Writer, v interface{}, format string) error {
+	if format == "" {
+		b, err := json.MarshalIndent(v, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+	tmpl, err := ParseTemplate(format)
+	if err != nil {
+		return err
+	}
+	if err = tmpl.Execute(w, v); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w)
+	return err
+}
+
+// 按行输出列表list，quiet时每行输出id(i)，format为json时每行输出一个json，否则按go模板输出。
+// 返回false表示不是这几种输出方式，由调用者输出表格
+func FormatList(w io.Writer, list interface{}, format string, quiet bool, id func(i int) string) (bool, error) {
+	items := reflect.ValueOf(list)
+	switch {
+	case quiet:
+		for i := 0; i < items.Len(); i++ {
+			if _, err := fmt.Fprintln(w, id(i)); err != nil {
+				return true, err
+			}
+		}
+		return true, nil
+	case format == "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		for i := 0; i < items.Len(); i++ {
+			if err := encoder.Encode(items.Index(i).Interface()); err != nil {
+				return true, err
+			}
+		}
+		return true, nil
+	case format != "":
+		tmpl, err := ParseTemplate(format)
+		if err != nil {
+			return true, err
+		}
+		for i := 0; i < items.Len(); i++ {
+			if err = tmpl.Execute(w, items.Index(i).Interface()); err != nil {
+				return true, err
+			}
+			if _, err = fmt.Fprintln(w); err != nil {
+				return true, err
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,38 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFormatList(t *testing.T) {
+	type item struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}
+	list := []*item{{"a1", "web"}, {"b2", "db<1>"}}
+	id := func(i int) string { return list[i].Id }
+	tests := []struct {
+		format  string
+		quiet   bool
+		done    bool
+		want    string
+		wantErr bool
+	}{
+		{"", false, false, "", false},
+		{"", true, true, "a1\nb2\n", false},
+		{"{{.Name}}", true, true, "a1\nb2\n", false},
+		{"json", false, true, `{"id":"a1","name":"web"}` + "\n" + `{"id":"b2","name":"db<1>"}` + "\n", false},
+		{"{{.Id}}:{{.Name}}", false, true, "a1:web\nb2:db<1>\n", false},
+		{"{{json .Name}}", false, true, "\"web\"\n\"db\\u003c1\\u003e\"\n", false},
+		{"{{.Id", false, true, "", true},
+		{"{{.Missing}}", false, true, "", true},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		done, err := FormatList(&buf, list, tt.format, tt.quiet, id)
+		if done != tt.done || (err != nil) != tt.wantErr || (!tt.wantErr && buf.String() != tt.want) {
+			t.Errorf("FormatList(%q, %v) = %v, %v, %q, want %v, %q", tt.format, tt.quiet, done, err, buf.String(), tt.done, tt.want)
+		}
+	}
+}
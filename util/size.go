@@ -0,0 +1,27 @@
+package util
+
+import "fmt"
+
+var (
+	decimalUnits = []string{"B", "kB", "MB", "GB", "TB", "PB"}
+	binaryUnits  = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+)
+
+// 按1000进制输出可读的大小，例如 1.5MB
+func HumanSize(size float64) string {
+	return formatSize(size, 1000, decimalUnits)
+}
+
+// 按1024进制输出可读的大小，例如 1.5MiB
+func BytesSize(size float64) string {
+	return formatSize(size, 1024, binaryUnits)
+}
+
+func formatSize(size, base float64, units []string) string {
+	i := 0
+	for size >= base && i < len(units)-1 {
+		size /= base
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", size, units[i])
+}
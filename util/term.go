@@ -0,0 +1,36 @@
+package util
+
+import (
+	"bufio"
+	"golang.org/x/sys/unix"
+	"os"
+	"strings"
+)
+
+// 从终端读取一行，读取期间关闭回显，用于输入密码
+// 多次读取要使用同一个r，否则前一次读取时缓存的内容会丢失
+func ReadPassword(f *os.File, r *bufio.Reader) (string, error) {
+	fd := int(f.Fd())
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		// 不是终端时直接读取
+		return ReadLine(r)
+	}
+	noEcho := *termios
+	noEcho.Lflag &^= unix.ECHO
+	noEcho.Lflag |= unix.ICANON | unix.ISIG
+	if err = unix.IoctlSetTermios(fd, unix.TCSETS, &noEcho); err != nil {
+		return "", err
+	}
+	defer unix.IoctlSetTermios(fd, unix.TCSETS, termios)
+	return ReadLine(r)
+}
+
+// 读取一行，去掉结尾的换行
+func ReadLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}